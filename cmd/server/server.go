@@ -7,16 +7,49 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
 
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/counter"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/journal"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/limiter"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/resp"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/store"
 	"github.com/stiflerGit/simpleinsurance-assessment/pkg/server"
 )
 
 var ( // flags
 	port            = flag.Int("port", 8080, "port on which start the server")
 	persistenceFile = flag.String("persistence", "", "path of the file to read/write state")
+	storeKind       = flag.String("store", "fs", "store of the state: fs, a file per state in the persistence dir, kv, a single file in it, or memory")
 	limit           = flag.Int64("limit", 15, "limit max number of request to N each 20 seconds")
+	window          = flag.Duration("window", 60*time.Second, "window of the requests counter")
+	resolution      = flag.Uint64("resolution", 1000, "number of ticks per window of the requests counter")
+	counterKind     = flag.String("counter-kind", "ring", "kind of the requests counter: ring, sharded, log or weighted")
+	limiterKind     = flag.String("limiter-kind", "ring", "kind of the per IP limiter counters: ring, sharded, log or weighted")
+	algorithm       = flag.String("limiter-algorithm", "window", "algorithm of the per IP limiters: window, token_bucket or gcra")
+	burst           = flag.Int64("burst", 0, "requests allowed at once by the token_bucket and gcra limiters after being idle. Equal to the limit if 0")
+	idleTTL         = flag.Duration("limiter-idle-ttl", 0, "time after which the limiter of an IP whose window has emptied is evicted. Never if 0")
+	maxKeys         = flag.Int("limiter-max-keys", 0, "maximum number of IPs with a limiter, the least recently used are evicted over it. Unbounded if 0")
+	journalSync     = flag.String("journal", "", "journal the increases between the saves, syncing it: always, batch or none. Disabled if empty")
+	shutdownTimeout = flag.Duration("shutdown-timeout", 10*time.Second, "time given on SIGINT or SIGTERM to drain the requests in flight and save the state")
+	redisAddr       = flag.String("redis", "", "address of a Redis compatible server keeping the per IP limits shared among the instances. In memory if empty")
+	redisResolution = flag.Uint64("redis-resolution", 0, "number of buckets per window of the per IP limits on the redis server, 1000 to match the in memory ones. 20 if 0")
 )
 
+const (
+	defaultPersistenceDir = "persistence"
+	kvFileName            = "state.kv"
+)
+
+var journalSyncs = map[string]journal.Sync{
+	"always": journal.SyncAlways,
+	"batch":  journal.SyncBatch,
+	"none":   journal.SyncNone,
+}
+
 func main() {
 	flag.Parse()
 
@@ -24,11 +57,51 @@ func main() {
 		server.WithLogger(log.New(os.Stderr, "server", log.LstdFlags|log.Lshortfile)),
 	}
 
-	if *persistenceFile != "" {
-		serverOpts = append(serverOpts, server.WithPersistence(*persistenceFile))
+	dir := *persistenceFile
+	if dir == "" {
+		dir = defaultPersistenceDir
+	}
+
+	switch *storeKind {
+	case "fs":
+		serverOpts = append(serverOpts, server.WithStore(store.NewFS(dir)))
+	case "kv":
+		kv, err := store.OpenKV(filepath.Join(dir, kvFileName))
+		if err != nil {
+			log.Fatalf("opening store: %v", err)
+		}
+		defer kv.Close()
+		serverOpts = append(serverOpts, server.WithStore(kv))
+	case "memory":
+		serverOpts = append(serverOpts, server.WithStore(store.NewMemory()))
+	default:
+		log.Fatalf("unknown store %q", *storeKind)
 	}
 
-	serverOpts = append(serverOpts, server.WithPerIPRequestLimiter(*limit))
+	serverOpts = append(serverOpts,
+		server.WithPerIPRequestLimiter(*limit),
+		server.WithCounterKind(counter.Kind(*counterKind)),
+		server.WithCounterWindow(*window, *resolution),
+		server.WithLimiterCounterKind(counter.Kind(*limiterKind)),
+		server.WithLimiterAlgorithm(limiter.Algorithm(*algorithm)),
+		server.WithLimiterBurst(*burst),
+		server.WithLimiterIdleTTL(*idleTTL),
+		server.WithLimiterMaxKeys(*maxKeys),
+	)
+
+	if *redisAddr != "" {
+		client := resp.NewClient(*redisAddr)
+		defer client.Close()
+		serverOpts = append(serverOpts, server.WithRedisLimiter(client), server.WithRedisResolution(*redisResolution))
+	}
+
+	if *journalSync != "" {
+		policy, ok := journalSyncs[*journalSync]
+		if !ok {
+			log.Fatalf("unknown journal sync policy %q", *journalSync)
+		}
+		serverOpts = append(serverOpts, server.WithJournal(policy))
+	}
 
 	myServer, err := server.New(serverOpts...)
 	if err != nil {
@@ -39,9 +112,40 @@ func main() {
 		log.Fatalf("[ERROR] starting the server: %v", err)
 	}
 
-	addr := fmt.Sprintf("localhost:%d", *port)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	log.Printf("starting server on %s", addr)
+	httpServer := &http.Server{
+		Addr:    fmt.Sprintf("localhost:%d", *port),
+		Handler: myServer,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("starting server on %s", httpServer.Addr)
+		serveErr <- httpServer.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		log.Printf("[ERROR] serving: %v", err)
+	case <-ctx.Done():
+		log.Printf("shutting down")
+	}
+	stop()
+
+	shutdownCtx, cancelFunc := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancelFunc()
+
+	// no new connections, the requests in flight are drained
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		log.Printf("[ERROR] shutting down the http server: %v", err)
+	}
+
+	// the counters and the limiter stop and save their state
+	if err := myServer.Shutdown(shutdownCtx); err != nil {
+		log.Printf("[ERROR] shutting down the server: %v", err)
+	}
 
-	log.Println(http.ListenAndServe(addr, myServer))
+	log.Printf("server stopped")
 }
@@ -0,0 +1,49 @@
+package clock
+
+import "time"
+
+// Clock is the source of time used by the rate structures
+//
+// it allows to replace the system clock with a Fake one in tests
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+	After(d time.Duration) <-chan time.Time
+}
+
+// Ticker is the Clock equivalent of time.Ticker
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// New returns the Clock backed by the system time
+func New() Clock {
+	return realClock{}
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+type realTicker struct {
+	t *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t realTicker) Stop() {
+	t.t.Stop()
+}
@@ -0,0 +1,185 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Fake is a Clock that moves only when told to
+//
+// Unlike time.Ticker a fake ticker never drops ticks: Advance blocks until
+// each tick has been received or the ticker has been stopped. This makes
+// tests deterministic, a routine that reads from the ticker has surely
+// handled the tick n once the tick n+1 has been delivered.
+type Fake struct {
+	m       sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+type fakeWaiter struct {
+	deadline time.Time
+	period   time.Duration // zero for one shot waiters
+	c        chan time.Time
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewFake returns a Fake clock set at now
+func NewFake(now time.Time) *Fake {
+	f := &Fake{now: now}
+	f.cond = sync.NewCond(&f.m)
+	return f
+}
+
+// Now returns the fake current time
+func (f *Fake) Now() time.Time {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	return f.now
+}
+
+// NewTicker returns a Ticker that fires each d of fake time
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	w := &fakeWaiter{
+		deadline: f.now.Add(d),
+		period:   d,
+		c:        make(chan time.Time),
+		stop:     make(chan struct{}),
+	}
+	f.addWaiter(w)
+
+	return &fakeTicker{f: f, w: w}
+}
+
+// After returns a channel that receives the fake time once d is elapsed
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	w := &fakeWaiter{
+		deadline: f.now.Add(d),
+		c:        make(chan time.Time, 1),
+		stop:     make(chan struct{}),
+	}
+
+	if d <= 0 {
+		w.c <- f.now
+		return w.c
+	}
+
+	f.addWaiter(w)
+
+	return w.c
+}
+
+// Advance moves the clock forward by d, firing every ticker and
+// After channel whose deadline falls in the period
+func (f *Fake) Advance(d time.Duration) {
+	f.m.Lock()
+	target := f.now.Add(d)
+	f.m.Unlock()
+
+	f.Set(target)
+}
+
+// Set moves the clock to t, firing every ticker and After channel
+// whose deadline falls before t. Moving the clock backward fires nothing.
+func (f *Fake) Set(t time.Time) {
+	for {
+		f.m.Lock()
+		w := f.nextWaiter(t)
+		if w == nil {
+			f.now = t
+			f.m.Unlock()
+			return
+		}
+
+		if w.deadline.After(f.now) {
+			f.now = w.deadline
+		}
+		now := f.now
+
+		if w.period > 0 {
+			w.deadline = w.deadline.Add(w.period)
+		} else {
+			f.removeWaiter(w)
+		}
+		f.m.Unlock()
+
+		// the lock is released, the receiver may need the clock
+		select {
+		case w.c <- now:
+		case <-w.stop:
+		}
+	}
+}
+
+// BlockUntil blocks until n tickers or After channels are waiting on the clock
+//
+// it is useful to wait for a routine to set up its tickers before advancing the clock
+func (f *Fake) BlockUntil(n int) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	for len(f.waiters) < n {
+		f.cond.Wait()
+	}
+}
+
+// nextWaiter returns the waiter with the earliest deadline not after t
+func (f *Fake) nextWaiter(t time.Time) *fakeWaiter {
+	var next *fakeWaiter
+	for _, w := range f.waiters {
+		if w.deadline.After(t) {
+			continue
+		}
+		if next == nil || w.deadline.Before(next.deadline) {
+			next = w
+		}
+	}
+	return next
+}
+
+func (f *Fake) addWaiter(w *fakeWaiter) {
+	f.waiters = append(f.waiters, w)
+	f.cond.Broadcast()
+}
+
+func (f *Fake) removeWaiter(w *fakeWaiter) {
+	for i := range f.waiters {
+		if f.waiters[i] == w {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			break
+		}
+	}
+	f.cond.Broadcast()
+}
+
+type fakeTicker struct {
+	f *Fake
+	w *fakeWaiter
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.w.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.w.stopOnce.Do(func() {
+		close(t.w.stop)
+
+		t.f.m.Lock()
+		defer t.f.m.Unlock()
+		t.f.removeWaiter(t.w)
+	})
+}
@@ -0,0 +1,78 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFake_Ticker(t *testing.T) {
+	start := time.Unix(0, 0)
+	clk := NewFake(start)
+	ticker := clk.NewTicker(time.Second)
+
+	got := make(chan time.Time)
+	go func() {
+		defer close(got)
+		for i := 0; i < 3; i++ {
+			got <- <-ticker.C()
+		}
+	}()
+
+	go clk.Advance(3 * time.Second)
+
+	for i := 1; i <= 3; i++ {
+		want := start.Add(time.Duration(i) * time.Second)
+		if tick := <-got; !tick.Equal(want) {
+			t.Errorf("tick %d = %v, want %v", i, tick, want)
+		}
+	}
+
+	ticker.Stop()
+	// a stopped ticker must not block the clock
+	clk.Advance(time.Second)
+
+	if now, want := clk.Now(), start.Add(4*time.Second); !now.Equal(want) {
+		t.Errorf("Now() = %v, want %v", now, want)
+	}
+}
+
+func TestFake_After(t *testing.T) {
+	start := time.Unix(0, 0)
+	clk := NewFake(start)
+
+	c := clk.After(time.Second)
+
+	clk.Advance(999 * time.Millisecond)
+	select {
+	case <-c:
+		t.Fatalf("After() fired before its deadline")
+	default:
+	}
+
+	clk.Advance(time.Millisecond)
+	select {
+	case at := <-c:
+		if want := start.Add(time.Second); !at.Equal(want) {
+			t.Errorf("After() fired at %v, want %v", at, want)
+		}
+	default:
+		t.Fatalf("After() not fired at its deadline")
+	}
+}
+
+func TestFake_SetBackward(t *testing.T) {
+	start := time.Unix(100, 0)
+	clk := NewFake(start)
+	c := clk.After(time.Second)
+
+	clk.Set(start.Add(-time.Hour))
+
+	if now, want := clk.Now(), start.Add(-time.Hour); !now.Equal(want) {
+		t.Errorf("Now() = %v, want %v", now, want)
+	}
+	select {
+	case <-c:
+		t.Fatalf("After() fired moving the clock backward")
+	default:
+	}
+}
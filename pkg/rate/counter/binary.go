@@ -0,0 +1,108 @@
+package counter
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/wire"
+)
+
+// MarshalBinary encodes the Counter in the compact binary format of package wire
+func (c *Counter) MarshalBinary() ([]byte, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.tickless {
+		c.advance()
+	}
+
+	e := wire.NewEncoder()
+	c.encode(e, KindRing)
+
+	return e.Bytes(), nil
+}
+
+// encode must be called with the lock held
+func (c *Counter) encode(e *wire.Encoder, kind Kind) {
+	e.String(string(kind))
+	e.Duration(c.windowDuration)
+	e.Uvarint(c.resolution)
+	e.Varint(c.counter)
+	e.Varint(c.prevCounter)
+	e.Uvarint(uint64(c.head))
+	e.Uvarint(uint64(c.tail))
+	e.Time(c.at)
+	e.Varints(c.counters)
+	e.Uvarint(c.journalSeq)
+}
+
+// UnmarshalBinary decodes a Counter encoded by MarshalBinary, it
+// accepts the Sharded encoding too since they share the format
+func (c *Counter) UnmarshalBinary(bytes []byte) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	d, err := wire.NewDecoder(bytes)
+	if err != nil {
+		return fmt.Errorf("decoding header: %w", err)
+	}
+
+	if kind := Kind(d.String()); kind != KindRing && kind != KindSharded {
+		return fmt.Errorf("decoding %q counter as a %q one", kind, KindRing)
+	}
+
+	return c.decode(d)
+}
+
+// decode must be called with the lock held
+func (c *Counter) decode(d *wire.Decoder) error {
+	windowDuration := d.Duration()
+	resolution := d.Uvarint()
+	counter := d.Varint()
+	prevCounter := d.Varint()
+	head := d.Uvarint()
+	tail := d.Uvarint()
+	at := d.Time()
+	counters := d.Varints()
+
+	// states of version 1 have no journal
+	var journalSeq uint64
+	if d.Version() >= 2 {
+		journalSeq = d.Uvarint()
+	}
+
+	if err := d.Err(); err != nil {
+		return fmt.Errorf("decoding: %w", err)
+	}
+
+	if err := validateState(windowDuration, resolution, len(counters), int(head), int(tail)); err != nil {
+		return err
+	}
+
+	c.windowDuration = windowDuration
+	c.resolution = resolution
+	c.counter = counter
+	c.prevCounter = prevCounter
+	c.head = int(head)
+	c.tail = int(tail)
+	c.at = at
+	c.counters = counters
+	c.journalSeq = journalSeq
+
+	return nil
+}
+
+// validateState returns an error if a decoded window of resolution ticks can not be
+// the one of a Counter, it is shared by the binary and the JSON loaders
+func validateState(windowDuration time.Duration, resolution uint64, counters int, head, tail int) error {
+	if windowDuration <= 0 {
+		return fmt.Errorf("%w: non positive windowDuration %v", ErrInvalidState, windowDuration)
+	}
+	if resolution == 0 || uint64(counters) != resolution {
+		return fmt.Errorf("%w: %d counters for resolution %d", ErrInvalidState, counters, resolution)
+	}
+	if head < 0 || tail < 0 || uint64(head) >= resolution || uint64(tail) >= resolution {
+		return fmt.Errorf("%w: head %d or tail %d out of the buffer of %d counters", ErrInvalidState, head, tail, resolution)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package counter
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/clock"
+)
+
+func TestNewKindFromBytes(t *testing.T) {
+	kinds := []Kind{KindRing, KindSharded, KindLog, KindWeighted}
+	formats := map[string]func(c Interface) ([]byte, error){
+		"binary": func(c Interface) ([]byte, error) { return c.MarshalBinary() },
+		"json":   func(c Interface) ([]byte, error) { return c.MarshalJSON() },
+	}
+
+	for _, kind := range kinds {
+		for name, marshal := range formats {
+			t.Run(string(kind)+"/"+name, func(t *testing.T) {
+				clk := clock.NewFake(time.Unix(1600000000, 0))
+
+				c, err := NewKind(kind, time.Second, 10, WithClock(clk), WithTickless())
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				c.IncreaseBy(3)
+				clk.Advance(300 * time.Millisecond)
+				c.IncreaseBy(2)
+				clk.Advance(200 * time.Millisecond)
+
+				bytes, err := marshal(c)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				restored, err := NewKindFromBytes(bytes, WithClock(clk), WithTickless())
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if got, want := reflect.TypeOf(restored), reflect.TypeOf(c); got != want {
+					t.Errorf("restored type = %v, want %v", got, want)
+				}
+				if got, want := restored.Value(), c.Value(); got != want || got != 5 {
+					t.Errorf("restored Value() = %d, Value() = %d, want 5", got, want)
+				}
+
+				clk.Advance(2 * time.Second)
+				if got := restored.Value(); got != 0 {
+					t.Errorf("after the window restored Value() = %d, want 0", got)
+				}
+			})
+		}
+	}
+}
+
+func TestCounter_BinarySize(t *testing.T) {
+	c := Must(time.Minute, 1000)
+	for i := 0; i < 1000; i++ {
+		c.Increase()
+		c.tick()
+	}
+
+	binary, err := c.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	json, err := c.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(binary) >= len(json)/2 {
+		t.Errorf("binary state is %d bytes, JSON is %d bytes", len(binary), len(json))
+	}
+}
+
+func TestCounter_UnmarshalBinaryErrors(t *testing.T) {
+	c := Must(time.Second, 10)
+	bytes, err := c.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := NewLog(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logBytes, err := l.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string][]byte{
+		"truncated":    bytes[:len(bytes)-1],
+		"no header":    bytes[1:],
+		"next version": append(append([]byte{}, bytes[:4]...), 0xff),
+		"other kind":   logBytes,
+	}
+	for name, input := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := NewFromBinary(input); err == nil {
+				t.Errorf("NewFromBinary() no error")
+			}
+		})
+	}
+}
+
+func TestCounter_UnmarshalInvalidState(t *testing.T) {
+	tests := map[string]func(c *Counter){
+		"no window":         func(c *Counter) { c.windowDuration = 0 },
+		"missing counters":  func(c *Counter) { c.counters = c.counters[:5] },
+		"no resolution":     func(c *Counter) { c.resolution, c.counters = 0, nil },
+		"head out of range": func(c *Counter) { c.head = 10 },
+		"negative tail":     func(c *Counter) { c.tail = -1 },
+	}
+	for name, corrupt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := Must(time.Second, 10)
+			corrupt(c)
+
+			bytes, err := c.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := NewFromBinary(bytes); !errors.Is(err, ErrInvalidState) {
+				t.Errorf("NewFromBinary() error = %v, want %v", err, ErrInvalidState)
+			}
+
+			if bytes, err = c.MarshalJSON(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := NewFromJSON(bytes); !errors.Is(err, ErrInvalidState) {
+				t.Errorf("NewFromJSON() error = %v, want %v", err, ErrInvalidState)
+			}
+		})
+	}
+}
@@ -2,18 +2,32 @@ package counter
 
 import (
 	"context"
+	"encoding"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"os"
+	"path/filepath"
 	"sync"
 	"time"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/journal"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/lifecycle"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/store"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/wire"
 )
 
 const (
 	minPeriod = time.Millisecond
+
+	// delays of the retries of a failed save
+	minSaveBackoff = 100 * time.Millisecond
+	maxSaveBackoff = time.Minute
 )
 
+// ErrInvalidState is returned when a decoded state is inconsistent, e.g. its head is out of its
+// buffer. It is shared by the limiters, so that any state failing validation is known as corrupt.
+var ErrInvalidState = errors.New("invalid state")
+
 // Counter keeps information on the number of requests in the past time period
 type Counter struct {
 	m              sync.Mutex
@@ -27,12 +41,11 @@ type Counter struct {
 	tail     int
 	at       time.Time
 
-	// persistence
-	persistenceFilePath  string
-	savePeriod           time.Duration
-	isPersistenceEnabled bool
+	watchers []*watcher // see Watch
 
-	stop chan struct{}
+	journalSeq uint64 // seq of the last journal record in the state, see WithJournal
+
+	config
 }
 
 // New is the constructor of Counter
@@ -41,6 +54,7 @@ func New(windowDuration time.Duration, resolution uint64, options ...Option) (*C
 		windowDuration: windowDuration,
 		resolution:     resolution,
 		counters:       make([]int64, resolution),
+		config:         defaultConfig(),
 	}
 
 	tickPeriod := computePeriod(c.windowDuration, c.resolution)
@@ -49,9 +63,15 @@ func New(windowDuration time.Duration, resolution uint64, options ...Option) (*C
 	}
 
 	for _, opt := range options {
-		opt(c)
+		opt(&c.config)
+	}
+
+	if c.tickless {
+		c.at = c.clock.Now()
 	}
 
+	c.createJournal()
+
 	return c, nil
 }
 
@@ -71,23 +91,20 @@ func computePeriod(duration time.Duration, resolution uint64) time.Duration {
 // NewFromFile create a Counter starting from a state file
 //
 // the state file must be created by previously run of the Counter using
-// the WithPersistence option
-func NewFromFile(filePath string, options ...Option) (wc *Counter, err error) {
+// the WithPersistence option with a store.FS
+func NewFromFile(filePath string, options ...Option) (*Counter, error) {
+	return NewFromStore(store.NewFS(filepath.Dir(filePath)), filepath.Base(filePath), options...)
+}
 
-	f, err := os.Open(filePath)
+// NewFromStore create a Counter starting from the state saved in s under key
+func NewFromStore(s store.Store, key string, options ...Option) (*Counter, error) {
+	bytes, err := s.Load(key)
 	if err != nil {
-		return nil, fmt.Errorf("opening file %s: %v", filePath, err)
+		return nil, err
 	}
-	defer func() {
-		cerr := f.Close()
-		if err == nil {
-			err = cerr
-		}
-	}()
 
-	bytes, err := ioutil.ReadAll(f)
-	if err != nil {
-		return nil, fmt.Errorf("reading file %s: %v", filePath, err)
+	if wire.IsBinary(bytes) {
+		return NewFromBinary(bytes, options...)
 	}
 
 	return NewFromJSON(bytes, options...)
@@ -95,83 +112,121 @@ func NewFromFile(filePath string, options ...Option) (wc *Counter, err error) {
 
 // NewFromJSON create a Counter starting from a JSON input
 func NewFromJSON(bytes []byte, options ...Option) (*Counter, error) {
-	c := &Counter{}
+	c := &Counter{
+		config: defaultConfig(),
+	}
 
 	if err := json.Unmarshal(bytes, c); err != nil {
-		return nil, fmt.Errorf("unmashalling JSON: %v", err)
+		return nil, fmt.Errorf("unmashalling JSON: %w", err)
 	}
 
-	downDuration := time.Now().Sub(c.at)
-	tickPeriod := computePeriod(c.windowDuration, c.resolution)
-	missingTicks := int(float64(downDuration) / float64(tickPeriod))
+	if err := c.restore(options...); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
 
-	// simulate missing ticks
-	for i := 0; i < missingTicks; i++ {
-		c.tick()
+// NewFromBinary create a Counter starting from a binary input, see MarshalBinary
+func NewFromBinary(bytes []byte, options ...Option) (*Counter, error) {
+	c := &Counter{
+		config: defaultConfig(),
 	}
 
-	for _, opt := range options {
-		opt(c)
+	if err := c.UnmarshalBinary(bytes); err != nil {
+		return nil, fmt.Errorf("unmashalling binary: %w", err)
+	}
+
+	if err := c.restore(options...); err != nil {
+		return nil, err
 	}
 
 	return c, nil
 }
 
+// restore applies the options to a Counter just unmarshalled, replays its journal,
+// reconciles it with WithWindow and catches up on the ticks missed since its state was saved
+func (c *Counter) restore(options ...Option) error {
+	// options first, the clock is needed to catch up on the missed ticks
+	for _, opt := range options {
+		opt(&c.config)
+	}
+
+	if err := c.openJournal(c); err != nil {
+		return err
+	}
+
+	if err := c.reconcile(); err != nil {
+		return err
+	}
+
+	c.catchUp()
+
+	return nil
+}
+
 // Run runs the the Counter routine
 //
-// to stop this routine just cancel the context
+// to stop this routine cancel the context or call Close, if persistence is enabled
+// the state is saved a last time before returning
 func (c *Counter) Run(ctx context.Context) error {
-	var (
-		err error
-		wg  sync.WaitGroup
-	)
+	period := computePeriod(c.windowDuration, c.resolution)
+	return run(ctx, &c.config, period, c.tick, c.saveState)
+}
+
+// run runs the routines of a counter: one calling tick each period, unless the
+// counter is tickless or tick is nil, and one calling save if persistence is enabled,
+// which flushes the journal too if it is batched
+//
+// the routines stop when ctx is cancelled or Close is called, then the state is
+// saved a last time. A failed save or flush is reported and retried, see WithErrorHandler,
+// only the failure of the last save is returned.
+func run(ctx context.Context, cfg *config, period time.Duration, tick func(), save func() error) (err error) {
+	if !cfg.life.Begin() {
+		return nil
+	}
+	defer func() { cfg.life.End(err) }()
+
+	var wg sync.WaitGroup
 
 	ctx, cancelFunc := context.WithCancel(ctx)
 	defer cancelFunc()
 
-	if c.isPersistenceEnabled {
+	go func() {
+		select {
+		case <-cfg.life.Stopped():
+			cancelFunc()
+		case <-ctx.Done():
+		}
+	}()
+
+	if cfg.isPersistenceEnabled {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-
-			ticker := time.NewTicker(c.savePeriod)
-
-			for {
-				select {
-				case <-ctx.Done():
-					ticker.Stop()
-					return
-
-				case <-ticker.C:
-					if serr := c.saveState(); serr != nil {
-						cancelFunc()
-						err = serr
-						return
-					}
-				}
-			}
+			err = saveLoop(ctx, cfg, save)
 		}()
 	}
 
+	if cfg.tickless || tick == nil {
+		wg.Wait()
+		return err
+	}
+
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 
-		period := computePeriod(c.windowDuration, c.resolution)
-		ticker := time.NewTicker(period)
+		ticker := cfg.clock.NewTicker(period)
+		defer ticker.Stop()
 
 		for {
 			select {
 			case <-ctx.Done():
-				ticker.Stop()
-				return
-
-			case <-c.stop:
-				ticker.Stop()
 				return
 
-			case <-ticker.C:
-				c.tick()
+			case <-ticker.C():
+				tick()
 			}
 		}
 	}()
@@ -181,10 +236,116 @@ func (c *Counter) Run(ctx context.Context) error {
 	return err
 }
 
+// saveLoop calls save each save period until ctx is cancelled, then a last time.
+// A failed save is retried with an exponential backoff, instead of waiting for the
+// next period, and the journal flushes are retried at the next flush period.
+func saveLoop(ctx context.Context, cfg *config, save func() error) error {
+	ticker := cfg.clock.NewTicker(cfg.savePeriod)
+	defer ticker.Stop()
+
+	// a nil channel is never ready
+	var flush <-chan time.Time
+	if cfg.isJournalEnabled && cfg.journal != nil && cfg.journalSync == journal.SyncBatch {
+		flushTicker := cfg.clock.NewTicker(cfg.journalFlushPeriod)
+		defer flushTicker.Stop()
+		flush = flushTicker.C()
+	}
+
+	var (
+		retry   <-chan time.Time
+		backoff time.Duration
+	)
+
+	trySave := func() {
+		if err := save(); err != nil {
+			backoff = lifecycle.Backoff(backoff, minSaveBackoff, maxSaveBackoff)
+			cfg.fail(fmt.Errorf("saving state of %s, retrying in %v: %w", cfg.stateKey, backoff, err))
+			retry = cfg.clock.After(backoff)
+			return
+		}
+		if backoff > 0 {
+			cfg.logf("counter %s: state saved after %v of backoff\n", cfg.stateKey, backoff)
+		}
+		backoff, retry = 0, nil
+		cfg.life.Recover()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			// the increases since the last save are not lost on shutdown
+			if err := save(); err != nil {
+				return fmt.Errorf("saving final state: %w", err)
+			}
+			return nil
+
+		case <-ticker.C():
+			// while backing off the saves wait for the retry
+			if retry == nil {
+				trySave()
+			}
+
+		case <-retry:
+			trySave()
+
+		case <-flush:
+			if err := cfg.journal.Flush(); err != nil {
+				cfg.fail(fmt.Errorf("flushing journal of %s: %w", cfg.stateKey, err))
+			}
+		}
+	}
+}
+
 func (c *Counter) tick() {
 	c.m.Lock()
 	defer c.m.Unlock()
 
+	c.shift()
+	c.at = c.clock.Now()
+	c.notify()
+}
+
+// advance moves the window of all the ticks elapsed since the last one,
+// it is the tickless equivalent of tick. Must be called with the lock held.
+func (c *Counter) advance() {
+	period := computePeriod(c.windowDuration, c.resolution)
+	elapsed := c.clock.Now().Sub(c.at)
+	if elapsed < period {
+		return
+	}
+
+	n := uint64(elapsed / period)
+	c.shiftN(n)
+	c.at = c.at.Add(time.Duration(n) * period)
+	c.notify()
+}
+
+// shiftN is equal to call shift n times, but it costs at most one pass over the buffer
+func (c *Counter) shiftN(n uint64) {
+	size := uint64(len(c.counters))
+
+	i := uint64(0)
+	for ; i < n && i < size; i++ {
+		c.shift()
+	}
+
+	if i == n {
+		return
+	}
+
+	// after a whole buffer of shifts the buffer is made only of zeros
+	// and expired counters, further shifts just move head and tail
+	for j := range c.counters {
+		c.counters[j] = 0
+	}
+
+	skip := int((n - i) % size)
+	c.head = (c.head + skip) % len(c.counters)
+	c.tail = (c.tail + skip) % len(c.counters)
+}
+
+// shift moves the window of one tick. Must be called with the lock held.
+func (c *Counter) shift() {
 	// save actual diff
 	c.counters[c.head] = c.counter - c.prevCounter
 	c.head = (c.head + 1) % len(c.counters)
@@ -197,33 +358,39 @@ func (c *Counter) tick() {
 	}
 
 	c.prevCounter = c.counter
+}
 
-	c.at = time.Now()
+func (c *Counter) saveState() error {
+	// mutex lock is in MarshalJSON and MarshalBinary
+	return c.save(c)
 }
 
-func (c *Counter) saveState() (err error) {
-	// mutex lock is in MarshalJSON
+type stateMarshaler interface {
+	json.Marshaler
+	encoding.BinaryMarshaler
+}
 
-	f, cerr := os.Create(c.persistenceFilePath)
-	if cerr != nil {
-		return fmt.Errorf("creating file %s: %v", c.persistenceFilePath, cerr)
+// saveFormat saves the state of v in s under key, encoded in format
+func saveFormat(s store.Store, key string, format Format, v stateMarshaler) error {
+	if format == FormatJSON {
+		return saveJSON(s, key, v)
 	}
-	defer func() {
-		if cerr = f.Close(); err == nil {
-			err = cerr
-		}
-	}()
 
-	bytes, err := json.Marshal(c)
+	bytes, err := v.MarshalBinary()
 	if err != nil {
-		return fmt.Errorf("marshalling json: %w", err)
+		return fmt.Errorf("marshalling binary: %w", err)
 	}
 
-	if _, err = f.Write(bytes); err != nil {
-		return fmt.Errorf("writing in file %s: %w", c.persistenceFilePath, err)
+	return s.Save(key, bytes)
+}
+
+func saveJSON(s store.Store, key string, v interface{}) error {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshalling json: %w", err)
 	}
 
-	return nil
+	return s.Save(key, bytes)
 }
 
 // Value returns the number of increase received in the passed window
@@ -231,6 +398,10 @@ func (c *Counter) Value() int64 {
 	c.m.Lock()
 	defer c.m.Unlock()
 
+	if c.tickless {
+		c.advance()
+	}
+
 	return c.counter
 }
 
@@ -242,9 +413,246 @@ func (c *Counter) Rate() float64 {
 
 // Increase increase the counter by one and returns the counter value
 func (c *Counter) Increase() int64 {
+	return c.IncreaseBy(1)
+}
+
+// IncreaseBy increase the counter by n and returns the counter value
+func (c *Counter) IncreaseBy(n int64) int64 {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.tickless {
+		c.advance()
+	}
+
+	c.counter += n
+	c.journalSeq = c.record(c.journalSeq, c.tickStart(), n)
+	c.notify()
+	return c.counter
+}
+
+// DecreaseBy decrease the counter by n and returns the counter value
+//
+// it is meant to refund previous increases: they are taken back starting
+// from the most recent tick, and never more than the value of the window
+func (c *Counter) DecreaseBy(n int64) int64 {
 	c.m.Lock()
 	defer c.m.Unlock()
 
-	c.counter++
+	if c.tickless {
+		c.advance()
+	}
+
+	before := c.counter
+	c.decreaseBy(n)
+	c.journalSeq = c.record(c.journalSeq, c.tickStart(), c.counter-before)
+	c.notify()
 	return c.counter
 }
+
+// decreaseBy must be called with the lock held
+func (c *Counter) decreaseBy(n int64) {
+	// first from the current tick
+	take := minInt64(n, c.counter-c.prevCounter)
+	if take > 0 {
+		c.counter -= take
+		n -= take
+	}
+
+	// then from the past ticks, newest first
+	i := c.head
+	for n > 0 && i != c.tail {
+		i = (i - 1 + len(c.counters)) % len(c.counters)
+
+		take = minInt64(n, c.counters[i])
+		if take <= 0 {
+			continue
+		}
+
+		c.counters[i] -= take
+		c.counter -= take
+		c.prevCounter -= take
+		n -= take
+	}
+}
+
+// Window returns the duration of the window
+func (c *Counter) Window() time.Duration {
+	return c.windowDuration
+}
+
+// ExpiresIn returns the time until n of the increases in the window expire, see Interface.
+// The increases of a tick expire together, a window after the start of the tick.
+func (c *Counter) ExpiresIn(n int64) (time.Duration, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.tickless {
+		c.advance()
+	}
+
+	return c.expiresIn(n)
+}
+
+// expiresIn must be called with the lock held
+func (c *Counter) expiresIn(n int64) (time.Duration, bool) {
+	if n <= 0 {
+		return 0, true
+	}
+
+	period := computePeriod(c.windowDuration, c.resolution)
+	at := c.tickStart()
+	now := c.clock.Now()
+
+	// the tick k positions from the oldest one of the window expires at the k+1-th
+	// tick from now, the ticks not yet in the buffer are empty
+	size := len(c.counters)
+	k := size - 1 - (c.head-c.tail+size)%size
+
+	var expired int64
+	for i := c.tail; ; i = (i + 1) % size {
+		k++
+		if i == c.head {
+			// the current tick
+			expired += c.counter - c.prevCounter
+		} else {
+			expired += c.counters[i]
+		}
+
+		if expired >= n {
+			return maxDuration(0, at.Add(time.Duration(k)*period).Sub(now)), true
+		}
+		if i == c.head {
+			return 0, false
+		}
+	}
+}
+
+// tickStart returns the start of the current tick. Must be called with the lock held.
+func (c *Counter) tickStart() time.Time {
+	if c.at.IsZero() {
+		// never ticked
+		return c.clock.Now()
+	}
+	return c.at
+}
+
+// Replay applies a record of the journal, see Journaled
+func (c *Counter) Replay(r journal.Record) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if r.Seq <= c.journalSeq {
+		return
+	}
+
+	c.replay(r.At, r.Delta)
+	c.journalSeq = r.Seq
+}
+
+// replay applies n increases made in the tick starting at at, moving the
+// window up to it. Must be called with the lock held.
+func (c *Counter) replay(at time.Time, n int64) {
+	if c.at.IsZero() {
+		c.at = at
+	}
+
+	period := computePeriod(c.windowDuration, c.resolution)
+	if elapsed := at.Sub(c.at); elapsed >= period {
+		ticks := uint64(elapsed / period)
+		c.shiftN(ticks)
+		c.at = c.at.Add(time.Duration(ticks) * period)
+	}
+
+	if n < 0 {
+		c.decreaseBy(-n)
+		return
+	}
+	c.counter += n
+}
+
+// JournalSeq returns the seq of the last journal record in the state, see Journaled
+func (c *Counter) JournalSeq() uint64 {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.journalSeq
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Snapshot returns the series of the ticks in the window, from the oldest to the
+// current one. If points is greater than zero and less than the resolution, the
+// series is downsampled to points, summing adjacent ticks.
+func (c *Counter) Snapshot(points int) []Point {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.tickless {
+		c.advance()
+	}
+
+	return c.snapshot(points)
+}
+
+// snapshot must be called with the lock held
+func (c *Counter) snapshot(points int) []Point {
+	period := computePeriod(c.windowDuration, c.resolution)
+
+	at := c.at
+	if at.IsZero() {
+		// never ticked
+		at = c.clock.Now()
+	}
+
+	// the window is made of the current tick and the len-1 previous ones,
+	// ticks not yet in the buffer are reported as empty
+	series := make([]Point, len(c.counters))
+	last := len(series) - 1
+	series[last] = Point{Start: at, Count: c.counter - c.prevCounter}
+
+	i, j := c.head, last
+	for j > 0 {
+		j--
+		series[j].Start = at.Add(-time.Duration(last-j) * period)
+
+		if i == c.tail {
+			continue
+		}
+		i = (i - 1 + len(c.counters)) % len(c.counters)
+		series[j].Count = c.counters[i]
+	}
+
+	return downsample(series, points)
+}
+
+// downsample sums adjacent points of series so that the result has n points
+func downsample(series []Point, n int) []Point {
+	if n <= 0 || n >= len(series) {
+		return series
+	}
+
+	result := make([]Point, n)
+	for k := range result {
+		start, end := k*len(series)/n, (k+1)*len(series)/n
+
+		result[k].Start = series[start].Start
+		for _, p := range series[start:end] {
+			result[k].Count += p.Count
+		}
+	}
+
+	return result
+}
@@ -3,11 +3,14 @@ package counter
 import (
 	"context"
 	"math"
+	"math/rand"
 	"os"
 	"reflect"
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/clock"
 )
 
 func TestNew(t *testing.T) {
@@ -34,9 +37,10 @@ func TestNew(t *testing.T) {
 				counter:        0,
 				prevCounter:    0,
 				resolution:     1000,
-				counters:       nil,
+				counters:       make([]int64, 1000),
 				head:           0,
 				tail:           0,
+				config:         defaultConfig(),
 			},
 			wantErr: false,
 		},
@@ -149,7 +153,7 @@ func TestWindowCounter(t *testing.T) {
 				counter:     0,
 				prevCounter: 0,
 				resolution:  10,
-				counters:    nil,
+				counters:    make([]int64, 10),
 				head:        0,
 				tail:        0,
 			},
@@ -168,7 +172,7 @@ func TestWindowCounter(t *testing.T) {
 				counter:     0,
 				prevCounter: 0,
 				resolution:  10,
-				counters:    nil,
+				counters:    make([]int64, 10),
 				head:        0,
 				tail:        0,
 			},
@@ -191,6 +195,7 @@ func TestWindowCounter(t *testing.T) {
 				counters:       tt.fields.counters,
 				head:           tt.fields.head,
 				tail:           tt.fields.tail,
+				config:         defaultConfig(),
 			}
 
 			ctx, cancelFunc := context.WithTimeout(context.TODO(), tt.args.testDuration)
@@ -243,3 +248,219 @@ func TestWindowCounter(t *testing.T) {
 		})
 	}
 }
+
+func TestCounter_WindowExpiry(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	c := Must(time.Second, 10, WithClock(clk))
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- c.Run(ctx)
+	}()
+	clk.BlockUntil(1)
+
+	steps := []struct {
+		increase int
+		advance  time.Duration
+		want     int64
+	}{
+		{increase: 3, advance: 500 * time.Millisecond, want: 3},
+		{increase: 2, advance: 600 * time.Millisecond, want: 2}, // first 3 expire at the 10th tick
+		{increase: 0, advance: 500 * time.Millisecond, want: 0},
+	}
+
+	for i, step := range steps {
+		for j := 0; j < step.increase; j++ {
+			c.Increase()
+		}
+		// a fake tick is delivered only after the previous one has been handled,
+		// so a further tick is needed to be sure the last expiration happened
+		clk.Advance(step.advance)
+		if got := c.Value(); got != step.want {
+			t.Errorf("step %d: Value() = %d, want %d", i, got, step.want)
+		}
+	}
+
+	cancelFunc()
+	if err := <-done; err != nil {
+		t.Errorf("Run() error = %v", err)
+	}
+}
+
+func TestNewFromJSON(t *testing.T) {
+	start := time.Unix(0, 0)
+
+	tests := []struct {
+		name     string
+		downtime time.Duration
+		want     int64
+	}{
+		{
+			name:     "no downtime",
+			downtime: 0,
+			want:     5,
+		},
+		{
+			name:     "downtime shorter than window",
+			downtime: 500 * time.Millisecond,
+			want:     5,
+		},
+		{
+			name:     "oldest tick expired during downtime",
+			downtime: 800 * time.Millisecond,
+			want:     2,
+		},
+		{
+			name:     "downtime longer than window",
+			downtime: time.Hour,
+			want:     0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := clock.NewFake(start)
+			c := Must(time.Second, 10, WithClock(clk))
+
+			for i := 0; i < 3; i++ {
+				c.Increase()
+			}
+			clk.Advance(100 * time.Millisecond)
+			c.tick()
+
+			for i := 0; i < 2; i++ {
+				c.Increase()
+			}
+			clk.Advance(100 * time.Millisecond)
+			c.tick()
+
+			bytes, err := c.MarshalJSON()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			clk.Advance(tt.downtime)
+
+			restored, err := NewFromJSON(bytes, WithClock(clk))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := restored.Value(); got != tt.want {
+				t.Errorf("Value() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCounter_TicklessMatchesTicker(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	period := 100 * time.Millisecond
+
+	ticker := Must(time.Second, 10, WithClock(clk))
+	ticker.at = clk.Now()
+	tickless := Must(time.Second, 10, WithClock(clk), WithTickless())
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		for j := r.Intn(5); j > 0; j-- {
+			ticker.Increase()
+			tickless.Increase()
+		}
+
+		// skip sometimes several ticks at once
+		nTicks := r.Intn(3)
+		if r.Intn(50) == 0 {
+			nTicks = 10 + r.Intn(20)
+		}
+
+		for j := 0; j < nTicks; j++ {
+			clk.Advance(period)
+			ticker.tick()
+		}
+
+		if got, want := tickless.Value(), ticker.Value(); got != want {
+			t.Fatalf("step %d: tickless Value() = %d, ticker Value() = %d", i, got, want)
+		}
+	}
+
+	if tickless.head != ticker.head || tickless.tail != ticker.tail {
+		t.Errorf("tickless head, tail = %d, %d, ticker head, tail = %d, %d",
+			tickless.head, tickless.tail, ticker.head, ticker.tail)
+	}
+	if !reflect.DeepEqual(tickless.counters, ticker.counters) {
+		t.Errorf("tickless counters = %v, ticker counters = %v", tickless.counters, ticker.counters)
+	}
+}
+
+func TestCounter_DecreaseBy(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	c := Must(time.Second, 10, WithClock(clk), WithTickless())
+
+	c.IncreaseBy(3)
+	clk.Advance(100 * time.Millisecond)
+	c.IncreaseBy(4)
+	clk.Advance(100 * time.Millisecond)
+	c.IncreaseBy(2)
+
+	// takes 2 from the current tick and 3 from the previous one
+	if got := c.DecreaseBy(5); got != 4 {
+		t.Errorf("DecreaseBy(5) = %d, want 4", got)
+	}
+
+	// only 3 units in the oldest tick are left, they expire
+	// and the window must not become negative
+	clk.Advance(800 * time.Millisecond)
+	if got := c.Value(); got != 1 {
+		t.Errorf("after the oldest tick expired Value() = %d, want 1", got)
+	}
+
+	if got := c.DecreaseBy(10); got != 0 {
+		t.Errorf("DecreaseBy(10) = %d, want 0", got)
+	}
+
+	clk.Advance(time.Second)
+	if got := c.Value(); got != 0 {
+		t.Errorf("after the window Value() = %d, want 0", got)
+	}
+}
+
+func TestCounter_Snapshot(t *testing.T) {
+	start := time.Unix(0, 0)
+	clk := clock.NewFake(start)
+	c := Must(time.Second, 10, WithClock(clk), WithTickless())
+
+	for i := int64(1); i <= 3; i++ {
+		c.IncreaseBy(i)
+		clk.Advance(100 * time.Millisecond)
+	}
+	c.IncreaseBy(4)
+
+	at := func(ms int) time.Time {
+		return start.Add(time.Duration(ms) * time.Millisecond)
+	}
+
+	want := []Point{
+		{Start: at(-600)}, {Start: at(-500)}, {Start: at(-400)}, {Start: at(-300)},
+		{Start: at(-200)}, {Start: at(-100)},
+		{Start: at(0), Count: 1}, {Start: at(100), Count: 2}, {Start: at(200), Count: 3},
+		{Start: at(300), Count: 4},
+	}
+	if got := c.Snapshot(0); !reflect.DeepEqual(got, want) {
+		t.Errorf("Snapshot(0) = %v, want %v", got, want)
+	}
+
+	wantDownsampled := []Point{
+		{Start: at(-600)}, {Start: at(-400)}, {Start: at(-100), Count: 1}, {Start: at(100), Count: 9},
+	}
+	if got := c.Snapshot(4); !reflect.DeepEqual(got, wantDownsampled) {
+		t.Errorf("Snapshot(4) = %v, want %v", got, wantDownsampled)
+	}
+
+	// after a whole window the first ticks are out
+	clk.Advance(800 * time.Millisecond)
+	got := c.Snapshot(0)
+	if got[0].Count != 3 || got[1].Count != 4 || !got[0].Start.Equal(at(200)) {
+		t.Errorf("after the window Snapshot(0) starts with %v, want 3 at %v and 4", got[:2], at(200))
+	}
+}
@@ -0,0 +1,333 @@
+package counter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/store"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/wire"
+)
+
+// Histogram keeps the distribution of durations observed in the past time period
+//
+// it has the same circular per tick layout of Counter, but each tick stores
+// a histogram instead of a single counter. Buckets are fixed, see LogLinearBounds.
+type Histogram struct {
+	m              sync.Mutex
+	windowDuration time.Duration
+	resolution     uint64          // number of tick per window windowDuration
+	bounds         []time.Duration // upper bound of each bucket but the last one, which is unbounded
+	window         []int64         // per bucket counters of the window, current tick included
+	current        []int64         // per bucket counters of the current tick
+
+	ticks [][]int64 // per bucket counters per tick, managed as a circular buffer
+	head  int
+	tail  int
+	at    time.Time
+
+	config
+}
+
+// LogLinearBounds returns bucket bounds from min to max: each power of two
+// is split in subBuckets linear buckets, so that the relative error of
+// the quantiles is about the same at any scale
+func LogLinearBounds(min, max time.Duration, subBuckets int) []time.Duration {
+	if min <= 0 || max <= min || subBuckets <= 0 {
+		return []time.Duration{max}
+	}
+
+	bounds := []time.Duration{min}
+	for lower := min; lower < max; lower *= 2 {
+		step := lower / time.Duration(subBuckets)
+		for i := 1; i <= subBuckets; i++ {
+			bounds = append(bounds, lower+time.Duration(i)*step)
+		}
+	}
+
+	return bounds
+}
+
+// NewHistogram is the constructor of Histogram
+func NewHistogram(windowDuration time.Duration, resolution uint64, bounds []time.Duration, options ...Option) (*Histogram, error) {
+	tickPeriod := computePeriod(windowDuration, resolution)
+	if tickPeriod < minPeriod {
+		return nil, fmt.Errorf("tickPeriod less than minimum tickPeriod: %v", tickPeriod)
+	}
+
+	if len(bounds) == 0 {
+		return nil, fmt.Errorf("no bucket bounds")
+	}
+	if !sort.SliceIsSorted(bounds, func(i, j int) bool { return bounds[i] < bounds[j] }) {
+		return nil, fmt.Errorf("bucket bounds not sorted")
+	}
+
+	h := &Histogram{
+		windowDuration: windowDuration,
+		resolution:     resolution,
+		bounds:         bounds,
+		window:         make([]int64, len(bounds)+1),
+		current:        make([]int64, len(bounds)+1),
+		ticks:          make([][]int64, resolution),
+		config:         defaultConfig(),
+	}
+	for i := range h.ticks {
+		h.ticks[i] = make([]int64, len(bounds)+1)
+	}
+
+	for _, opt := range options {
+		opt(&h.config)
+	}
+
+	if h.tickless {
+		h.at = h.clock.Now()
+	}
+
+	return h, nil
+}
+
+// NewHistogramFromFile create a Histogram starting from a state file
+//
+// the state file must be created by previously run of the Histogram using
+// the WithPersistence option
+func NewHistogramFromFile(filePath string, options ...Option) (*Histogram, error) {
+	return NewHistogramFromStore(store.NewFS(filepath.Dir(filePath)), filepath.Base(filePath), options...)
+}
+
+// NewHistogramFromStore create a Histogram starting from the state saved in s under key
+func NewHistogramFromStore(s store.Store, key string, options ...Option) (*Histogram, error) {
+	bytes, err := s.Load(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if wire.IsBinary(bytes) {
+		return NewHistogramFromBinary(bytes, options...)
+	}
+
+	return NewHistogramFromJSON(bytes, options...)
+}
+
+// NewHistogramFromJSON create a Histogram starting from a JSON input
+func NewHistogramFromJSON(bytes []byte, options ...Option) (*Histogram, error) {
+	h := &Histogram{
+		config: defaultConfig(),
+	}
+
+	if err := json.Unmarshal(bytes, h); err != nil {
+		return nil, fmt.Errorf("unmashalling JSON: %w", err)
+	}
+
+	for _, opt := range options {
+		opt(&h.config)
+	}
+
+	h.catchUp()
+
+	return h, nil
+}
+
+// NewHistogramFromBinary create a Histogram starting from a binary input, see MarshalBinary
+func NewHistogramFromBinary(bytes []byte, options ...Option) (*Histogram, error) {
+	h := &Histogram{
+		config: defaultConfig(),
+	}
+
+	if err := h.UnmarshalBinary(bytes); err != nil {
+		return nil, fmt.Errorf("unmashalling binary: %w", err)
+	}
+
+	for _, opt := range options {
+		opt(&h.config)
+	}
+
+	h.catchUp()
+
+	return h, nil
+}
+
+// Run runs the the Histogram routine
+//
+// to stop this routine cancel the context or call Close
+func (h *Histogram) Run(ctx context.Context) error {
+	period := computePeriod(h.windowDuration, h.resolution)
+	return run(ctx, &h.config, period, h.tick, h.saveState)
+}
+
+func (h *Histogram) tick() {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	h.shift()
+	h.at = h.clock.Now()
+}
+
+// advance moves the window of all the ticks elapsed since the last one,
+// it is the tickless equivalent of tick. Must be called with the lock held.
+func (h *Histogram) advance() {
+	period := computePeriod(h.windowDuration, h.resolution)
+	elapsed := h.clock.Now().Sub(h.at)
+	if elapsed < period {
+		return
+	}
+
+	n := uint64(elapsed / period)
+	h.shiftN(n)
+	h.at = h.at.Add(time.Duration(n) * period)
+}
+
+// shiftN is equal to call shift n times, but it costs at most one pass over the buffer
+func (h *Histogram) shiftN(n uint64) {
+	size := uint64(len(h.ticks))
+
+	i := uint64(0)
+	for ; i < n && i < size; i++ {
+		h.shift()
+	}
+
+	if i == n {
+		return
+	}
+
+	// after a whole buffer of shifts the window is empty,
+	// further shifts just move head and tail
+	for _, tick := range h.ticks {
+		for j := range tick {
+			tick[j] = 0
+		}
+	}
+
+	skip := int((n - i) % size)
+	h.head = (h.head + skip) % len(h.ticks)
+	h.tail = (h.tail + skip) % len(h.ticks)
+}
+
+// shift moves the window of one tick. Must be called with the lock held.
+func (h *Histogram) shift() {
+	copy(h.ticks[h.head], h.current)
+	for i := range h.current {
+		h.current[i] = 0
+	}
+	h.head = (h.head + 1) % len(h.ticks)
+
+	// tail = head means the window is full
+	// and we can start to subtract oldest ticks
+	if h.tail == h.head {
+		for i, n := range h.ticks[h.tail] {
+			h.window[i] -= n
+		}
+		h.tail = (h.tail + 1) % len(h.ticks)
+	}
+}
+
+func (h *Histogram) saveState() error {
+	// mutex lock is in MarshalJSON and MarshalBinary
+	return saveFormat(h.stateStore, h.stateKey, h.format, h)
+}
+
+// bucket returns the index of the bucket of d
+func (h *Histogram) bucket(d time.Duration) int {
+	return sort.Search(len(h.bounds), func(i int) bool { return d <= h.bounds[i] })
+}
+
+// Observe records the duration d
+func (h *Histogram) Observe(d time.Duration) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	if h.tickless {
+		h.advance()
+	}
+
+	i := h.bucket(d)
+	h.current[i]++
+	h.window[i]++
+}
+
+// Count returns the number of durations observed in the passed window
+func (h *Histogram) Count() int64 {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	if h.tickless {
+		h.advance()
+	}
+
+	count := int64(0)
+	for _, n := range h.window {
+		count += n
+	}
+	return count
+}
+
+// Quantile returns the q-quantile, 0 <= q <= 1, of the durations observed in the
+// passed window. The value is interpolated linearly inside its bucket, durations
+// above the last bound are reported as the last bound. It returns 0 if the window is empty.
+func (h *Histogram) Quantile(q float64) time.Duration {
+	return h.Quantiles(q)[0]
+}
+
+// Quantiles is equal to Quantile, but computes several quantiles at once
+func (h *Histogram) Quantiles(qs ...float64) []time.Duration {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	if h.tickless {
+		h.advance()
+	}
+
+	count := int64(0)
+	for _, n := range h.window {
+		count += n
+	}
+
+	quantiles := make([]time.Duration, len(qs))
+	if count == 0 {
+		return quantiles
+	}
+
+	for i, q := range qs {
+		quantiles[i] = h.quantile(q, count)
+	}
+
+	return quantiles
+}
+
+// quantile must be called with the lock held
+func (h *Histogram) quantile(q float64, count int64) time.Duration {
+	if q < 0 {
+		q = 0
+	}
+	if q > 1 {
+		q = 1
+	}
+
+	rank := q * float64(count)
+	cumulative := float64(0)
+
+	for i, n := range h.window {
+		if n == 0 || cumulative+float64(n) < rank {
+			cumulative += float64(n)
+			continue
+		}
+
+		if i == len(h.bounds) {
+			return h.bounds[len(h.bounds)-1]
+		}
+
+		lower := time.Duration(0)
+		if i > 0 {
+			lower = h.bounds[i-1]
+		}
+		upper := h.bounds[i]
+
+		fraction := (rank - cumulative) / float64(n)
+		return lower + time.Duration(fraction*float64(upper-lower))
+	}
+
+	return h.bounds[len(h.bounds)-1]
+}
@@ -0,0 +1,81 @@
+package counter
+
+import (
+	"fmt"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/wire"
+)
+
+// histogramTag identifies a Histogram in the binary format, it is not a Kind
+const histogramTag = "histogram"
+
+// MarshalBinary encodes the Histogram in the compact binary format of package wire
+func (h *Histogram) MarshalBinary() ([]byte, error) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	if h.tickless {
+		h.advance()
+	}
+
+	e := wire.NewEncoder()
+	e.String(histogramTag)
+	e.Duration(h.windowDuration)
+	e.Uvarint(h.resolution)
+
+	e.Uvarint(uint64(len(h.bounds)))
+	for _, bound := range h.bounds {
+		e.Duration(bound)
+	}
+
+	e.Varints(h.current)
+	// the number of ticks is the resolution
+	for _, tick := range h.ticks {
+		e.Varints(tick)
+	}
+
+	e.Uvarint(uint64(h.head))
+	e.Uvarint(uint64(h.tail))
+	e.Time(h.at)
+
+	return e.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a Histogram encoded by MarshalBinary
+func (h *Histogram) UnmarshalBinary(bytes []byte) error {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	d, err := wire.NewDecoder(bytes)
+	if err != nil {
+		return fmt.Errorf("decoding header: %w", err)
+	}
+
+	if tag := d.String(); tag != histogramTag {
+		return fmt.Errorf("decoding %q as a %q", tag, histogramTag)
+	}
+
+	hJSON := HistogramJSON{
+		Duration:   d.Duration(),
+		Resolution: d.Uvarint(),
+	}
+
+	for n := d.Uvarint(); n > 0 && d.Err() == nil; n-- {
+		hJSON.Bounds = append(hJSON.Bounds, d.Duration())
+	}
+
+	hJSON.Current = d.Varints()
+	for i := uint64(0); i < hJSON.Resolution && d.Err() == nil; i++ {
+		hJSON.Ticks = append(hJSON.Ticks, d.Varints())
+	}
+
+	hJSON.Head = int(d.Uvarint())
+	hJSON.Tail = int(d.Uvarint())
+	hJSON.At = d.Time()
+
+	if err := d.Err(); err != nil {
+		return fmt.Errorf("decoding: %w", err)
+	}
+
+	return h.load(hJSON)
+}
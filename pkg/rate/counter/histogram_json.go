@@ -0,0 +1,96 @@
+package counter
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type HistogramJSON struct {
+	Version    int             `json:"version"`
+	Duration   time.Duration   `json:"windowDuration"`
+	Resolution uint64          `json:"resolution"`
+	Bounds     []time.Duration `json:"bounds"`
+	Current    []int64         `json:"current"`
+	Ticks      [][]int64       `json:"ticks"`
+	Head       int             `json:"head"`
+	Tail       int             `json:"tail"`
+	At         time.Time       `json:"at"`
+}
+
+func (h *Histogram) MarshalJSON() ([]byte, error) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	if h.tickless {
+		h.advance()
+	}
+
+	return json.Marshal(
+		HistogramJSON{
+			Version:    histogramSchema.Version(),
+			Duration:   h.windowDuration,
+			Resolution: h.resolution,
+			Bounds:     h.bounds,
+			Current:    h.current,
+			Ticks:      h.ticks,
+			Head:       h.head,
+			Tail:       h.tail,
+			At:         h.at,
+		},
+	)
+}
+
+func (h *Histogram) UnmarshalJSON(bytes []byte) error {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	bytes, err := histogramSchema.Migrate(bytes)
+	if err != nil {
+		return err
+	}
+
+	hJSON := HistogramJSON{}
+
+	if err := json.Unmarshal(bytes, &hJSON); err != nil {
+		return fmt.Errorf("unmarshalling json: %w", err)
+	}
+
+	return h.load(hJSON)
+}
+
+// load sets the state of the Histogram to a decoded one, it is shared by the
+// binary and the JSON decoders. Must be called with the lock held.
+func (h *Histogram) load(hJSON HistogramJSON) error {
+	nBuckets := len(hJSON.Bounds) + 1
+	if len(hJSON.Ticks) == 0 || uint64(len(hJSON.Ticks)) != hJSON.Resolution || len(hJSON.Current) != nBuckets ||
+		hJSON.Head < 0 || hJSON.Head >= len(hJSON.Ticks) || hJSON.Tail < 0 || hJSON.Tail >= len(hJSON.Ticks) {
+		return fmt.Errorf("%w: histogram of resolution %d, %d ticks, %d buckets",
+			ErrInvalidState, hJSON.Resolution, len(hJSON.Ticks), nBuckets)
+	}
+	for _, tick := range hJSON.Ticks {
+		if len(tick) != nBuckets {
+			return fmt.Errorf("%w: histogram tick of %d buckets, want %d", ErrInvalidState, len(tick), nBuckets)
+		}
+	}
+
+	h.windowDuration = hJSON.Duration
+	h.resolution = hJSON.Resolution
+	h.bounds = hJSON.Bounds
+	h.current = hJSON.Current
+	h.ticks = hJSON.Ticks
+	h.head = hJSON.Head
+	h.tail = hJSON.Tail
+	h.at = hJSON.At
+
+	// the window is not stored, it is computed from the ticks
+	h.window = make([]int64, nBuckets)
+	copy(h.window, h.current)
+	for i := h.tail; i != h.head; i = (i + 1) % len(h.ticks) {
+		for j, n := range h.ticks[i] {
+			h.window[j] += n
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package counter
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/clock"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/store"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/wire"
+)
+
+func TestLogLinearBounds(t *testing.T) {
+	got := LogLinearBounds(time.Millisecond, 4*time.Millisecond, 2)
+	want := []time.Duration{
+		time.Millisecond,
+		1500 * time.Microsecond, 2 * time.Millisecond,
+		3 * time.Millisecond, 4 * time.Millisecond,
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LogLinearBounds() = %v, want %v", got, want)
+	}
+}
+
+func TestHistogram_Quantile(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	bounds := LogLinearBounds(time.Microsecond, 10*time.Second, 8)
+	h, err := NewHistogram(time.Second, 10, bounds, WithClock(clk), WithTickless())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := h.Quantile(0.5); got != 0 {
+		t.Errorf("empty Quantile(0.5) = %v, want 0", got)
+	}
+
+	// 1ms, 2ms, ..., 1000ms
+	for i := 1; i <= 1000; i++ {
+		h.Observe(time.Duration(i) * time.Millisecond)
+	}
+
+	tests := []struct {
+		q    float64
+		want time.Duration
+	}{
+		{q: 0.5, want: 500 * time.Millisecond},
+		{q: 0.95, want: 950 * time.Millisecond},
+		{q: 0.99, want: 990 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		got := h.Quantile(tt.q)
+		// 8 sub buckets per power of two make an error within 1/8
+		if relativeError := float64(got-tt.want) / float64(tt.want); relativeError > 0.125 || relativeError < -0.125 {
+			t.Errorf("Quantile(%v) = %v, want about %v", tt.q, got, tt.want)
+		}
+	}
+
+	// durations over the last bound are reported as the last bound
+	h.Observe(time.Minute)
+	if got, want := h.Quantile(1), bounds[len(bounds)-1]; got != want {
+		t.Errorf("Quantile(1) = %v, want %v", got, want)
+	}
+}
+
+func TestHistogram_Window(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	bounds := LogLinearBounds(time.Millisecond, time.Second, 4)
+	h, err := NewHistogram(time.Second, 10, bounds, WithClock(clk), WithTickless())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.Observe(800 * time.Millisecond)
+	clk.Advance(500 * time.Millisecond)
+	h.Observe(10 * time.Millisecond)
+	h.Observe(10 * time.Millisecond)
+
+	bytes, err := json.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := NewHistogramFromJSON(bytes, WithClock(clk), WithTickless())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := restored.Count(); got != 3 {
+		t.Errorf("restored Count() = %d, want 3", got)
+	}
+	if got, want := restored.Quantile(1), h.Quantile(1); got != want {
+		t.Errorf("restored Quantile(1) = %v, want %v", got, want)
+	}
+
+	// the slow observation expires, the fast ones are still in the window
+	clk.Advance(500 * time.Millisecond)
+	if got := restored.Count(); got != 2 {
+		t.Errorf("after the first expiration Count() = %d, want 2", got)
+	}
+	if got := restored.Quantile(1); got > 10*time.Millisecond {
+		t.Errorf("after the first expiration Quantile(1) = %v, want at most 10ms", got)
+	}
+
+	clk.Advance(time.Second)
+	if got := restored.Count(); got != 0 {
+		t.Errorf("after the window Count() = %d, want 0", got)
+	}
+}
+
+func TestHistogram_Format(t *testing.T) {
+	for _, format := range []Format{FormatBinary, FormatJSON} {
+		clk := clock.NewFake(time.Unix(0, 0))
+		states := store.NewMemory()
+		options := []Option{WithClock(clk), WithTickless(), WithPersistence(states, "latency", time.Second), WithFormat(format)}
+
+		h, err := NewHistogram(time.Second, 10, LogLinearBounds(time.Millisecond, time.Second, 4), options...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.Observe(10 * time.Millisecond)
+		h.Observe(800 * time.Millisecond)
+
+		if err := h.saveState(); err != nil {
+			t.Fatal(err)
+		}
+		bytes, err := states.Load("latency")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := wire.IsBinary(bytes), format == FormatBinary; got != want {
+			t.Errorf("format %d: saved binary %v, want %v", format, got, want)
+		}
+
+		restored, err := NewHistogramFromStore(states, "latency", options...)
+		if err != nil {
+			t.Fatalf("format %d: %v", format, err)
+		}
+		if got := restored.Count(); got != 2 {
+			t.Errorf("format %d: restored Count() = %d, want 2", format, got)
+		}
+		if got, want := restored.Quantile(1), h.Quantile(1); got != want {
+			t.Errorf("format %d: restored Quantile(1) = %v, want %v", format, got, want)
+		}
+	}
+}
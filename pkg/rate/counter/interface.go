@@ -0,0 +1,186 @@
+package counter
+
+import (
+	"context"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/store"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/wire"
+)
+
+// Interface is implemented by all the window counters
+type Interface interface {
+	// Increase increase the counter by one and returns the counter value
+	Increase() int64
+	// IncreaseBy increase the counter by n and returns the counter value
+	IncreaseBy(n int64) int64
+	// DecreaseBy refunds n previous increases and returns the counter value
+	DecreaseBy(n int64) int64
+	// Value returns the number of increase received in the passed window
+	Value() int64
+	// Rate returns the number of increase per seconds
+	Rate() float64
+	// Window returns the duration of the window
+	Window() time.Duration
+	// ExpiresIn returns the time until n of the increases in the window expire, if no
+	// increase is refunded meanwhile. It returns false if the window holds less than n.
+	ExpiresIn(n int64) (time.Duration, bool)
+	// Run runs the counter routines until the context is cancelled or Close is called
+	Run(ctx context.Context) error
+	// Close stops the routines of Run and waits for them
+	Close() error
+	// Wait waits for the routines of Run to stop
+	Wait() error
+	// Err returns the last failure of the routines of Run, see WithErrorHandler
+	Err() error
+	// RestoreReport returns what the restore did to catch up with the clock
+	RestoreReport() RestoreReport
+
+	Journaled
+	json.Marshaler
+	json.Unmarshaler
+	encoding.BinaryMarshaler
+	encoding.BinaryUnmarshaler
+}
+
+// Point is the number of increases in the tick starting at Start
+type Point struct {
+	Start time.Time `json:"start"`
+	Count int64     `json:"count"`
+}
+
+// Snapshotter is implemented by the counters able to return the per tick series of their window
+type Snapshotter interface {
+	Snapshot(points int) []Point
+}
+
+// Kind identifies an implementation of Interface, it is stored
+// in the persisted state so that a restore picks the right one
+type Kind string
+
+const (
+	// KindRing is the circular buffer of per tick counters, see Counter
+	KindRing Kind = "ring"
+	// KindSharded is the lock free circular buffer, see Sharded
+	KindSharded Kind = "sharded"
+	// KindLog is the exact log of timestamps, see Log
+	KindLog Kind = "log"
+	// KindWeighted is the two buckets weighted approximation, see Weighted
+	KindWeighted Kind = "weighted"
+)
+
+var (
+	_ Snapshotter = (*Counter)(nil)
+	_ Snapshotter = (*Sharded)(nil)
+
+	_ Interface = (*Counter)(nil)
+	_ Interface = (*Sharded)(nil)
+	_ Interface = (*Log)(nil)
+	_ Interface = (*Weighted)(nil)
+)
+
+// NewKind creates a counter of the given kind
+//
+// resolution is the number of ticks per window of the ring and sharded
+// kinds, the other kinds ignore it
+func NewKind(kind Kind, windowDuration time.Duration, resolution uint64, options ...Option) (Interface, error) {
+	switch kind {
+	case KindRing, "":
+		return New(windowDuration, resolution, options...)
+	case KindSharded:
+		return NewSharded(windowDuration, resolution, options...)
+	case KindLog:
+		return NewLog(windowDuration, options...)
+	case KindWeighted:
+		return NewWeighted(windowDuration, options...)
+	default:
+		return nil, fmt.Errorf("unknown counter kind %q", kind)
+	}
+}
+
+// NewKindFromFile create a counter starting from a state file, the kind
+// of the counter is the one stored in the file, either JSON or binary
+func NewKindFromFile(filePath string, options ...Option) (Interface, error) {
+	return NewKindFromStore(store.NewFS(filepath.Dir(filePath)), filepath.Base(filePath), options...)
+}
+
+// NewKindFromStore create a counter starting from the state saved in s under key
+func NewKindFromStore(s store.Store, key string, options ...Option) (Interface, error) {
+	bytes, err := s.Load(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewKindFromBytes(bytes, options...)
+}
+
+// NewKindFromBytes create a counter starting from a state, detecting whether it is JSON or binary
+func NewKindFromBytes(bytes []byte, options ...Option) (Interface, error) {
+	if wire.IsBinary(bytes) {
+		return NewKindFromBinary(bytes, options...)
+	}
+
+	return NewKindFromJSON(bytes, options...)
+}
+
+// NewKindFromJSON create a counter starting from a JSON input, the kind
+// of the counter is the one stored in the input. Inputs of older versions
+// are migrated first, see counterSchema.
+func NewKindFromJSON(bytes []byte, options ...Option) (Interface, error) {
+	bytes, err := counterSchema.Migrate(bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	kindJSON := struct {
+		Kind Kind `json:"kind"`
+	}{}
+
+	if err := json.Unmarshal(bytes, &kindJSON); err != nil {
+		return nil, fmt.Errorf("unmashalling JSON: %w", err)
+	}
+
+	switch kindJSON.Kind {
+	case KindRing, "":
+		return NewFromJSON(bytes, options...)
+	case KindSharded:
+		return NewShardedFromJSON(bytes, options...)
+	case KindLog:
+		return NewLogFromJSON(bytes, options...)
+	case KindWeighted:
+		return NewWeightedFromJSON(bytes, options...)
+	default:
+		return nil, fmt.Errorf("unknown counter kind %q", kindJSON.Kind)
+	}
+}
+
+// NewKindFromBinary create a counter starting from a binary input, the kind
+// of the counter is the one stored in the input
+func NewKindFromBinary(bytes []byte, options ...Option) (Interface, error) {
+	d, err := wire.NewDecoder(bytes)
+	if err != nil {
+		return nil, fmt.Errorf("decoding header: %w", err)
+	}
+
+	kind := Kind(d.String())
+	if err := d.Err(); err != nil {
+		return nil, fmt.Errorf("decoding kind: %w", err)
+	}
+
+	switch kind {
+	case KindRing:
+		return NewFromBinary(bytes, options...)
+	case KindSharded:
+		return NewShardedFromBinary(bytes, options...)
+	case KindLog:
+		return NewLogFromBinary(bytes, options...)
+	case KindWeighted:
+		return NewWeightedFromBinary(bytes, options...)
+	default:
+		return nil, fmt.Errorf("unknown counter kind %q", kind)
+	}
+}
@@ -0,0 +1,206 @@
+package counter
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/clock"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/schema"
+)
+
+func TestNewKind(t *testing.T) {
+	tests := []struct {
+		kind     Kind
+		wantType reflect.Type
+		wantErr  bool
+	}{
+		{kind: "", wantType: reflect.TypeOf(&Counter{})},
+		{kind: KindRing, wantType: reflect.TypeOf(&Counter{})},
+		{kind: KindSharded, wantType: reflect.TypeOf(&Sharded{})},
+		{kind: KindLog, wantType: reflect.TypeOf(&Log{})},
+		{kind: KindWeighted, wantType: reflect.TypeOf(&Weighted{})},
+		{kind: "unknown", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.kind), func(t *testing.T) {
+			clk := clock.NewFake(time.Unix(0, 0))
+
+			c, err := NewKind(tt.kind, time.Second, 10, WithClock(clk), WithTickless())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewKind() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			c.IncreaseBy(3)
+			clk.Advance(500 * time.Millisecond)
+
+			bytes, err := c.MarshalJSON()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			restored, err := NewKindFromJSON(bytes, WithClock(clk), WithTickless())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := reflect.TypeOf(restored); got != tt.wantType {
+				t.Errorf("restored type = %v, want %v", got, tt.wantType)
+			}
+
+			// the weighted kind is an estimate, but at half of the
+			// first fixed window there is no previous one to weight
+			if got, want := restored.Value(), c.Value(); got != want || got != 3 {
+				t.Errorf("restored Value() = %d, Value() = %d, want 3", got, want)
+			}
+
+			// the weighted kind needs a further window to forget the previous one
+			clk.Advance(2 * time.Second)
+			if got := restored.Value(); got != 0 {
+				t.Errorf("after the window restored Value() = %d, want 0", got)
+			}
+		})
+	}
+}
+
+func TestExpiresIn(t *testing.T) {
+	exact := map[int64]time.Duration{1: 600 * time.Millisecond, 2: 600 * time.Millisecond, 3: 900 * time.Millisecond, 5: 900 * time.Millisecond}
+	tests := []struct {
+		kind Kind
+		want map[int64]time.Duration
+	}{
+		{kind: KindRing, want: exact},
+		// the increases pending at a tick are accounted in the oldest elapsed tick
+		{kind: KindSharded, want: map[int64]time.Duration{1: 600 * time.Millisecond, 5: 600 * time.Millisecond}},
+		{kind: KindLog, want: exact},
+		// the 5 increases of the current fixed window fade out during the next one
+		{kind: KindWeighted, want: map[int64]time.Duration{2: time.Second, 5: 1600 * time.Millisecond}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.kind), func(t *testing.T) {
+			clk := clock.NewFake(time.Unix(0, 0))
+			c, err := NewKind(tt.kind, time.Second, 10, WithClock(clk), WithTickless())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c.IncreaseBy(2)
+			clk.Advance(300 * time.Millisecond)
+			c.IncreaseBy(3)
+			clk.Advance(100 * time.Millisecond)
+
+			for n, want := range tt.want {
+				got, ok := c.ExpiresIn(n)
+				if !ok || got != want {
+					t.Errorf("ExpiresIn(%d) = %v, %v, want %v, true", n, got, ok, want)
+				}
+			}
+
+			if _, ok := c.ExpiresIn(6); ok {
+				t.Error("ExpiresIn(6) of 5 increases = true, want false")
+			}
+		})
+	}
+}
+
+func TestLog(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	l, err := NewLog(time.Second, WithClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l.IncreaseBy(2)
+	clk.Advance(300 * time.Millisecond)
+	l.IncreaseBy(3)
+	clk.Advance(300 * time.Millisecond)
+	l.Increase()
+
+	if got := l.DecreaseBy(2); got != 4 {
+		t.Errorf("DecreaseBy(2) = %d, want 4", got)
+	}
+
+	// the log is exact: the first increases expire exactly after the window
+	clk.Advance(399 * time.Millisecond)
+	if got := l.Value(); got != 4 {
+		t.Errorf("before the first expiration Value() = %d, want 4", got)
+	}
+	clk.Advance(time.Millisecond)
+	if got := l.Value(); got != 2 {
+		t.Errorf("after the first expiration Value() = %d, want 2", got)
+	}
+}
+
+func TestWeighted(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	w, err := NewWeighted(time.Second, WithClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w.IncreaseBy(100)
+	clk.Advance(time.Second)
+	w.IncreaseBy(10)
+
+	steps := []struct {
+		advance time.Duration
+		want    int64
+	}{
+		{advance: 0, want: 110},
+		{advance: 250 * time.Millisecond, want: 85},
+		{advance: 500 * time.Millisecond, want: 35},
+		{advance: 250 * time.Millisecond, want: 10},
+		{advance: 2 * time.Second, want: 0},
+	}
+	for i, step := range steps {
+		clk.Advance(step.advance)
+		if got := w.Value(); got != step.want {
+			t.Errorf("step %d: Value() = %d, want %d", i, got, step.want)
+		}
+	}
+}
+
+func TestNewKindFromJSON_Versions(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1600000000, 0))
+
+	// saved before the kind and the version were introduced
+	legacy := `{"windowDuration":1000000000,"counter":3,"prev_counter":1,"resolution":2,` +
+		`"counters":[1,0],"head":1,"tail":0,"at":"2020-09-13T12:26:40Z"}`
+
+	c, err := NewKindFromJSON([]byte(legacy), WithClock(clk), WithTickless())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.(*Counter); !ok {
+		t.Errorf("legacy counter restored as %T, want *Counter", c)
+	}
+	if got := c.Value(); got != 3 {
+		t.Errorf("legacy Value() = %d, want 3", got)
+	}
+
+	bytes, err := c.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(bytes), `"version":1`) {
+		t.Errorf("MarshalJSON() = %s, want version 1", bytes)
+	}
+
+	newer := strings.Replace(string(bytes), `"version":1`, `"version":2`, 1)
+	for name, restore := range map[string]func([]byte) error{
+		"kind":     func(b []byte) error { _, err := NewKindFromJSON(b); return err },
+		"ring":     func(b []byte) error { _, err := NewFromJSON(b); return err },
+		"sharded":  func(b []byte) error { _, err := NewShardedFromJSON(b); return err },
+		"log":      func(b []byte) error { _, err := NewLogFromJSON(b); return err },
+		"weighted": func(b []byte) error { _, err := NewWeightedFromJSON(b); return err },
+	} {
+		if err := restore([]byte(newer)); !errors.Is(err, schema.ErrUnsupportedVersion) {
+			t.Errorf("%s: restoring a newer version error = %v, want %v", name, err, schema.ErrUnsupportedVersion)
+		}
+	}
+}
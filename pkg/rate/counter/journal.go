@@ -0,0 +1,108 @@
+package counter
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/journal"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/store"
+)
+
+// Journaled is implemented by the counters able to replay a journal, see WithJournal
+type Journaled interface {
+	// Replay applies a record of the journal, unless the state already includes it
+	Replay(r journal.Record)
+	// JournalSeq returns the seq of the last journal record included in the state
+	JournalSeq() uint64
+}
+
+// journalPath returns the path of the journal enabled by WithJournal, if any
+func (cfg *config) journalPath() (string, bool) {
+	if !cfg.isJournalEnabled || !cfg.isPersistenceEnabled {
+		return "", false
+	}
+
+	locator, ok := cfg.stateStore.(store.Locator)
+	if !ok {
+		return "", false
+	}
+
+	return journal.Path(locator.Path(cfg.stateKey)), true
+}
+
+// createJournal creates the journal enabled by WithJournal, the records
+// left by a previous run are dropped at the first write
+func (cfg *config) createJournal() {
+	filePath, ok := cfg.journalPath()
+	if !ok {
+		return
+	}
+
+	cfg.journal = journal.Create(filePath, cfg.journalSync)
+}
+
+// openJournal opens the journal enabled by WithJournal and replays
+// its records on top of the state just restored in j
+func (cfg *config) openJournal(j Journaled) error {
+	filePath, ok := cfg.journalPath()
+	if !ok {
+		return nil
+	}
+
+	records, err := journal.Read(filePath)
+	if err != nil {
+		return fmt.Errorf("reading journal: %w", err)
+	}
+
+	for _, r := range records {
+		j.Replay(r)
+	}
+
+	cfg.journal = journal.Open(filePath, cfg.journalSync)
+	cfg.journal.Skip(j.JournalSeq())
+
+	return nil
+}
+
+// record appends to the journal, if any, n increases in the tick starting
+// at at. It returns the seq of the record, or seq if nothing is appended.
+//
+// a record that can not be appended is reported, see WithErrorHandler: the
+// increases are then only in the state, until it is saved
+func (cfg *config) record(seq uint64, at time.Time, n int64) uint64 {
+	if cfg.journal == nil || n == 0 {
+		return seq
+	}
+
+	appended, err := cfg.journal.Append(cfg.journalKey, at, n)
+	if err != nil {
+		cfg.fail(fmt.Errorf("journaling increases: %w", err))
+		return seq
+	}
+
+	return appended
+}
+
+// save saves the state of v in the persistence file, then drops
+// from the journal the records included in the state
+func (cfg *config) save(v stateMarshaler) error {
+	var seq uint64
+	if cfg.isJournalEnabled && cfg.journal != nil {
+		// the records appended before the marshalling are in the state
+		seq = cfg.journal.Seq()
+	}
+
+	if err := saveFormat(cfg.stateStore, cfg.stateKey, cfg.format, v); err != nil {
+		return err
+	}
+
+	if !cfg.isJournalEnabled || cfg.journal == nil {
+		return nil
+	}
+
+	if err := cfg.journal.Compact(seq); err != nil {
+		return fmt.Errorf("compacting journal: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package counter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/clock"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/journal"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/store"
+)
+
+func TestNewKindFromStore_Journal(t *testing.T) {
+	kinds := []Kind{KindRing, KindSharded, KindLog, KindWeighted}
+	policies := map[string]journal.Sync{
+		"always": journal.SyncAlways,
+		"batch":  journal.SyncBatch,
+		"none":   journal.SyncNone,
+	}
+
+	for _, kind := range kinds {
+		for name, policy := range policies {
+			t.Run(string(kind)+"/"+name, func(t *testing.T) {
+				clk := clock.NewFake(time.Unix(1600000000, 0))
+				states := store.NewFS(t.TempDir())
+				options := []Option{
+					WithClock(clk),
+					WithTickless(),
+					WithPersistence(states, "counter", time.Second),
+					WithJournal(policy, 100*time.Millisecond),
+				}
+
+				c, err := NewKind(kind, time.Second, 10, options...)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				c.IncreaseBy(3)
+				clk.Advance(100 * time.Millisecond)
+				if err := saveState(c); err != nil {
+					t.Fatal(err)
+				}
+
+				// increases after the save are only in the journal
+				c.IncreaseBy(4)
+				clk.Advance(200 * time.Millisecond)
+				c.IncreaseBy(2)
+				c.DecreaseBy(1)
+				// the sharded counter journals the increases at the next tick
+				clk.Advance(100 * time.Millisecond)
+				c.Value()
+				if err := journalOf(c).Flush(); err != nil {
+					t.Fatal(err)
+				}
+
+				restored, err := NewKindFromStore(states, "counter", options...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, want := restored.Value(), c.Value(); got != want || got != 8 {
+					t.Errorf("restored Value() = %d, Value() = %d, want 8", got, want)
+				}
+
+				// the journal goes on from the restored state
+				restored.IncreaseBy(1)
+				if err := saveState(restored); err != nil {
+					t.Fatal(err)
+				}
+				restored.IncreaseBy(1)
+				clk.Advance(100 * time.Millisecond)
+				restored.Value()
+				if err := journalOf(restored).Flush(); err != nil {
+					t.Fatal(err)
+				}
+
+				again, err := NewKindFromStore(states, "counter", options...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := again.Value(); got != 10 {
+					t.Errorf("restored twice Value() = %d, want 10", got)
+				}
+
+				clk.Advance(2 * time.Second)
+				if got := again.Value(); got != 0 {
+					t.Errorf("after the window Value() = %d, want 0", got)
+				}
+			})
+		}
+	}
+}
+
+// saveState saves the state of c as its persistence routine does
+func saveState(c Interface) error {
+	return c.(interface{ saveState() error }).saveState()
+}
+
+func journalOf(c Interface) *journal.Journal {
+	switch c := c.(type) {
+	case *Counter:
+		return c.journal
+	case *Sharded:
+		return c.ring.journal
+	case *Log:
+		return c.journal
+	case *Weighted:
+		return c.journal
+	default:
+		return nil
+	}
+}
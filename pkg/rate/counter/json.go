@@ -7,6 +7,8 @@ import (
 )
 
 type WindowCounterJSON struct {
+	Version     int           `json:"version"`
+	Kind        Kind          `json:"kind,omitempty"`
 	Duration    time.Duration `json:"windowDuration"`
 	Counter     int64         `json:"counter"`
 	PrevCounter int64         `json:"prev_counter"`
@@ -15,34 +17,54 @@ type WindowCounterJSON struct {
 	Head        int           `json:"head"`
 	Tail        int           `json:"tail"`
 	At          time.Time     `json:"at"`
+	JournalSeq  uint64        `json:"journal_seq,omitempty"`
 }
 
 func (c *Counter) MarshalJSON() ([]byte, error) {
 	c.m.Lock()
 	defer c.m.Unlock()
 
-	return json.Marshal(
-		WindowCounterJSON{
-			Duration:    c.windowDuration,
-			Counter:     c.counter,
-			PrevCounter: c.prevCounter,
-			Resolution:  c.resolution,
-			Counters:    c.counters,
-			Head:        c.head,
-			Tail:        c.tail,
-			At:          c.at,
-		},
-	)
+	if c.tickless {
+		c.advance()
+	}
+
+	return json.Marshal(c.toJSON())
+}
+
+// toJSON must be called with the lock held
+func (c *Counter) toJSON() WindowCounterJSON {
+	return WindowCounterJSON{
+		Version:     counterSchema.Version(),
+		Kind:        KindRing,
+		Duration:    c.windowDuration,
+		Counter:     c.counter,
+		PrevCounter: c.prevCounter,
+		Resolution:  c.resolution,
+		Counters:    c.counters,
+		Head:        c.head,
+		Tail:        c.tail,
+		At:          c.at,
+		JournalSeq:  c.journalSeq,
+	}
 }
 
 func (c *Counter) UnmarshalJSON(bytes []byte) error {
 	c.m.Lock()
 	defer c.m.Unlock()
 
+	bytes, err := counterSchema.Migrate(bytes)
+	if err != nil {
+		return err
+	}
+
 	cJSON := WindowCounterJSON{}
 
 	if err := json.Unmarshal(bytes, &cJSON); err != nil {
-		return fmt.Errorf("unmarshalling json: %v", err)
+		return fmt.Errorf("unmarshalling json: %w", err)
+	}
+
+	if err := validateState(cJSON.Duration, cJSON.Resolution, len(cJSON.Counters), cJSON.Head, cJSON.Tail); err != nil {
+		return err
 	}
 
 	c.windowDuration = cJSON.Duration
@@ -53,6 +75,7 @@ func (c *Counter) UnmarshalJSON(bytes []byte) error {
 	c.head = cJSON.Head
 	c.tail = cJSON.Tail
 	c.at = cJSON.At
+	c.journalSeq = cJSON.JournalSeq
 
 	return nil
 }
@@ -0,0 +1,29 @@
+package counter
+
+// Close stops the routines of Run, which save the state a last time if persistence
+// is enabled, and waits for them. It returns the error of the last save, if it failed.
+func (cfg *config) Close() error {
+	return cfg.life.Close()
+}
+
+// Wait waits for the routines of Run to stop, see Close
+func (cfg *config) Wait() error {
+	return cfg.life.Wait()
+}
+
+// Err returns the last failure of the routines of Run, nil if they recovered from it,
+// e.g. a save failed and the following retry succeeded
+func (cfg *config) Err() error {
+	return cfg.life.Err()
+}
+
+// fail reports a failure of the routines of Run, see WithErrorHandler
+func (cfg *config) fail(err error) {
+	cfg.life.Fail(err)
+
+	if cfg.errorHandler != nil {
+		cfg.errorHandler(err)
+		return
+	}
+	cfg.logf("counter %s: %v\n", cfg.stateKey, err)
+}
@@ -0,0 +1,148 @@
+package counter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/clock"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/store"
+)
+
+var errDiskFull = errors.New("disk full")
+
+// failingStore fails the saves while failing is set, it reports each save on saves
+type failingStore struct {
+	store.Store
+	m       sync.Mutex
+	failing bool
+	saves   chan error
+}
+
+func newFailingStore() *failingStore {
+	return &failingStore{Store: store.NewMemory(), saves: make(chan error, 16)}
+}
+
+func (s *failingStore) Save(key string, data []byte) error {
+	s.m.Lock()
+	var err error
+	if s.failing {
+		err = errDiskFull
+	} else {
+		err = s.Store.Save(key, data)
+	}
+	s.m.Unlock()
+
+	s.saves <- err
+	return err
+}
+
+func (s *failingStore) setFailing(failing bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.failing = failing
+}
+
+func TestCounter_SaveRetry(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	states := newFailingStore()
+	states.setFailing(true)
+
+	handled := make(chan error, 16)
+	c := Must(time.Minute, 60, WithClock(clk), WithTickless(),
+		WithPersistence(states, "counter", time.Second),
+		WithErrorHandler(func(err error) { handled <- err }))
+
+	done := make(chan error)
+	go func() {
+		done <- c.Run(context.Background())
+	}()
+	clk.BlockUntil(1)
+
+	c.IncreaseBy(3)
+
+	// the failed saves are retried after 100ms, then 200ms, instead of failing Run
+	advances := []time.Duration{time.Second, 100 * time.Millisecond}
+	for i, d := range advances {
+		clk.Advance(d)
+		if err := <-states.saves; !errors.Is(err, errDiskFull) {
+			t.Fatalf("save %d error = %v, want errDiskFull", i, err)
+		}
+		if err := <-handled; !errors.Is(err, errDiskFull) {
+			t.Errorf("handled error %d = %v, want errDiskFull", i, err)
+		}
+		if err := c.Err(); !errors.Is(err, errDiskFull) {
+			t.Errorf("save %d: Err() = %v, want errDiskFull", i, err)
+		}
+		// the save ticker and the retry
+		clk.BlockUntil(2)
+	}
+
+	states.setFailing(false)
+	clk.Advance(200 * time.Millisecond)
+	if err := <-states.saves; err != nil {
+		t.Fatalf("retried save error = %v", err)
+	}
+
+	c.IncreaseBy(2)
+
+	// the increases after the last save are saved by Close
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Run() error = %v", err)
+	}
+	if err := c.Err(); err != nil {
+		t.Errorf("Err() after a successful retry = %v, want nil", err)
+	}
+
+	restored, err := NewFromStore(states, "counter", WithClock(clk), WithTickless())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := restored.Value(); got != 5 {
+		t.Errorf("restored Value() = %d, want 5", got)
+	}
+}
+
+func TestCounter_Close(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	states := newFailingStore()
+
+	c := Must(time.Second, 10, WithClock(clk), WithPersistence(states, "counter", time.Second))
+
+	// nothing to wait for before Run
+	if err := c.Wait(); err != nil {
+		t.Errorf("Wait() before Run error = %v", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- c.Run(context.Background())
+	}()
+	// the tick and the save tickers
+	clk.BlockUntil(2)
+
+	// the final save fails
+	states.setFailing(true)
+	if err := c.Close(); !errors.Is(err, errDiskFull) {
+		t.Errorf("Close() error = %v, want errDiskFull", err)
+	}
+	if err := <-done; !errors.Is(err, errDiskFull) {
+		t.Errorf("Run() error = %v, want errDiskFull", err)
+	}
+	<-states.saves
+
+	// a closed counter does not run again
+	if err := c.Run(context.Background()); err != nil {
+		t.Errorf("Run() after Close error = %v", err)
+	}
+	select {
+	case <-states.saves:
+		t.Error("Run() after Close saved the state")
+	default:
+	}
+}
@@ -0,0 +1,246 @@
+package counter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/journal"
+)
+
+// Log keeps the timestamp of each increase in the past time period
+//
+// unlike Counter its value is exact at any time, but its memory grows with
+// the number of increases in the window: use it for low volumes
+type Log struct {
+	m              sync.Mutex
+	windowDuration time.Duration
+	counter        int64      // sum of the entries
+	entries        []logEntry // sorted by ascending time
+	journalSeq     uint64     // seq of the last journal record in the state, see WithJournal
+
+	config
+}
+
+type logEntry struct {
+	at time.Time
+	n  int64
+}
+
+// NewLog is the constructor of Log
+func NewLog(windowDuration time.Duration, options ...Option) (*Log, error) {
+	if windowDuration <= 0 {
+		return nil, fmt.Errorf("non positive windowDuration: %v", windowDuration)
+	}
+
+	l := &Log{
+		windowDuration: windowDuration,
+		config:         defaultConfig(),
+	}
+
+	for _, opt := range options {
+		opt(&l.config)
+	}
+
+	l.createJournal()
+
+	return l, nil
+}
+
+// NewLogFromJSON create a Log starting from a JSON input
+func NewLogFromJSON(bytes []byte, options ...Option) (*Log, error) {
+	l := &Log{
+		config: defaultConfig(),
+	}
+
+	if err := json.Unmarshal(bytes, l); err != nil {
+		return nil, fmt.Errorf("unmashalling JSON: %w", err)
+	}
+
+	for _, opt := range options {
+		opt(&l.config)
+	}
+
+	if err := l.openJournal(l); err != nil {
+		return nil, err
+	}
+	l.reconcile()
+	l.catchUp()
+
+	return l, nil
+}
+
+// NewLogFromBinary create a Log starting from a binary input
+func NewLogFromBinary(bytes []byte, options ...Option) (*Log, error) {
+	l := &Log{
+		config: defaultConfig(),
+	}
+
+	if err := l.UnmarshalBinary(bytes); err != nil {
+		return nil, fmt.Errorf("unmashalling binary: %w", err)
+	}
+
+	for _, opt := range options {
+		opt(&l.config)
+	}
+
+	if err := l.openJournal(l); err != nil {
+		return nil, err
+	}
+	l.reconcile()
+	l.catchUp()
+
+	return l, nil
+}
+
+// Run runs the the Log routine, the Log needs a routine only to save its state
+//
+// to stop this routine cancel the context or call Close
+func (l *Log) Run(ctx context.Context) error {
+	return run(ctx, &l.config, 0, nil, l.saveState)
+}
+
+// expire drops the entries out of the window. Must be called with the lock held.
+func (l *Log) expire() {
+	windowStart := l.clock.Now().Add(-l.windowDuration)
+
+	i := 0
+	for ; i < len(l.entries) && !l.entries[i].at.After(windowStart); i++ {
+		l.counter -= l.entries[i].n
+	}
+
+	l.entries = l.entries[i:]
+}
+
+func (l *Log) saveState() error {
+	// mutex lock is in MarshalJSON and MarshalBinary
+	return l.save(l)
+}
+
+// Value returns the number of increase received in the passed window
+func (l *Log) Value() int64 {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	l.expire()
+	return l.counter
+}
+
+// Rate returns the number of increase per seconds
+func (l *Log) Rate() float64 {
+	windowSeconds := float64(l.windowDuration) / float64(time.Second)
+	return float64(l.Value()) / windowSeconds
+}
+
+// Window returns the duration of the window
+func (l *Log) Window() time.Duration {
+	return l.windowDuration
+}
+
+// ExpiresIn returns the time until n of the increases in the window expire, see Interface.
+// Each increase expires exactly a window after it was made.
+func (l *Log) ExpiresIn(n int64) (time.Duration, bool) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	l.expire()
+
+	if n <= 0 {
+		return 0, true
+	}
+
+	var expired int64
+	for _, e := range l.entries {
+		expired += e.n
+		if expired >= n {
+			return maxDuration(0, e.at.Add(l.windowDuration).Sub(l.clock.Now())), true
+		}
+	}
+
+	return 0, false
+}
+
+// Increase increase the counter by one and returns the counter value
+func (l *Log) Increase() int64 {
+	return l.IncreaseBy(1)
+}
+
+// IncreaseBy increase the counter by n and returns the counter value
+func (l *Log) IncreaseBy(n int64) int64 {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	l.expire()
+
+	now := l.clock.Now()
+	l.entries = append(l.entries, logEntry{at: now, n: n})
+	l.counter += n
+	l.journalSeq = l.record(l.journalSeq, now, n)
+	return l.counter
+}
+
+// DecreaseBy decrease the counter by n and returns the counter value
+//
+// it is meant to refund previous increases: they are taken back
+// starting from the most recent one
+func (l *Log) DecreaseBy(n int64) int64 {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	l.expire()
+
+	before := l.counter
+	l.decreaseBy(n)
+	l.journalSeq = l.record(l.journalSeq, l.clock.Now(), l.counter-before)
+
+	return l.counter
+}
+
+// decreaseBy must be called with the lock held
+func (l *Log) decreaseBy(n int64) {
+	for i := len(l.entries) - 1; i >= 0 && n > 0; i-- {
+		take := minInt64(n, l.entries[i].n)
+		l.entries[i].n -= take
+		l.counter -= take
+		n -= take
+
+		if l.entries[i].n == 0 {
+			l.entries = l.entries[:i]
+		}
+	}
+}
+
+// Replay applies a record of the journal, see Journaled
+func (l *Log) Replay(r journal.Record) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if r.Seq <= l.journalSeq {
+		return
+	}
+	l.journalSeq = r.Seq
+
+	if r.Delta < 0 {
+		l.decreaseBy(-r.Delta)
+		return
+	}
+
+	// entries must stay sorted
+	at := r.At
+	if last := len(l.entries) - 1; last >= 0 && at.Before(l.entries[last].at) {
+		at = l.entries[last].at
+	}
+
+	l.entries = append(l.entries, logEntry{at: at, n: r.Delta})
+	l.counter += r.Delta
+}
+
+// JournalSeq returns the seq of the last journal record in the state, see Journaled
+func (l *Log) JournalSeq() uint64 {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	return l.journalSeq
+}
@@ -0,0 +1,82 @@
+package counter
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/wire"
+)
+
+func (l *Log) MarshalBinary() ([]byte, error) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	l.expire()
+
+	e := wire.NewEncoder()
+	e.String(string(KindLog))
+	e.Duration(l.windowDuration)
+
+	// entries are sorted, each one is stored as the delta from the previous one
+	prev := time.Time{}
+	if len(l.entries) > 0 {
+		prev = l.entries[0].at
+	}
+	e.Time(prev)
+
+	e.Uvarint(uint64(len(l.entries)))
+	for _, entry := range l.entries {
+		e.Duration(entry.at.Sub(prev))
+		e.Varint(entry.n)
+		prev = entry.at
+	}
+
+	e.Uvarint(l.journalSeq)
+
+	return e.Bytes(), nil
+}
+
+func (l *Log) UnmarshalBinary(bytes []byte) error {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	d, err := wire.NewDecoder(bytes)
+	if err != nil {
+		return fmt.Errorf("decoding header: %w", err)
+	}
+
+	if kind := Kind(d.String()); kind != KindLog {
+		return fmt.Errorf("decoding %q counter as a %q one", kind, KindLog)
+	}
+
+	windowDuration := d.Duration()
+	prev := d.Time()
+
+	var (
+		counter int64
+		entries []logEntry
+	)
+	for n := d.Uvarint(); n > 0 && d.Err() == nil; n-- {
+		entry := logEntry{at: prev.Add(d.Duration()), n: d.Varint()}
+		entries = append(entries, entry)
+		counter += entry.n
+		prev = entry.at
+	}
+
+	// states of version 1 have no journal
+	var journalSeq uint64
+	if d.Version() >= 2 {
+		journalSeq = d.Uvarint()
+	}
+
+	if err := d.Err(); err != nil {
+		return fmt.Errorf("decoding: %w", err)
+	}
+
+	l.windowDuration = windowDuration
+	l.counter = counter
+	l.entries = entries
+	l.journalSeq = journalSeq
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package counter
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type LogJSON struct {
+	Version    int            `json:"version"`
+	Kind       Kind           `json:"kind"`
+	Duration   time.Duration  `json:"windowDuration"`
+	Entries    []LogEntryJSON `json:"entries"`
+	JournalSeq uint64         `json:"journal_seq,omitempty"`
+}
+
+type LogEntryJSON struct {
+	At time.Time `json:"at"`
+	N  int64     `json:"n"`
+}
+
+func (l *Log) MarshalJSON() ([]byte, error) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	l.expire()
+
+	lJSON := LogJSON{
+		Version:    counterSchema.Version(),
+		Kind:       KindLog,
+		Duration:   l.windowDuration,
+		Entries:    make([]LogEntryJSON, len(l.entries)),
+		JournalSeq: l.journalSeq,
+	}
+	for i, e := range l.entries {
+		lJSON.Entries[i] = LogEntryJSON{At: e.at, N: e.n}
+	}
+
+	return json.Marshal(lJSON)
+}
+
+func (l *Log) UnmarshalJSON(bytes []byte) error {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	bytes, err := counterSchema.Migrate(bytes)
+	if err != nil {
+		return err
+	}
+
+	lJSON := LogJSON{}
+
+	if err := json.Unmarshal(bytes, &lJSON); err != nil {
+		return fmt.Errorf("unmarshalling json: %w", err)
+	}
+
+	l.windowDuration = lJSON.Duration
+	l.journalSeq = lJSON.JournalSeq
+	l.counter = 0
+	l.entries = make([]logEntry, len(lJSON.Entries))
+	for i, e := range lJSON.Entries {
+		l.entries[i] = logEntry{at: e.At, n: e.N}
+		l.counter += e.N
+	}
+
+	return nil
+}
@@ -0,0 +1,285 @@
+package counter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/store"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/wire"
+)
+
+// Multi counts a single stream of increases over several windows at once
+//
+// all the windows share the same circular buffer of per tick counters,
+// sized for the longest window. Each window keeps its own running sum,
+// so the value of every window is returned in O(1) and a tick costs
+// O(number of windows).
+type Multi struct {
+	m       sync.Mutex
+	period  time.Duration
+	windows []time.Duration // sorted ascending
+	sums    []int64         // sum of the ticks in each window, current tick excluded
+	pending int64           // increases in the current tick
+
+	counters []int64 // counters per tick, managed as a circular buffer
+	head     int     // position of the next tick
+	filled   int     // number of ticks in the buffer
+	at       time.Time
+
+	config
+}
+
+// NewMulti is the constructor of Multi
+//
+// each window must be a multiple of tickPeriod, a window lasting
+// n ticks behaves like a Counter with resolution n
+func NewMulti(tickPeriod time.Duration, windows []time.Duration, options ...Option) (*Multi, error) {
+	if tickPeriod < minPeriod {
+		return nil, fmt.Errorf("tickPeriod less than minimum tickPeriod: %v", tickPeriod)
+	}
+
+	if len(windows) == 0 {
+		return nil, fmt.Errorf("no windows")
+	}
+
+	sorted := make([]time.Duration, len(windows))
+	copy(sorted, windows)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	for i, w := range sorted {
+		if w <= 0 || w%tickPeriod != 0 {
+			return nil, fmt.Errorf("window %v is not a positive multiple of tickPeriod %v", w, tickPeriod)
+		}
+		if i > 0 && sorted[i-1] == w {
+			return nil, fmt.Errorf("duplicated window %v", w)
+		}
+	}
+
+	mc := &Multi{
+		period:   tickPeriod,
+		windows:  sorted,
+		sums:     make([]int64, len(sorted)),
+		counters: make([]int64, sorted[len(sorted)-1]/tickPeriod),
+		config:   defaultConfig(),
+	}
+
+	for _, opt := range options {
+		opt(&mc.config)
+	}
+
+	if mc.tickless {
+		mc.at = mc.clock.Now()
+	}
+
+	return mc, nil
+}
+
+// MustMulti is equal to NewMulti but panics if there is some error
+func MustMulti(tickPeriod time.Duration, windows []time.Duration, options ...Option) *Multi {
+	mc, err := NewMulti(tickPeriod, windows, options...)
+	if err != nil {
+		panic(err)
+	}
+	return mc
+}
+
+// NewMultiFromFile create a Multi starting from a state file
+//
+// the state file must be created by previously run of the Multi using
+// the WithPersistence option
+func NewMultiFromFile(filePath string, options ...Option) (*Multi, error) {
+	return NewMultiFromStore(store.NewFS(filepath.Dir(filePath)), filepath.Base(filePath), options...)
+}
+
+// NewMultiFromStore create a Multi starting from the state saved in s under key
+func NewMultiFromStore(s store.Store, key string, options ...Option) (*Multi, error) {
+	bytes, err := s.Load(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if wire.IsBinary(bytes) {
+		return NewMultiFromBinary(bytes, options...)
+	}
+
+	return NewMultiFromJSON(bytes, options...)
+}
+
+// NewMultiFromJSON create a Multi starting from a JSON input
+func NewMultiFromJSON(bytes []byte, options ...Option) (*Multi, error) {
+	mc := &Multi{
+		config: defaultConfig(),
+	}
+
+	if err := json.Unmarshal(bytes, mc); err != nil {
+		return nil, fmt.Errorf("unmashalling JSON: %w", err)
+	}
+
+	for _, opt := range options {
+		opt(&mc.config)
+	}
+
+	mc.catchUp()
+
+	return mc, nil
+}
+
+// NewMultiFromBinary create a Multi starting from a binary input, see MarshalBinary
+func NewMultiFromBinary(bytes []byte, options ...Option) (*Multi, error) {
+	mc := &Multi{
+		config: defaultConfig(),
+	}
+
+	if err := mc.UnmarshalBinary(bytes); err != nil {
+		return nil, fmt.Errorf("unmashalling binary: %w", err)
+	}
+
+	for _, opt := range options {
+		opt(&mc.config)
+	}
+
+	mc.catchUp()
+
+	return mc, nil
+}
+
+// Run runs the the Multi routine
+//
+// to stop this routine cancel the context or call Close
+func (mc *Multi) Run(ctx context.Context) error {
+	return run(ctx, &mc.config, mc.period, mc.tick, mc.saveState)
+}
+
+func (mc *Multi) tick() {
+	mc.m.Lock()
+	defer mc.m.Unlock()
+
+	mc.shift()
+	mc.at = mc.clock.Now()
+}
+
+// advance moves the windows of all the ticks elapsed since the last one,
+// it is the tickless equivalent of tick. Must be called with the lock held.
+func (mc *Multi) advance() {
+	elapsed := mc.clock.Now().Sub(mc.at)
+	if elapsed < mc.period {
+		return
+	}
+
+	n := uint64(elapsed / mc.period)
+	mc.shiftN(n)
+	mc.at = mc.at.Add(time.Duration(n) * mc.period)
+}
+
+// shiftN is equal to call shift n times, but it costs at most one pass over the buffer
+func (mc *Multi) shiftN(n uint64) {
+	size := uint64(len(mc.counters))
+
+	i := uint64(0)
+	for ; i < n && i < size; i++ {
+		mc.shift()
+	}
+
+	if i == n {
+		return
+	}
+
+	// after a whole buffer of shifts every window is empty,
+	// further shifts just move the head
+	for j := range mc.counters {
+		mc.counters[j] = 0
+	}
+	mc.head = (mc.head + int((n-i)%size)) % len(mc.counters)
+}
+
+// shift moves the windows of one tick. Must be called with the lock held.
+func (mc *Multi) shift() {
+	mc.counters[mc.head] = mc.pending
+	if mc.filled < len(mc.counters) {
+		mc.filled++
+	}
+
+	for i, w := range mc.windows {
+		mc.sums[i] += mc.pending
+
+		// a window of n ticks keeps the current tick and the n-1 previous ones
+		ticks := int(w / mc.period)
+		if mc.filled >= ticks {
+			expired := (mc.head - (ticks - 1) + len(mc.counters)) % len(mc.counters)
+			mc.sums[i] -= mc.counters[expired]
+		}
+	}
+
+	mc.head = (mc.head + 1) % len(mc.counters)
+	mc.pending = 0
+}
+
+func (mc *Multi) saveState() error {
+	// mutex lock is in MarshalJSON and MarshalBinary
+	return saveFormat(mc.stateStore, mc.stateKey, mc.format, mc)
+}
+
+// Windows returns the windows of the Multi, sorted ascending
+func (mc *Multi) Windows() []time.Duration {
+	windows := make([]time.Duration, len(mc.windows))
+	copy(windows, mc.windows)
+	return windows
+}
+
+// Value returns the number of increase received in the passed window,
+// false if window is not one of the Multi windows
+func (mc *Multi) Value(window time.Duration) (int64, bool) {
+	mc.m.Lock()
+	defer mc.m.Unlock()
+
+	if mc.tickless {
+		mc.advance()
+	}
+
+	for i, w := range mc.windows {
+		if w == window {
+			return mc.sums[i] + mc.pending, true
+		}
+	}
+
+	return 0, false
+}
+
+// Values returns the number of increase received in each window
+func (mc *Multi) Values() map[time.Duration]int64 {
+	mc.m.Lock()
+	defer mc.m.Unlock()
+
+	if mc.tickless {
+		mc.advance()
+	}
+
+	values := make(map[time.Duration]int64, len(mc.windows))
+	for i, w := range mc.windows {
+		values[w] = mc.sums[i] + mc.pending
+	}
+
+	return values
+}
+
+// Increase increase the counter by one
+func (mc *Multi) Increase() {
+	mc.IncreaseBy(1)
+}
+
+// IncreaseBy increase the counter by n
+func (mc *Multi) IncreaseBy(n int64) {
+	mc.m.Lock()
+	defer mc.m.Unlock()
+
+	if mc.tickless {
+		mc.advance()
+	}
+
+	mc.pending += n
+}
@@ -0,0 +1,72 @@
+package counter
+
+import (
+	"fmt"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/wire"
+)
+
+// multiTag identifies a Multi in the binary format, it is not a Kind
+const multiTag = "multi"
+
+// MarshalBinary encodes the Multi in the compact binary format of package wire
+func (mc *Multi) MarshalBinary() ([]byte, error) {
+	mc.m.Lock()
+	defer mc.m.Unlock()
+
+	if mc.tickless {
+		mc.advance()
+	}
+
+	e := wire.NewEncoder()
+	e.String(multiTag)
+	e.Duration(mc.period)
+
+	e.Uvarint(uint64(len(mc.windows)))
+	for _, w := range mc.windows {
+		e.Duration(w)
+	}
+
+	e.Varint(mc.pending)
+	e.Varints(mc.counters)
+	e.Uvarint(uint64(mc.head))
+	e.Uvarint(uint64(mc.filled))
+	e.Time(mc.at)
+
+	return e.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a Multi encoded by MarshalBinary
+func (mc *Multi) UnmarshalBinary(bytes []byte) error {
+	mc.m.Lock()
+	defer mc.m.Unlock()
+
+	d, err := wire.NewDecoder(bytes)
+	if err != nil {
+		return fmt.Errorf("decoding header: %w", err)
+	}
+
+	if tag := d.String(); tag != multiTag {
+		return fmt.Errorf("decoding %q as a %q", tag, multiTag)
+	}
+
+	mcJSON := MultiCounterJSON{
+		Period: d.Duration(),
+	}
+
+	for n := d.Uvarint(); n > 0 && d.Err() == nil; n-- {
+		mcJSON.Windows = append(mcJSON.Windows, d.Duration())
+	}
+
+	mcJSON.Pending = d.Varint()
+	mcJSON.Counters = d.Varints()
+	mcJSON.Head = int(d.Uvarint())
+	mcJSON.Filled = int(d.Uvarint())
+	mcJSON.At = d.Time()
+
+	if err := d.Err(); err != nil {
+		return fmt.Errorf("decoding: %w", err)
+	}
+
+	return mc.load(mcJSON)
+}
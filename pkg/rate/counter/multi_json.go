@@ -0,0 +1,96 @@
+package counter
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type MultiCounterJSON struct {
+	Version  int             `json:"version"`
+	Period   time.Duration   `json:"period"`
+	Windows  []time.Duration `json:"windows"`
+	Pending  int64           `json:"pending"`
+	Counters []int64         `json:"counters"`
+	Head     int             `json:"head"`
+	Filled   int             `json:"filled"`
+	At       time.Time       `json:"at"`
+}
+
+func (mc *Multi) MarshalJSON() ([]byte, error) {
+	mc.m.Lock()
+	defer mc.m.Unlock()
+
+	if mc.tickless {
+		mc.advance()
+	}
+
+	return json.Marshal(
+		MultiCounterJSON{
+			Version:  multiSchema.Version(),
+			Period:   mc.period,
+			Windows:  mc.windows,
+			Pending:  mc.pending,
+			Counters: mc.counters,
+			Head:     mc.head,
+			Filled:   mc.filled,
+			At:       mc.at,
+		},
+	)
+}
+
+func (mc *Multi) UnmarshalJSON(bytes []byte) error {
+	mc.m.Lock()
+	defer mc.m.Unlock()
+
+	bytes, err := multiSchema.Migrate(bytes)
+	if err != nil {
+		return err
+	}
+
+	mcJSON := MultiCounterJSON{}
+
+	if err := json.Unmarshal(bytes, &mcJSON); err != nil {
+		return fmt.Errorf("unmarshalling json: %w", err)
+	}
+
+	return mc.load(mcJSON)
+}
+
+// load sets the state of the Multi to a decoded one, it is shared by the
+// binary and the JSON decoders. Must be called with the lock held.
+func (mc *Multi) load(mcJSON MultiCounterJSON) error {
+	if len(mcJSON.Windows) == 0 || mcJSON.Period <= 0 {
+		return fmt.Errorf("%w: multi counter of period %v, windows %v", ErrInvalidState, mcJSON.Period, mcJSON.Windows)
+	}
+
+	longest := mcJSON.Windows[len(mcJSON.Windows)-1]
+	if size := int(longest / mcJSON.Period); len(mcJSON.Counters) != size ||
+		mcJSON.Head < 0 || mcJSON.Head >= size || mcJSON.Filled < 0 || mcJSON.Filled > size {
+		return fmt.Errorf("%w: multi counter buffer of len %d, head %d, filled %d",
+			ErrInvalidState, len(mcJSON.Counters), mcJSON.Head, mcJSON.Filled)
+	}
+
+	mc.period = mcJSON.Period
+	mc.windows = mcJSON.Windows
+	mc.pending = mcJSON.Pending
+	mc.counters = mcJSON.Counters
+	mc.head = mcJSON.Head
+	mc.filled = mcJSON.Filled
+	mc.at = mcJSON.At
+
+	// the sums are not stored, they are computed from the buffer
+	mc.sums = make([]int64, len(mc.windows))
+	for i, w := range mc.windows {
+		ticks := int(w/mc.period) - 1
+		if ticks > mc.filled {
+			ticks = mc.filled
+		}
+
+		for j := 1; j <= ticks; j++ {
+			mc.sums[i] += mc.counters[(mc.head-j+len(mc.counters))%len(mc.counters)]
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,170 @@
+package counter
+
+import (
+	"encoding/json"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/clock"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/store"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/wire"
+)
+
+func TestNewMulti(t *testing.T) {
+	tests := []struct {
+		name    string
+		period  time.Duration
+		windows []time.Duration
+		wantErr bool
+	}{
+		{
+			name:    "nominal",
+			period:  time.Second,
+			windows: []time.Duration{time.Hour, time.Minute, time.Second},
+		},
+		{
+			name:    "no windows",
+			period:  time.Second,
+			wantErr: true,
+		},
+		{
+			name:    "window not multiple of period",
+			period:  time.Second,
+			windows: []time.Duration{1500 * time.Millisecond},
+			wantErr: true,
+		},
+		{
+			name:    "duplicated window",
+			period:  time.Second,
+			windows: []time.Duration{time.Minute, time.Minute},
+			wantErr: true,
+		},
+		{
+			name:    "period really small",
+			period:  time.Nanosecond,
+			windows: []time.Duration{time.Second},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewMulti(tt.period, tt.windows)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewMulti() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMulti_MatchesCounters(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	period := 100 * time.Millisecond
+	windows := []time.Duration{period, 500 * time.Millisecond, time.Second, 3 * time.Second}
+
+	mc := MustMulti(period, windows, WithClock(clk), WithTickless())
+
+	counters := make(map[time.Duration]*Counter)
+	for _, w := range windows {
+		counters[w] = Must(w, uint64(w/period), WithClock(clk), WithTickless())
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		n := int64(r.Intn(5))
+		mc.IncreaseBy(n)
+		for _, c := range counters {
+			c.IncreaseBy(n)
+		}
+
+		nTicks := r.Intn(3)
+		if r.Intn(100) == 0 {
+			nTicks = 30 + r.Intn(30)
+		}
+		clk.Advance(time.Duration(nTicks) * period)
+
+		values := mc.Values()
+		for w, c := range counters {
+			if got, want := values[w], c.Value(); got != want {
+				t.Fatalf("step %d, window %v: Multi value = %d, Counter value = %d", i, w, got, want)
+			}
+		}
+	}
+}
+
+func TestMulti_JSON(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	period := 100 * time.Millisecond
+	windows := []time.Duration{500 * time.Millisecond, time.Second}
+
+	mc := MustMulti(period, windows, WithClock(clk))
+	for i := 0; i < 6; i++ {
+		mc.IncreaseBy(int64(i))
+		clk.Advance(period)
+		mc.tick()
+	}
+	mc.Increase()
+
+	bytes, err := json.Marshal(mc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := NewMultiFromJSON(bytes, WithClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := restored.Values(), mc.Values(); !reflect.DeepEqual(got, want) {
+		t.Errorf("restored Values() = %v, want %v", got, want)
+	}
+
+	// after the downtime only the longest window keeps the oldest ticks
+	clk.Advance(5 * period)
+	restored, err = NewMultiFromJSON(bytes, WithClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[time.Duration]int64{
+		500 * time.Millisecond: 0,
+		time.Second:            2 + 3 + 4 + 5 + 1,
+	}
+	if got := restored.Values(); !reflect.DeepEqual(got, want) {
+		t.Errorf("after downtime Values() = %v, want %v", got, want)
+	}
+}
+
+func TestMulti_Format(t *testing.T) {
+	for _, format := range []Format{FormatBinary, FormatJSON} {
+		clk := clock.NewFake(time.Unix(0, 0))
+		states := store.NewMemory()
+		options := []Option{WithClock(clk), WithTickless(), WithPersistence(states, "multi", time.Second), WithFormat(format)}
+
+		period := 100 * time.Millisecond
+		mc := MustMulti(period, []time.Duration{500 * time.Millisecond, time.Second}, options...)
+		mc.IncreaseBy(3)
+		clk.Advance(3 * period)
+		mc.IncreaseBy(2)
+
+		if err := mc.saveState(); err != nil {
+			t.Fatal(err)
+		}
+		bytes, err := states.Load("multi")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := wire.IsBinary(bytes), format == FormatBinary; got != want {
+			t.Errorf("format %d: saved binary %v, want %v", format, got, want)
+		}
+
+		restored, err := NewMultiFromStore(states, "multi", options...)
+		if err != nil {
+			t.Fatalf("format %d: %v", format, err)
+		}
+		if got, want := restored.Values(), mc.Values(); !reflect.DeepEqual(got, want) {
+			t.Errorf("format %d: restored Values() = %v, want %v", format, got, want)
+		}
+	}
+}
@@ -1,17 +1,170 @@
 package counter
 
-import "time"
+import (
+	"log"
+	"time"
 
-type Option func(c *Counter)
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/clock"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/journal"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/lifecycle"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/store"
+)
 
-// WithPersistence set the Counter to save its state in a file
+type Option func(c *config)
+
+// Format is the encoding of the saved state
+type Format int
+
+const (
+	// FormatBinary is the compact binary encoding, see package wire
+	FormatBinary Format = iota
+	// FormatJSON is the JSON encoding, bigger and slower but human readable
+	FormatJSON
+)
+
+// config is the configuration shared by all the counters
+type config struct {
+	clock clock.Clock
+
+	// tickless counters have no routine, they advance the window
+	// from the elapsed time on each access
+	tickless bool
+
+	// persistence
+	format               Format
+	stateStore           store.Store
+	stateKey             string
+	savePeriod           time.Duration
+	isPersistenceEnabled bool
+
+	// journal
+	journal            *journal.Journal
+	journalKey         string
+	journalSync        journal.Sync
+	journalFlushPeriod time.Duration
+	isJournalEnabled   bool
+
+	// window of restored counters, see WithWindow
+	window           time.Duration
+	windowResolution uint64
+	isWindowSet      bool
+
+	// restore, see WithClockPolicy
+	clockPolicy ClockPolicy
+	maxDowntime time.Duration
+	report      RestoreReport
+
+	logger *log.Logger
+
+	// routines of Run, see Close
+	life         lifecycle.Lifecycle
+	errorHandler func(err error)
+}
+
+func defaultConfig() config {
+	return config{
+		clock: clock.New(),
+	}
+}
+
+// WithPersistence set the Counter to save its state in a Store
 //
-// filePath is the path where the state file will be saved, the state
-// is stored each savePeriod, overwriting the previous saved state
-func WithPersistence(filePath string, savePeriod time.Duration) Option {
-	return func(c *Counter) {
-		c.persistenceFilePath = filePath
+// key is the key the state is saved under in s, the state is
+// stored each savePeriod, overwriting the previous saved state
+func WithPersistence(s store.Store, key string, savePeriod time.Duration) Option {
+	return func(c *config) {
+		c.stateStore = s
+		c.stateKey = key
 		c.savePeriod = savePeriod
 		c.isPersistenceEnabled = true
 	}
 }
+
+// WithClock set the Clock used by the Counter, by default the system clock is used
+func WithClock(clk clock.Clock) Option {
+	return func(c *config) {
+		c.clock = clk
+	}
+}
+
+// WithTickless set the Counter to run without a routine
+//
+// the window is advanced from the elapsed time on each Increase and Value,
+// catching up on all the ticks passed since the last call
+func WithTickless() Option {
+	return func(c *config) {
+		c.tickless = true
+	}
+}
+
+// WithFormat set the encoding of the state saved with WithPersistence, by default FormatBinary.
+// States are restored whatever their encoding.
+func WithFormat(format Format) Option {
+	return func(c *config) {
+		c.format = format
+	}
+}
+
+// WithJournal set the Counter to append its increases to a journal between the saves
+// of its state, the journal is replayed when the Counter is restored. It has effect
+// only together with WithPersistence on a store.Locator, e.g. store.FS: the journal
+// is kept next to the state file.
+//
+// policy is the sync policy of the journal, with journal.SyncBatch the
+// journal is flushed each flushPeriod
+func WithJournal(policy journal.Sync, flushPeriod time.Duration) Option {
+	return func(c *config) {
+		c.journalSync = policy
+		c.journalFlushPeriod = flushPeriod
+		c.isJournalEnabled = true
+	}
+}
+
+// WithSharedJournal set the counter to append its increases to j, with key as record key
+//
+// it is meant for the owners of many counters saved in a single state, e.g.
+// limiter.Map, which are in charge of flushing, compacting and replaying j
+func WithSharedJournal(j *journal.Journal, key string) Option {
+	return func(c *config) {
+		c.journal = j
+		c.journalKey = key
+	}
+}
+
+// WithWindow set the window of a restored counter: a state saved with a different
+// window or resolution is reconciled to them, e.g. the circular buffer is resampled.
+// resolution is ignored by the kinds without ticks, and counters created with New ignore both.
+func WithWindow(windowDuration time.Duration, resolution uint64) Option {
+	return func(c *config) {
+		c.window = windowDuration
+		c.windowResolution = resolution
+		c.isWindowSet = true
+	}
+}
+
+// WithLogger set the logger of the adjustments made to a restored counter, by default nothing is logged
+func WithLogger(logger *log.Logger) Option {
+	return func(c *config) {
+		c.logger = logger
+	}
+}
+
+// WithClockPolicy set how a restored counter handles a state saved at a time not
+// consistent with the clock, by default ClockClamp. A state is saved in the future
+// after the clock is stepped backwards, while a forward step is detected only if
+// maxDowntime is positive, as a state saved longer than maxDowntime ago.
+func WithClockPolicy(policy ClockPolicy, maxDowntime time.Duration) Option {
+	return func(c *config) {
+		c.clockPolicy = policy
+		c.maxDowntime = maxDowntime
+	}
+}
+
+// WithErrorHandler set a function called by the routine of Run at each failed save or
+// journal flush, which are retried. By default the failures are logged, see WithLogger.
+// The last failure is returned by Err until a save succeeds.
+func WithErrorHandler(handler func(err error)) Option {
+	return func(c *config) {
+		c.errorHandler = handler
+	}
+}
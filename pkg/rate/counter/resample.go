@@ -0,0 +1,149 @@
+package counter
+
+import (
+	"fmt"
+	"time"
+)
+
+// logf logs an adjustment made to a restored counter, see WithLogger
+func (cfg *config) logf(format string, args ...interface{}) {
+	if cfg.logger == nil {
+		return
+	}
+	cfg.logger.Printf(format, args...)
+}
+
+// reconcile resamples a restored Counter to the window set by WithWindow, if it differs
+func (c *Counter) reconcile() error {
+	if !c.isWindowSet {
+		return nil
+	}
+
+	windowDuration, resolution := c.window, c.windowResolution
+	if resolution == 0 {
+		resolution = c.resolution
+	}
+
+	if windowDuration == c.windowDuration && resolution == c.resolution {
+		return nil
+	}
+
+	if period := computePeriod(windowDuration, resolution); period < minPeriod {
+		return fmt.Errorf("tickPeriod less than minimum tickPeriod: %v", period)
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.logf("counter %s: resampling window %v with resolution %d to window %v with resolution %d\n",
+		c.stateKey, c.windowDuration, c.resolution, windowDuration, resolution)
+
+	c.resample(windowDuration, resolution)
+
+	return nil
+}
+
+// resample moves the ticks of the window in a buffer of resolution ticks, each
+// windowDuration/resolution long. The increases of each old tick are spread over
+// the new ticks it overlaps, those falling out of the new window are dropped.
+// Must be called with the lock held.
+func (c *Counter) resample(windowDuration time.Duration, resolution uint64) {
+	oldPeriod := computePeriod(c.windowDuration, c.resolution)
+	period := computePeriod(windowDuration, resolution)
+
+	series := c.snapshot(0)
+
+	// a longer current tick ends with the old one, so that the window keeps
+	// all the past ticks, a shorter one starts with it, so that it does not
+	// start in the future
+	at := series[len(series)-1].Start
+	if period > oldPeriod {
+		at = at.Add(oldPeriod - period)
+	}
+
+	// ticks[len-1] is the current tick, ticks[len-1-k] the one starting k periods before
+	ticks := make([]int64, resolution)
+	last := len(ticks) - 1
+
+	for _, p := range series {
+		if p.Count == 0 {
+			continue
+		}
+
+		from := p.Start.Sub(at)
+		to := from + oldPeriod
+
+		// largest multiple of period not greater than from
+		k := from / period
+		if k*period > from {
+			k--
+		}
+
+		// the share of each new tick is rounded on the cumulative
+		// overlap, so that the increases of the old tick are preserved
+		var overlap time.Duration
+		var assigned int64
+		for ; k*period < to; k++ {
+			start, end := k*period, (k+1)*period
+			if start < from {
+				start = from
+			}
+			if end > to {
+				end = to
+			}
+			overlap += end - start
+
+			share := int64(float64(p.Count)*float64(overlap)/float64(oldPeriod)+0.5) - assigned
+			assigned += share
+
+			// the part of the current old tick after the current new tick is not elapsed
+			i := last + int(k)
+			if i > last {
+				i = last
+			}
+			if i < 0 {
+				continue
+			}
+			ticks[i] += share
+		}
+	}
+
+	c.windowDuration = windowDuration
+	c.resolution = resolution
+
+	// the past ticks fill the buffer from its start, head is where
+	// the current one will be saved and tail = 0 the oldest one
+	c.counters = make([]int64, resolution)
+	copy(c.counters, ticks[:last])
+	c.head = last
+	c.tail = 0
+
+	c.counter = 0
+	for _, n := range ticks {
+		c.counter += n
+	}
+	c.prevCounter = c.counter - ticks[last]
+	c.at = at
+}
+
+// reconcile sets the window of a restored Log to the one set by WithWindow,
+// entries older than the new window expire at the next access
+func (l *Log) reconcile() {
+	if !l.isWindowSet || l.window <= 0 || l.window == l.windowDuration {
+		return
+	}
+
+	l.logf("log counter %s: changing window from %v to %v\n", l.stateKey, l.windowDuration, l.window)
+	l.windowDuration = l.window
+}
+
+// reconcile sets the window of a restored Weighted to the one set by WithWindow,
+// the current and previous counts are kept and weighted on the new window
+func (w *Weighted) reconcile() {
+	if !w.isWindowSet || w.window <= 0 || w.window == w.windowDuration {
+		return
+	}
+
+	w.logf("weighted counter %s: changing window from %v to %v\n", w.stateKey, w.windowDuration, w.window)
+	w.windowDuration = w.window
+}
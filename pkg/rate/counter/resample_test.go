@@ -0,0 +1,163 @@
+package counter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/clock"
+)
+
+func TestNewFromJSON_Window(t *testing.T) {
+	type window struct {
+		duration   time.Duration
+		resolution uint64
+	}
+	tests := []struct {
+		name     string
+		saved    window
+		restored window
+		// increases made in each tick of the saved window
+		perTick int64
+		// Value after the restore, and after advancing by advance
+		want        int64
+		advance     time.Duration
+		wantAdvance int64
+	}{
+		{
+			name:        "finer resolution",
+			saved:       window{time.Second, 10},
+			restored:    window{time.Second, 100},
+			perTick:     10,
+			want:        100,
+			advance:     time.Second + 10*time.Millisecond,
+			wantAdvance: 0,
+		},
+		{
+			name:        "coarser resolution",
+			saved:       window{time.Second, 100},
+			restored:    window{time.Second, 10},
+			perTick:     1,
+			want:        100,
+			advance:     time.Second,
+			wantAdvance: 0,
+		},
+		{
+			name:        "uneven resolution",
+			saved:       window{time.Second, 10},
+			restored:    window{time.Second, 7},
+			perTick:     3,
+			want:        30,
+			advance:     2 * time.Second,
+			wantAdvance: 0,
+		},
+		{
+			name:        "shrunk window",
+			saved:       window{2 * time.Second, 20},
+			restored:    window{time.Second, 10},
+			perTick:     1,
+			want:        10,
+			advance:     500 * time.Millisecond,
+			wantAdvance: 5,
+		},
+		{
+			name:        "extended window",
+			saved:       window{time.Second, 10},
+			restored:    window{2 * time.Second, 20},
+			perTick:     1,
+			want:        10,
+			advance:     time.Second,
+			wantAdvance: 10,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := clock.NewFake(time.Unix(1600000000, 0))
+
+			c := Must(tt.saved.duration, tt.saved.resolution, WithClock(clk), WithTickless())
+			period := computePeriod(tt.saved.duration, tt.saved.resolution)
+			for i := uint64(0); i < tt.saved.resolution; i++ {
+				if i > 0 {
+					clk.Advance(period)
+				}
+				c.IncreaseBy(tt.perTick)
+			}
+
+			bytes, err := c.MarshalJSON()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			restored, err := NewFromJSON(bytes, WithClock(clk), WithTickless(),
+				WithWindow(tt.restored.duration, tt.restored.resolution))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if restored.windowDuration != tt.restored.duration || restored.resolution != tt.restored.resolution ||
+				len(restored.counters) != int(tt.restored.resolution) {
+				t.Errorf("restored window %v with resolution %d and %d ticks, want %v with resolution %d",
+					restored.windowDuration, restored.resolution, len(restored.counters),
+					tt.restored.duration, tt.restored.resolution)
+			}
+
+			if got := restored.Value(); got != tt.want {
+				t.Errorf("Value() = %d, want %d", got, tt.want)
+			}
+
+			clk.Advance(tt.advance)
+			if got := restored.Value(); got != tt.wantAdvance {
+				t.Errorf("after %v Value() = %d, want %d", tt.advance, got, tt.wantAdvance)
+			}
+		})
+	}
+}
+
+func TestNewFromJSON_WindowTooSmall(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1600000000, 0))
+
+	bytes, err := Must(time.Second, 10, WithClock(clk)).MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewFromJSON(bytes, WithClock(clk), WithWindow(time.Second, 1e6)); err == nil {
+		t.Errorf("NewFromJSON() with a period below the minimum no error")
+	}
+}
+
+func TestNewKindFromJSON_Window(t *testing.T) {
+	for _, kind := range []Kind{KindSharded, KindLog, KindWeighted} {
+		t.Run(string(kind), func(t *testing.T) {
+			clk := clock.NewFake(time.Unix(1600000000, 0))
+
+			c, err := NewKind(kind, time.Second, 10, WithClock(clk), WithTickless())
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.IncreaseBy(3)
+			clk.Advance(100 * time.Millisecond)
+
+			bytes, err := c.MarshalJSON()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			restored, err := NewKindFromJSON(bytes, WithClock(clk), WithTickless(), WithWindow(2*time.Second, 20))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the increases are still in the extended window
+			clk.Advance(time.Second)
+			if got := restored.Value(); got != 3 {
+				t.Errorf("Value() = %d, want 3", got)
+			}
+
+			// the weighted kind forgets the increases after two windows
+			clk.Advance(3 * time.Second)
+			if got := restored.Value(); got != 0 {
+				t.Errorf("after the window Value() = %d, want 0", got)
+			}
+		})
+	}
+}
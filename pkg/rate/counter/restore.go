@@ -0,0 +1,270 @@
+package counter
+
+import (
+	"time"
+)
+
+// ClockPolicy is how a restore handles a state saved at a time not consistent with
+// the clock, i.e. after the clock has been stepped backwards or forwards
+type ClockPolicy int
+
+const (
+	// ClockClamp keeps the state and moves it to the clock: a state saved in the
+	// future is restored as saved now, a downtime longer than the maximum one is
+	// cut to it
+	ClockClamp ClockPolicy = iota
+	// ClockTrust keeps the state as saved: a state saved in the future does not move
+	// until the clock reaches it, a long downtime expires all the increases
+	ClockTrust
+	// ClockReset discards the state, the counter restarts from an empty window
+	ClockReset
+)
+
+func (p ClockPolicy) String() string {
+	switch p {
+	case ClockClamp:
+		return "clamp"
+	case ClockTrust:
+		return "trust"
+	case ClockReset:
+		return "reset"
+	default:
+		return "unknown"
+	}
+}
+
+// ClockAnomaly is the inconsistency between the time of a restored state and the clock
+type ClockAnomaly int
+
+const (
+	// ClockConsistent is a state saved in the past, no longer than the maximum downtime ago
+	ClockConsistent ClockAnomaly = iota
+	// ClockBackward is a state saved in the future
+	ClockBackward
+	// ClockForward is a state saved longer than the maximum downtime ago
+	ClockForward
+)
+
+func (a ClockAnomaly) String() string {
+	switch a {
+	case ClockConsistent:
+		return "consistent"
+	case ClockBackward:
+		return "backward"
+	case ClockForward:
+		return "forward"
+	default:
+		return "unknown"
+	}
+}
+
+// RestoreReport describes what a restore did to catch up with the clock
+type RestoreReport struct {
+	// MissedTicks is the number of ticks elapsed since the state was saved
+	MissedTicks uint64
+	// Expired is the number of increases expired since the state was saved
+	Expired int64
+	// Discarded is the number of increases discarded by ClockReset
+	Discarded int64
+	// Anomaly is the clock anomaly detected, if any, see WithClockPolicy
+	Anomaly ClockAnomaly
+	// Skew is how far the clock was stepped: the time the state is in the future
+	// for ClockBackward, the downtime beyond the maximum one for ClockForward
+	Skew time.Duration
+}
+
+// RestoreReport returns the report of the restore of the counter, it is empty
+// for counters not restored from a state
+func (cfg *config) RestoreReport() RestoreReport {
+	return cfg.report
+}
+
+// restoreClock checks the time at of a restored state against the clock and applies
+// the ClockPolicy: it returns the time the state is moved to, and whether the state
+// must be discarded. The anomaly, if any, is recorded in the report.
+func (cfg *config) restoreClock(at time.Time) (time.Time, bool) {
+	now := cfg.clock.Now()
+	elapsed := now.Sub(at)
+
+	switch {
+	case elapsed < 0:
+		cfg.report.Anomaly = ClockBackward
+		cfg.report.Skew = -elapsed
+	case cfg.maxDowntime > 0 && elapsed > cfg.maxDowntime:
+		cfg.report.Anomaly = ClockForward
+		cfg.report.Skew = elapsed - cfg.maxDowntime
+	default:
+		return at, false
+	}
+
+	cfg.logf("counter %s: clock stepped %v by %v since the state was saved, applying the %v policy\n",
+		cfg.stateKey, cfg.report.Anomaly, cfg.report.Skew, cfg.clockPolicy)
+
+	switch cfg.clockPolicy {
+	case ClockTrust:
+		return at, false
+	case ClockReset:
+		return now, true
+	}
+
+	if cfg.report.Anomaly == ClockBackward {
+		return now, false
+	}
+	return now.Add(-cfg.maxDowntime), false
+}
+
+// catchUp moves the window of the ticks missed since the state was saved, in
+// at most one pass over the buffer whatever the downtime
+func (c *Counter) catchUp() {
+	if c.at.IsZero() {
+		// never ticked
+		return
+	}
+
+	at, reset := c.restoreClock(c.at)
+	if reset {
+		c.report.Discarded = c.counter
+		c.counter, c.prevCounter = 0, 0
+		c.counters = make([]int64, len(c.counters))
+		c.head, c.tail = 0, 0
+		c.at = at
+		return
+	}
+
+	before := c.counter
+	c.at = at
+
+	period := computePeriod(c.windowDuration, c.resolution)
+	if elapsed := c.clock.Now().Sub(c.at); elapsed >= period {
+		n := uint64(elapsed / period)
+		c.shiftN(n)
+		c.at = c.at.Add(time.Duration(n) * period)
+		c.report.MissedTicks = n
+	}
+
+	c.report.Expired = before - c.counter
+}
+
+// catchUp is the Histogram equivalent of Counter.catchUp
+func (h *Histogram) catchUp() {
+	if h.at.IsZero() {
+		// never ticked
+		return
+	}
+
+	at, reset := h.restoreClock(h.at)
+	if reset {
+		h.report.Discarded = sum(h.window)
+		h.window = make([]int64, len(h.window))
+		h.current = make([]int64, len(h.current))
+		for i := range h.ticks {
+			h.ticks[i] = make([]int64, len(h.current))
+		}
+		h.head, h.tail = 0, 0
+		h.at = at
+		return
+	}
+
+	before := sum(h.window)
+	h.at = at
+
+	period := computePeriod(h.windowDuration, h.resolution)
+	if elapsed := h.clock.Now().Sub(h.at); elapsed >= period {
+		n := uint64(elapsed / period)
+		h.shiftN(n)
+		h.at = h.at.Add(time.Duration(n) * period)
+		h.report.MissedTicks = n
+	}
+
+	h.report.Expired = before - sum(h.window)
+}
+
+// catchUp is the Multi equivalent of Counter.catchUp, the
+// expired increases are the ones of the longest window
+func (mc *Multi) catchUp() {
+	if mc.at.IsZero() {
+		// never ticked
+		return
+	}
+
+	longest := len(mc.sums) - 1
+
+	at, reset := mc.restoreClock(mc.at)
+	if reset {
+		mc.report.Discarded = mc.sums[longest] + mc.pending
+		mc.sums = make([]int64, len(mc.sums))
+		mc.pending = 0
+		mc.counters = make([]int64, len(mc.counters))
+		mc.head, mc.filled = 0, 0
+		mc.at = at
+		return
+	}
+
+	before := mc.sums[longest] + mc.pending
+	mc.at = at
+
+	if elapsed := mc.clock.Now().Sub(mc.at); elapsed >= mc.period {
+		n := uint64(elapsed / mc.period)
+		mc.shiftN(n)
+		mc.at = mc.at.Add(time.Duration(n) * mc.period)
+		mc.report.MissedTicks = n
+	}
+
+	mc.report.Expired = before - (mc.sums[longest] + mc.pending)
+}
+
+// catchUp applies the ClockPolicy to the entries of a restored Log
+// and drops the ones expired since the state was saved
+func (l *Log) catchUp() {
+	if len(l.entries) == 0 {
+		return
+	}
+
+	last := l.entries[len(l.entries)-1].at
+	at, reset := l.restoreClock(last)
+	if reset {
+		l.report.Discarded = l.counter
+		l.entries = nil
+		l.counter = 0
+		return
+	}
+
+	if shift := at.Sub(last); shift != 0 {
+		for i := range l.entries {
+			l.entries[i].at = l.entries[i].at.Add(shift)
+		}
+	}
+
+	before := l.counter
+	l.expire()
+	l.report.Expired = before - l.counter
+}
+
+// catchUp applies the ClockPolicy to the fixed windows of a restored
+// Weighted and rolls them to the clock
+func (w *Weighted) catchUp() {
+	if w.start.IsZero() {
+		return
+	}
+
+	at, reset := w.restoreClock(w.start)
+	if reset {
+		w.report.Discarded = w.prev + w.curr
+		w.prev, w.curr = 0, 0
+		w.start = at
+		return
+	}
+
+	before := w.prev + w.curr
+	w.start = at
+	w.roll(w.clock.Now())
+	w.report.Expired = before - (w.prev + w.curr)
+}
+
+func sum(values []int64) int64 {
+	var s int64
+	for _, v := range values {
+		s += v
+	}
+	return s
+}
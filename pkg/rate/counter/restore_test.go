@@ -0,0 +1,156 @@
+package counter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/clock"
+)
+
+func TestNewFromJSON_Downtime(t *testing.T) {
+	saved := time.Unix(1600000000, 0)
+
+	c := Must(time.Second, 1000, WithClock(clock.NewFake(saved)))
+	c.IncreaseBy(5)
+	c.tick()
+	c.IncreaseBy(2)
+
+	bytes, err := c.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a day of downtime is caught up in a single pass over the buffer
+	clk := clock.NewFake(saved.Add(24 * time.Hour))
+	start := time.Now()
+	restored, err := NewFromJSON(bytes, WithClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("restore took %v", elapsed)
+	}
+
+	want := RestoreReport{MissedTicks: 24 * 3600 * 1000, Expired: 7}
+	if got := restored.RestoreReport(); got != want {
+		t.Errorf("RestoreReport() = %+v, want %+v", got, want)
+	}
+	if got := restored.Value(); got != 0 {
+		t.Errorf("Value() = %d, want 0", got)
+	}
+	if !restored.at.Equal(clk.Now()) {
+		t.Errorf("at = %v, want %v", restored.at, clk.Now())
+	}
+}
+
+func TestNewKindFromJSON_ClockPolicy(t *testing.T) {
+	saved := time.Unix(1600000000, 0)
+
+	tests := []struct {
+		name        string
+		policy      ClockPolicy
+		maxDowntime time.Duration
+		restoredAt  time.Time
+		want        RestoreReport
+		// Value right after the restore, and after a window
+		wantValue  int64
+		wantWindow int64
+	}{
+		{
+			name:       "backward clamp",
+			policy:     ClockClamp,
+			restoredAt: saved.Add(-time.Hour),
+			want:       RestoreReport{Anomaly: ClockBackward, Skew: time.Hour},
+			wantValue:  3,
+			wantWindow: 0,
+		},
+		{
+			name:       "backward trust",
+			policy:     ClockTrust,
+			restoredAt: saved.Add(-time.Hour),
+			want:       RestoreReport{Anomaly: ClockBackward, Skew: time.Hour},
+			wantValue:  3,
+			// the increases wait for the clock to reach the time they were saved
+			wantWindow: 3,
+		},
+		{
+			name:       "backward reset",
+			policy:     ClockReset,
+			restoredAt: saved.Add(-time.Hour),
+			want:       RestoreReport{Discarded: 3, Anomaly: ClockBackward, Skew: time.Hour},
+			wantValue:  0,
+			wantWindow: 0,
+		},
+		{
+			name:        "forward clamp",
+			policy:      ClockClamp,
+			maxDowntime: 500 * time.Millisecond,
+			restoredAt:  saved.Add(time.Hour),
+			want:        RestoreReport{Anomaly: ClockForward, Skew: time.Hour - 500*time.Millisecond},
+			wantValue:   3,
+			wantWindow:  0,
+		},
+		{
+			name:        "forward reset",
+			policy:      ClockReset,
+			maxDowntime: 500 * time.Millisecond,
+			restoredAt:  saved.Add(time.Hour),
+			want:        RestoreReport{Discarded: 3, Anomaly: ClockForward, Skew: time.Hour - 500*time.Millisecond},
+			wantValue:   0,
+			wantWindow:  0,
+		},
+		{
+			name:        "no anomaly",
+			policy:      ClockReset,
+			maxDowntime: time.Hour,
+			restoredAt:  saved.Add(200 * time.Millisecond),
+			want:        RestoreReport{},
+			wantValue:   3,
+			wantWindow:  0,
+		},
+	}
+	for _, kind := range []Kind{KindRing, KindSharded, KindLog, KindWeighted} {
+		for _, tt := range tests {
+			t.Run(string(kind)+"/"+tt.name, func(t *testing.T) {
+				savedClk := clock.NewFake(saved)
+				c, err := NewKind(kind, time.Second, 10, WithClock(savedClk), WithTickless())
+				if err != nil {
+					t.Fatal(err)
+				}
+				c.IncreaseBy(3)
+				// the sharded kind accounts the increases once their tick is over
+				savedClk.Advance(100 * time.Millisecond)
+
+				bytes, err := c.MarshalJSON()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				clk := clock.NewFake(tt.restoredAt)
+				restored, err := NewKindFromJSON(bytes, WithClock(clk), WithTickless(),
+					WithClockPolicy(tt.policy, tt.maxDowntime))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				// the time of the state is the last tick for the ring kinds,
+				// the last increase or window start for the others
+				got := restored.RestoreReport()
+				if got.Anomaly != tt.want.Anomaly || got.Discarded != tt.want.Discarded ||
+					got.Skew < tt.want.Skew-100*time.Millisecond || got.Skew > tt.want.Skew+100*time.Millisecond {
+					t.Errorf("RestoreReport() = %+v, want %+v", got, tt.want)
+				}
+
+				if got := restored.Value(); got != tt.wantValue {
+					t.Errorf("Value() = %d, want %d", got, tt.wantValue)
+				}
+
+				// two windows, so that the weighted kind forgets too
+				clk.Advance(2 * time.Second)
+				if got := restored.Value(); got != tt.wantWindow {
+					t.Errorf("after the window Value() = %d, want %d", got, tt.wantWindow)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package counter
+
+import (
+	"encoding/json"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/schema"
+)
+
+// schemas of the JSON documents, documents of older versions are migrated when unmarshalled
+var (
+	// counterSchema is shared by all the kinds of Interface
+	counterSchema   = schema.New("counter", 1).Register(0, migrateCounterV0)
+	multiSchema     = schema.New("multi counter", 1).Register(0, schema.Unchanged)
+	histogramSchema = schema.New("histogram", 1).Register(0, schema.Unchanged)
+)
+
+// migrateCounterV0 sets the kind of the counters saved before the kinds were
+// introduced, which are all ring counters
+func migrateCounterV0(doc schema.Document) error {
+	if _, ok := doc["kind"]; !ok {
+		doc["kind"] = json.RawMessage(`"` + KindRing + `"`)
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+package counter
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/journal"
+)
+
+// cacheLineSize is used to pad the shards, so that each of them
+// lives in its own cache line and the cores do not contend for it
+const cacheLineSize = 64
+
+// Sharded is a Counter for high throughput
+//
+// Increase never takes a lock: increments are spread over atomic cells, about
+// one per CPU, which are folded into the circular buffer at each tick. For this
+// reason Value is exact as of the last tick, the increments of the current tick
+// are accounted only once the tick is over.
+type Sharded struct {
+	ring   *Counter // the window, accessed with ring.m held
+	shards []shard
+	value  int64 // window value at the last tick, accessed atomically
+	next   int64 // unix nano of the next tick for tickless counters, accessed atomically
+
+	nextShard uint32
+	shardIdx  sync.Pool
+}
+
+type shard struct {
+	n int64
+	_ [cacheLineSize - 8]byte
+}
+
+// NewSharded is the constructor of Sharded
+func NewSharded(windowDuration time.Duration, resolution uint64, options ...Option) (*Sharded, error) {
+	ring, err := New(windowDuration, resolution, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSharded(ring), nil
+}
+
+// MustSharded is equal to NewSharded but panics if there is some error
+func MustSharded(windowDuration time.Duration, resolution uint64, options ...Option) *Sharded {
+	s, err := NewSharded(windowDuration, resolution, options...)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// NewShardedFromJSON create a Sharded starting from a JSON input
+//
+// the input has the same format of the Counter one, so that a state
+// saved by a Counter can be restored in a Sharded and vice versa
+func NewShardedFromJSON(bytes []byte, options ...Option) (*Sharded, error) {
+	ring, err := NewFromJSON(bytes, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSharded(ring), nil
+}
+
+// NewShardedFromBinary create a Sharded starting from a binary input,
+// which has the same format of the Counter one, see NewShardedFromJSON
+func NewShardedFromBinary(bytes []byte, options ...Option) (*Sharded, error) {
+	ring, err := NewFromBinary(bytes, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSharded(ring), nil
+}
+
+// RestoreReport returns the report of the restore of the Sharded, see Interface
+func (s *Sharded) RestoreReport() RestoreReport {
+	return s.ring.RestoreReport()
+}
+
+// Close stops the routines of Run and waits for them, see Counter.Close
+func (s *Sharded) Close() error {
+	return s.ring.Close()
+}
+
+// Wait waits for the routines of Run to stop, see Counter.Wait
+func (s *Sharded) Wait() error {
+	return s.ring.Wait()
+}
+
+// Err returns the last failure of the routines of Run, see Counter.Err
+func (s *Sharded) Err() error {
+	return s.ring.Err()
+}
+
+func newSharded(ring *Counter) *Sharded {
+	s := &Sharded{}
+	s.init(ring)
+	return s
+}
+
+func (s *Sharded) init(ring *Counter) {
+	s.ring = ring
+	s.shards = make([]shard, runtime.GOMAXPROCS(0))
+
+	// sync.Pool keeps a cache per P, so a routine mostly gets back the
+	// index used by the previous routine that ran on the same P
+	s.shardIdx.New = func() interface{} {
+		idx := int(atomic.AddUint32(&s.nextShard, 1)-1) % len(s.shards)
+		return &idx
+	}
+
+	s.ring.m.Lock()
+	s.publish()
+	s.ring.m.Unlock()
+}
+
+// Run runs the the Sharded routine
+//
+// to stop this routine cancel the context or call Close
+func (s *Sharded) Run(ctx context.Context) error {
+	period := computePeriod(s.ring.windowDuration, s.ring.resolution)
+	return run(ctx, &s.ring.config, period, s.tick, s.saveState)
+}
+
+func (s *Sharded) tick() {
+	s.ring.m.Lock()
+	defer s.ring.m.Unlock()
+
+	s.fold()
+	s.ring.shift()
+	s.ring.at = s.ring.clock.Now()
+	s.publish()
+}
+
+// advance is the tickless equivalent of tick
+func (s *Sharded) advance() {
+	s.ring.m.Lock()
+	defer s.ring.m.Unlock()
+
+	// pending increments belong to the oldest elapsed tick
+	s.fold()
+	s.ring.advance()
+	s.publish()
+}
+
+// fold moves the pending increments in the current tick of the circular
+// buffer, they are journaled at once. Must be called with the ring lock held.
+func (s *Sharded) fold() {
+	var n int64
+	for i := range s.shards {
+		n += atomic.SwapInt64(&s.shards[i].n, 0)
+	}
+
+	s.ring.counter += n
+	s.ring.journalSeq = s.ring.record(s.ring.journalSeq, s.ring.tickStart(), n)
+}
+
+// publish exposes the state of the circular buffer to the lock free
+// readers. Must be called with the ring lock held.
+func (s *Sharded) publish() {
+	// prevCounter is the value of the window at the last tick
+	atomic.StoreInt64(&s.value, s.ring.prevCounter)
+
+	period := computePeriod(s.ring.windowDuration, s.ring.resolution)
+	atomic.StoreInt64(&s.next, s.ring.at.Add(period).UnixNano())
+}
+
+func (s *Sharded) advanceIfElapsed() {
+	if !s.ring.tickless {
+		return
+	}
+
+	if s.ring.clock.Now().UnixNano() >= atomic.LoadInt64(&s.next) {
+		s.advance()
+	}
+}
+
+func (s *Sharded) saveState() error {
+	// mutex lock is in MarshalJSON and MarshalBinary
+	return s.ring.save(s)
+}
+
+// Value returns the number of increase received in the passed window,
+// as of the last tick
+func (s *Sharded) Value() int64 {
+	s.advanceIfElapsed()
+
+	return atomic.LoadInt64(&s.value)
+}
+
+// Rate returns the number of increase per seconds, as of the last tick
+func (s *Sharded) Rate() float64 {
+	windowSeconds := float64(s.ring.windowDuration) / float64(time.Second)
+	return float64(s.Value()) / windowSeconds
+}
+
+// Increase increase the counter by one and returns the counter value as of the last tick
+func (s *Sharded) Increase() int64 {
+	return s.IncreaseBy(1)
+}
+
+// IncreaseBy increase the counter by n and returns the counter value as of the last tick
+func (s *Sharded) IncreaseBy(n int64) int64 {
+	idx := s.shardIdx.Get().(*int)
+	atomic.AddInt64(&s.shards[*idx].n, n)
+	s.shardIdx.Put(idx)
+
+	return s.Value()
+}
+
+// DecreaseBy decrease the counter by n and returns the counter value as of the last tick
+//
+// unlike increases, refunds take the lock, see Counter.DecreaseBy
+func (s *Sharded) DecreaseBy(n int64) int64 {
+	s.ring.m.Lock()
+	defer s.ring.m.Unlock()
+
+	s.fold()
+	if s.ring.tickless {
+		s.ring.advance()
+	}
+	before := s.ring.counter
+	s.ring.decreaseBy(n)
+	s.ring.journalSeq = s.ring.record(s.ring.journalSeq, s.ring.tickStart(), s.ring.counter-before)
+	s.publish()
+
+	return atomic.LoadInt64(&s.value)
+}
+
+// Window returns the duration of the window
+func (s *Sharded) Window() time.Duration {
+	return s.ring.windowDuration
+}
+
+// ExpiresIn returns the time until n of the increases in the window expire, see Counter.ExpiresIn
+//
+// increases of the current tick are included
+func (s *Sharded) ExpiresIn(n int64) (time.Duration, bool) {
+	s.ring.m.Lock()
+	defer s.ring.m.Unlock()
+
+	s.fold()
+	if s.ring.tickless {
+		s.ring.advance()
+		s.publish()
+	}
+
+	return s.ring.expiresIn(n)
+}
+
+// Snapshot returns the series of the ticks in the window, see Counter.Snapshot
+//
+// increases of the current tick are included
+func (s *Sharded) Snapshot(points int) []Point {
+	s.ring.m.Lock()
+	defer s.ring.m.Unlock()
+
+	s.fold()
+	if s.ring.tickless {
+		s.ring.advance()
+		s.publish()
+	}
+
+	return s.ring.snapshot(points)
+}
+
+// Replay applies a record of the journal, see Journaled
+func (s *Sharded) Replay(r journal.Record) {
+	s.ring.m.Lock()
+	defer s.ring.m.Unlock()
+
+	if r.Seq <= s.ring.journalSeq {
+		return
+	}
+
+	s.fold()
+	s.ring.replay(r.At, r.Delta)
+	s.ring.journalSeq = r.Seq
+	s.publish()
+}
+
+// JournalSeq returns the seq of the last journal record in the state, see Journaled
+func (s *Sharded) JournalSeq() uint64 {
+	return s.ring.JournalSeq()
+}
@@ -0,0 +1,45 @@
+package counter
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/wire"
+)
+
+func (s *Sharded) MarshalBinary() ([]byte, error) {
+	s.ring.m.Lock()
+	defer s.ring.m.Unlock()
+
+	// see MarshalJSON
+	s.fold()
+	if s.ring.tickless {
+		s.ring.advance()
+		s.publish()
+	}
+
+	e := wire.NewEncoder()
+	s.ring.encode(e, KindSharded)
+
+	return e.Bytes(), nil
+}
+
+func (s *Sharded) UnmarshalBinary(bytes []byte) error {
+	if s.ring == nil {
+		s.init(&Counter{config: defaultConfig()})
+	}
+
+	if err := s.ring.UnmarshalBinary(bytes); err != nil {
+		return fmt.Errorf("unmarshalling ring: %w", err)
+	}
+
+	s.ring.m.Lock()
+	defer s.ring.m.Unlock()
+
+	for i := range s.shards {
+		atomic.StoreInt64(&s.shards[i].n, 0)
+	}
+	s.publish()
+
+	return nil
+}
@@ -0,0 +1,45 @@
+package counter
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+)
+
+func (s *Sharded) MarshalJSON() ([]byte, error) {
+	s.ring.m.Lock()
+	defer s.ring.m.Unlock()
+
+	// folding does not change the window, pending increments
+	// are moved in the current tick of the circular buffer
+	s.fold()
+	if s.ring.tickless {
+		s.ring.advance()
+		s.publish()
+	}
+
+	cJSON := s.ring.toJSON()
+	cJSON.Kind = KindSharded
+
+	return json.Marshal(cJSON)
+}
+
+func (s *Sharded) UnmarshalJSON(bytes []byte) error {
+	if s.ring == nil {
+		s.init(&Counter{config: defaultConfig()})
+	}
+
+	if err := s.ring.UnmarshalJSON(bytes); err != nil {
+		return fmt.Errorf("unmarshalling ring: %w", err)
+	}
+
+	s.ring.m.Lock()
+	defer s.ring.m.Unlock()
+
+	for i := range s.shards {
+		atomic.StoreInt64(&s.shards[i].n, 0)
+	}
+	s.publish()
+
+	return nil
+}
@@ -0,0 +1,128 @@
+package counter
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/clock"
+)
+
+func TestSharded(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	s := MustSharded(time.Second, 10, WithClock(clk))
+
+	const (
+		nRoutine = 16
+		nReq     = 1000
+	)
+
+	wg := sync.WaitGroup{}
+	wg.Add(nRoutine)
+	for i := 0; i < nRoutine; i++ {
+		go func() {
+			defer wg.Done()
+			for j := 0; j < nReq; j++ {
+				s.Increase()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := s.Value(); got != 0 {
+		t.Errorf("before the tick Value() = %d, want 0", got)
+	}
+
+	clk.Advance(100 * time.Millisecond)
+	s.tick()
+
+	if got, want := s.Value(), int64(nRoutine*nReq); got != want {
+		t.Errorf("after the tick Value() = %d, want %d", got, want)
+	}
+
+	for i := 0; i < 9; i++ {
+		clk.Advance(100 * time.Millisecond)
+		s.tick()
+	}
+
+	if got := s.Value(); got != 0 {
+		t.Errorf("after the window Value() = %d, want 0", got)
+	}
+}
+
+func TestSharded_Tickless(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	s := MustSharded(time.Second, 10, WithClock(clk), WithTickless())
+
+	for i := 0; i < 5; i++ {
+		s.Increase()
+	}
+
+	clk.Advance(100 * time.Millisecond)
+	if got := s.Value(); got != 5 {
+		t.Errorf("after one tick Value() = %d, want 5", got)
+	}
+
+	clk.Advance(time.Second)
+	if got := s.Value(); got != 0 {
+		t.Errorf("after the window Value() = %d, want 0", got)
+	}
+}
+
+func TestSharded_JSON(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	s := MustSharded(time.Second, 10, WithClock(clk))
+
+	for i := 0; i < 3; i++ {
+		s.Increase()
+	}
+	clk.Advance(100 * time.Millisecond)
+	s.tick()
+
+	// pending increments must be saved as well
+	for i := 0; i < 2; i++ {
+		s.Increase()
+	}
+
+	bytes, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewFromJSON(bytes, WithClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Value(); got != 5 {
+		t.Errorf("restored Counter Value() = %d, want 5", got)
+	}
+
+	restored, err := NewShardedFromJSON(bytes, WithClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(100 * time.Millisecond)
+	restored.tick()
+	if got := restored.Value(); got != 5 {
+		t.Errorf("restored Sharded Value() = %d, want 5", got)
+	}
+}
+
+func BenchmarkCounter_Increase(b *testing.B) {
+	c := Must(time.Minute, 1000)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.Increase()
+		}
+	})
+}
+
+func BenchmarkSharded_Increase(b *testing.B) {
+	s := MustSharded(time.Minute, 1000)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			s.Increase()
+		}
+	})
+}
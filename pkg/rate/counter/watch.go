@@ -0,0 +1,172 @@
+package counter
+
+import (
+	"context"
+	"time"
+)
+
+// Direction is the direction of a threshold crossing
+type Direction int
+
+const (
+	// Above is the crossing of a value going up to the threshold or over
+	Above Direction = iota
+	// Below is the crossing of a value going down under the threshold
+	Below
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Above:
+		return "above"
+	case Below:
+		return "below"
+	default:
+		return "unknown"
+	}
+}
+
+// Event is sent to the watchers when the window value crosses their threshold
+type Event struct {
+	At        time.Time
+	Value     int64
+	Threshold int64
+	Direction Direction
+}
+
+// WatchOption configures a watch, see Counter.Watch
+type WatchOption func(w *watcher)
+
+// WithHysteresis set how far the value must go back from the threshold before
+// another crossing is notified. By default it is a tenth of the threshold, at least 1.
+func WithHysteresis(hysteresis int64) WatchOption {
+	return func(w *watcher) {
+		w.hysteresis = hysteresis
+	}
+}
+
+type watcher struct {
+	threshold  int64
+	direction  Direction
+	hysteresis int64
+	armed      bool
+	c          chan Event
+}
+
+// check returns true if value is a crossing to notify, and updates the
+// armed state: a notified crossing disarms the watcher, which is armed
+// again once the value has gone back by the hysteresis from the crossing
+// value, threshold going above and threshold-1 going below, so that it
+// is armed again whatever the threshold
+func (w *watcher) check(value int64) bool {
+	switch w.direction {
+	case Above:
+		if w.armed && value >= w.threshold {
+			w.armed = false
+			return true
+		}
+		if value <= w.threshold-w.hysteresis {
+			w.armed = true
+		}
+
+	case Below:
+		if w.armed && value < w.threshold {
+			w.armed = false
+			return true
+		}
+		if value >= w.threshold-1+w.hysteresis {
+			w.armed = true
+		}
+	}
+
+	return false
+}
+
+// send never blocks: if the watcher has not received the previous
+// event yet, that event is replaced by the newest one
+func (w *watcher) send(e Event) {
+	select {
+	case w.c <- e:
+		return
+	default:
+	}
+
+	select {
+	case <-w.c:
+	default:
+	}
+
+	select {
+	case w.c <- e:
+	default:
+	}
+}
+
+// Watch returns a channel that receives an Event each time the window value
+// crosses threshold in direction. The channel is closed when ctx is done.
+//
+// A slow receiver never blocks the Counter, it gets only the latest event.
+// Values are evaluated at each tick and each change of the Counter.
+func (c *Counter) Watch(ctx context.Context, threshold int64, direction Direction, options ...WatchOption) <-chan Event {
+	w := &watcher{
+		threshold:  threshold,
+		direction:  direction,
+		hysteresis: threshold / 10,
+		c:          make(chan Event, 1),
+	}
+	if w.hysteresis < 1 {
+		w.hysteresis = 1
+	}
+
+	for _, opt := range options {
+		opt(w)
+	}
+
+	c.m.Lock()
+	if c.tickless {
+		c.advance()
+	}
+
+	// only a crossing is notified, not the initial state
+	switch direction {
+	case Above:
+		w.armed = c.counter < threshold
+	case Below:
+		w.armed = c.counter >= threshold
+	}
+
+	c.watchers = append(c.watchers, w)
+	c.m.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		c.m.Lock()
+		defer c.m.Unlock()
+
+		for i := range c.watchers {
+			if c.watchers[i] == w {
+				c.watchers = append(c.watchers[:i], c.watchers[i+1:]...)
+				break
+			}
+		}
+		close(w.c)
+	}()
+
+	return w.c
+}
+
+// notify sends an Event to the watchers whose threshold has been crossed.
+// Must be called with the lock held.
+func (c *Counter) notify() {
+	for _, w := range c.watchers {
+		if w.check(c.counter) {
+			w.send(Event{
+				At:        c.clock.Now(),
+				Value:     c.counter,
+				Threshold: w.threshold,
+				Direction: w.direction,
+			})
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package counter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/clock"
+)
+
+func TestCounter_Watch(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	c := Must(time.Second, 10, WithClock(fake), WithTickless())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	above := c.Watch(ctx, 10, Above, WithHysteresis(4))
+	below := c.Watch(ctx, 10, Below, WithHysteresis(4))
+
+	expectNone := func(name string, ch <-chan Event) {
+		t.Helper()
+		select {
+		case e := <-ch:
+			t.Fatalf("%s: unexpected event %+v", name, e)
+		default:
+		}
+	}
+	expect := func(name string, ch <-chan Event, value int64, direction Direction) {
+		t.Helper()
+		select {
+		case e := <-ch:
+			if e.Value != value || e.Direction != direction || e.Threshold != 10 {
+				t.Fatalf("%s: got event %+v, want value %d direction %v", name, e, value, direction)
+			}
+		default:
+			t.Fatalf("%s: no event", name)
+		}
+	}
+
+	c.IncreaseBy(9)
+	expectNone("above", above)
+	expectNone("below", below)
+
+	c.Increase()
+	expect("above", above, 10, Above)
+	expectNone("below", below)
+
+	// flapping around the threshold, inside the hysteresis
+	c.DecreaseBy(1)
+	c.Increase()
+	c.DecreaseBy(1)
+	c.Increase()
+	expectNone("above", above)
+	expectNone("below", below)
+
+	// over threshold + hysteresis arms the below watcher
+	c.IncreaseBy(4)
+	c.DecreaseBy(5)
+	expect("below", below, 9, Below)
+	c.Increase()
+	c.DecreaseBy(1)
+	expectNone("below", below)
+
+	// under threshold - hysteresis arms the above watcher again
+	c.DecreaseBy(4)
+	c.IncreaseBy(5)
+	expect("above", above, 10, Above)
+
+	// a crossing due to the window sliding
+	c.IncreaseBy(4)
+	fake.Advance(time.Second)
+	c.Value()
+	expect("below", below, 0, Below)
+	expectNone("above", above)
+}
+
+func TestCounter_WatchSlowSubscriber(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	c := Must(time.Second, 10, WithClock(fake), WithTickless())
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	ch := c.Watch(ctx, 2, Above, WithHysteresis(1))
+
+	// nobody is receiving, the counter must not block
+	for i := 0; i < 5; i++ {
+		c.IncreaseBy(2)
+		c.DecreaseBy(2)
+	}
+	c.IncreaseBy(3)
+
+	e := <-ch
+	if e.Value != 3 {
+		t.Errorf("got value %d, want the latest event with value 3", e.Value)
+	}
+
+	cancel()
+	if _, ok := <-ch; ok {
+		t.Errorf("channel not closed after cancel")
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+	if len(c.watchers) != 0 {
+		t.Errorf("got %d watchers after cancel, want 0", len(c.watchers))
+	}
+}
+
+func TestCounter_WatchSmallThreshold(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	c := Must(time.Second, 10, WithClock(fake), WithTickless())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the default hysteresis is 1, as large as the threshold
+	above := c.Watch(ctx, 1, Above)
+	below := c.Watch(ctx, 1, Below)
+
+	value := func(ch <-chan Event) int64 {
+		t.Helper()
+		select {
+		case e := <-ch:
+			return e.Value
+		default:
+			t.Fatal("no event")
+			return 0
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		c.Increase()
+		if got := value(above); got != 1 {
+			t.Fatalf("crossing %d: above event value %d, want 1", i, got)
+		}
+
+		c.DecreaseBy(1)
+		if got := value(below); got != 0 {
+			t.Fatalf("crossing %d: below event value %d, want 0", i, got)
+		}
+	}
+}
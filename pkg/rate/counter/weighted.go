@@ -0,0 +1,259 @@
+package counter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/journal"
+)
+
+// Weighted approximates the number of increases in the past time period
+// using only two fixed windows: the current one and the previous one
+//
+// the previous window is weighted by the fraction of it still overlapping
+// the sliding window, which assumes the increases were evenly spread in
+// it. The memory is O(1) whatever the window and the traffic.
+type Weighted struct {
+	m              sync.Mutex
+	windowDuration time.Duration
+	prev           int64     // increases in the previous fixed window
+	curr           int64     // increases in the current fixed window
+	start          time.Time // start of the current fixed window
+	journalSeq     uint64    // seq of the last journal record in the state, see WithJournal
+
+	config
+}
+
+// NewWeighted is the constructor of Weighted
+func NewWeighted(windowDuration time.Duration, options ...Option) (*Weighted, error) {
+	if windowDuration <= 0 {
+		return nil, fmt.Errorf("non positive windowDuration: %v", windowDuration)
+	}
+
+	w := &Weighted{
+		windowDuration: windowDuration,
+		config:         defaultConfig(),
+	}
+
+	for _, opt := range options {
+		opt(&w.config)
+	}
+
+	w.start = w.clock.Now()
+
+	w.createJournal()
+
+	return w, nil
+}
+
+// NewWeightedFromJSON create a Weighted starting from a JSON input
+func NewWeightedFromJSON(bytes []byte, options ...Option) (*Weighted, error) {
+	w := &Weighted{
+		config: defaultConfig(),
+	}
+
+	if err := json.Unmarshal(bytes, w); err != nil {
+		return nil, fmt.Errorf("unmashalling JSON: %w", err)
+	}
+
+	for _, opt := range options {
+		opt(&w.config)
+	}
+
+	if err := w.openJournal(w); err != nil {
+		return nil, err
+	}
+	w.reconcile()
+	w.catchUp()
+
+	return w, nil
+}
+
+// NewWeightedFromBinary create a Weighted starting from a binary input
+func NewWeightedFromBinary(bytes []byte, options ...Option) (*Weighted, error) {
+	w := &Weighted{
+		config: defaultConfig(),
+	}
+
+	if err := w.UnmarshalBinary(bytes); err != nil {
+		return nil, fmt.Errorf("unmashalling binary: %w", err)
+	}
+
+	for _, opt := range options {
+		opt(&w.config)
+	}
+
+	if err := w.openJournal(w); err != nil {
+		return nil, err
+	}
+	w.reconcile()
+	w.catchUp()
+
+	return w, nil
+}
+
+// Run runs the the Weighted routine, the Weighted needs a routine only to save its state
+//
+// to stop this routine cancel the context or call Close
+func (w *Weighted) Run(ctx context.Context) error {
+	return run(ctx, &w.config, 0, nil, w.saveState)
+}
+
+// roll moves to the fixed window containing now. Must be called with the lock held.
+func (w *Weighted) roll(now time.Time) {
+	elapsed := now.Sub(w.start)
+	if elapsed < w.windowDuration {
+		return
+	}
+
+	windows := elapsed / w.windowDuration
+	if windows == 1 {
+		w.prev = w.curr
+	} else {
+		w.prev = 0
+	}
+	w.curr = 0
+	w.start = w.start.Add(windows * w.windowDuration)
+}
+
+// estimate must be called with the lock held
+func (w *Weighted) estimate() float64 {
+	now := w.clock.Now()
+	w.roll(now)
+
+	overlap := 1 - float64(now.Sub(w.start))/float64(w.windowDuration)
+	// the clock may be before the start, e.g. after a clock adjustment
+	overlap = math.Max(0, math.Min(1, overlap))
+
+	return float64(w.curr) + float64(w.prev)*overlap
+}
+
+func (w *Weighted) saveState() error {
+	// mutex lock is in MarshalJSON and MarshalBinary
+	return w.save(w)
+}
+
+// Value returns the estimated number of increase received in the passed window
+func (w *Weighted) Value() int64 {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	return int64(math.Round(w.estimate()))
+}
+
+// Rate returns the estimated number of increase per seconds
+func (w *Weighted) Rate() float64 {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	windowSeconds := float64(w.windowDuration) / float64(time.Second)
+	return w.estimate() / windowSeconds
+}
+
+// Window returns the duration of the window
+func (w *Weighted) Window() time.Duration {
+	return w.windowDuration
+}
+
+// ExpiresIn returns the estimated time until n of the increases in the window expire,
+// see Interface. The previous fixed window fades out first, then the current one
+// fades out during the next fixed window.
+func (w *Weighted) ExpiresIn(n int64) (time.Duration, bool) {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	estimate := w.estimate()
+	if n <= 0 {
+		return 0, true
+	}
+	if float64(n) > estimate {
+		return 0, false
+	}
+
+	now := w.clock.Now()
+	target := estimate - float64(n)
+	window := float64(w.windowDuration)
+
+	var at time.Time
+	if curr := float64(w.curr); target >= curr {
+		// prev weighs target-curr at a fraction 1-(target-curr)/prev of the current fixed window
+		at = w.start.Add(time.Duration(window * (1 - (target-curr)/float64(w.prev))))
+	} else {
+		at = w.start.Add(w.windowDuration + time.Duration(window*(1-target/curr)))
+	}
+
+	return maxDuration(0, at.Sub(now)), true
+}
+
+// Increase increase the counter by one and returns the counter value
+func (w *Weighted) Increase() int64 {
+	return w.IncreaseBy(1)
+}
+
+// IncreaseBy increase the counter by n and returns the counter value
+func (w *Weighted) IncreaseBy(n int64) int64 {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	now := w.clock.Now()
+	w.roll(now)
+	w.curr += n
+	w.journalSeq = w.record(w.journalSeq, now, n)
+
+	return int64(math.Round(w.estimate()))
+}
+
+// DecreaseBy decrease the counter by n and returns the counter value
+//
+// it is meant to refund previous increases: they are taken back from
+// the current fixed window first
+func (w *Weighted) DecreaseBy(n int64) int64 {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	now := w.clock.Now()
+	w.roll(now)
+
+	before := w.curr + w.prev
+	w.decreaseBy(n)
+	w.journalSeq = w.record(w.journalSeq, now, w.curr+w.prev-before)
+
+	return int64(math.Round(w.estimate()))
+}
+
+// decreaseBy must be called with the lock held
+func (w *Weighted) decreaseBy(n int64) {
+	take := minInt64(n, w.curr)
+	w.curr -= take
+	w.prev -= minInt64(n-take, w.prev)
+}
+
+// Replay applies a record of the journal, see Journaled
+func (w *Weighted) Replay(r journal.Record) {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if r.Seq <= w.journalSeq {
+		return
+	}
+	w.journalSeq = r.Seq
+
+	w.roll(r.At)
+	if r.Delta < 0 {
+		w.decreaseBy(-r.Delta)
+		return
+	}
+	w.curr += r.Delta
+}
+
+// JournalSeq returns the seq of the last journal record in the state, see Journaled
+func (w *Weighted) JournalSeq() uint64 {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	return w.journalSeq
+}
@@ -0,0 +1,65 @@
+package counter
+
+import (
+	"fmt"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/wire"
+)
+
+func (w *Weighted) MarshalBinary() ([]byte, error) {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	w.roll(w.clock.Now())
+
+	e := wire.NewEncoder()
+	e.String(string(KindWeighted))
+	e.Duration(w.windowDuration)
+	e.Varint(w.prev)
+	e.Varint(w.curr)
+	e.Time(w.start)
+	e.Uvarint(w.journalSeq)
+
+	return e.Bytes(), nil
+}
+
+func (w *Weighted) UnmarshalBinary(bytes []byte) error {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	d, err := wire.NewDecoder(bytes)
+	if err != nil {
+		return fmt.Errorf("decoding header: %w", err)
+	}
+
+	if kind := Kind(d.String()); kind != KindWeighted {
+		return fmt.Errorf("decoding %q counter as a %q one", kind, KindWeighted)
+	}
+
+	windowDuration := d.Duration()
+	prev := d.Varint()
+	curr := d.Varint()
+	start := d.Time()
+
+	// states of version 1 have no journal
+	var journalSeq uint64
+	if d.Version() >= 2 {
+		journalSeq = d.Uvarint()
+	}
+
+	if err := d.Err(); err != nil {
+		return fmt.Errorf("decoding: %w", err)
+	}
+
+	if windowDuration <= 0 {
+		return fmt.Errorf("%w: non positive windowDuration %v", ErrInvalidState, windowDuration)
+	}
+
+	w.windowDuration = windowDuration
+	w.prev = prev
+	w.curr = curr
+	w.start = start
+	w.journalSeq = journalSeq
+
+	return nil
+}
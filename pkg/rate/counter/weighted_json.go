@@ -0,0 +1,64 @@
+package counter
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type WeightedJSON struct {
+	Version    int           `json:"version"`
+	Kind       Kind          `json:"kind"`
+	Duration   time.Duration `json:"windowDuration"`
+	Prev       int64         `json:"prev"`
+	Curr       int64         `json:"curr"`
+	Start      time.Time     `json:"start"`
+	JournalSeq uint64        `json:"journal_seq,omitempty"`
+}
+
+func (w *Weighted) MarshalJSON() ([]byte, error) {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	w.roll(w.clock.Now())
+
+	return json.Marshal(
+		WeightedJSON{
+			Version:    counterSchema.Version(),
+			Kind:       KindWeighted,
+			Duration:   w.windowDuration,
+			Prev:       w.prev,
+			Curr:       w.curr,
+			Start:      w.start,
+			JournalSeq: w.journalSeq,
+		},
+	)
+}
+
+func (w *Weighted) UnmarshalJSON(bytes []byte) error {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	bytes, err := counterSchema.Migrate(bytes)
+	if err != nil {
+		return err
+	}
+
+	wJSON := WeightedJSON{}
+
+	if err := json.Unmarshal(bytes, &wJSON); err != nil {
+		return fmt.Errorf("unmarshalling json: %w", err)
+	}
+
+	if wJSON.Duration <= 0 {
+		return fmt.Errorf("%w: non positive windowDuration %v", ErrInvalidState, wJSON.Duration)
+	}
+
+	w.windowDuration = wJSON.Duration
+	w.prev = wJSON.Prev
+	w.curr = wJSON.Curr
+	w.start = wJSON.Start
+	w.journalSeq = wJSON.JournalSeq
+
+	return nil
+}
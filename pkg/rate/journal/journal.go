@@ -0,0 +1,472 @@
+// Package journal implements the append only journal of the increases
+// made between two saves of a state
+//
+// each record is the delta of a counter, identified by a key, in the tick
+// starting at a given time. Records are numbered by a sequence: a state
+// keeps the seq of the last record it includes, so that replaying the
+// journal on top of it skips the records already in it. Once a state is
+// saved the records it includes are dropped, see Compact.
+package journal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	journalSuffix = ".journal"
+	tempSuffix    = ".tmp"
+	version       = 1
+)
+
+// magic starts every journal, it is followed by the version and by the seq of the first record
+var magic = []byte{0x89, 'J', 'R', 'N'}
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Sync is the policy used to sync the journal on disk
+type Sync int
+
+const (
+	// SyncAlways writes and syncs each record as soon as it is appended
+	SyncAlways Sync = iota
+	// SyncBatch keeps the records in memory until Flush, which writes and syncs
+	// them at once. Deltas of the same key and tick are merged in a single record.
+	SyncBatch
+	// SyncNone writes each record as soon as it is appended, and leaves the
+	// sync to the operating system: records survive a crash of the process,
+	// but not of the machine
+	SyncNone
+)
+
+// Record is the delta of the counter key in the tick starting at At
+type Record struct {
+	Seq   uint64
+	Key   string
+	At    time.Time
+	Delta int64
+}
+
+// Path returns the path of the journal of the state saved in filePath
+func Path(filePath string) string {
+	return filePath + journalSuffix
+}
+
+// Journal appends records to a journal file
+//
+// the file is opened at the first access, so that a journal that is never
+// written leaves the file untouched. A failed open or write is retried at the
+// next access: the file is opened again, which drops a record torn by the failure.
+type Journal struct {
+	m        sync.Mutex
+	filePath string
+	policy   Sync
+	truncate bool   // drop the records in the file at the opening
+	after    uint64 // see Skip
+
+	f       *os.File
+	next    uint64   // seq of the next record
+	pending []Record // appended but not written yet, SyncBatch only
+}
+
+// Open returns the journal in filePath, new records are appended to the ones already in it
+func Open(filePath string, policy Sync) *Journal {
+	return &Journal{filePath: filePath, policy: policy, next: 1}
+}
+
+// Create returns the journal in filePath, dropping the records already in it
+func Create(filePath string, policy Sync) *Journal {
+	return &Journal{filePath: filePath, policy: policy, truncate: true, next: 1}
+}
+
+// Skip makes the seq of the next records greater than seq, it is meant for
+// states including records of a journal that is no longer on disk
+func (j *Journal) Skip(seq uint64) {
+	j.m.Lock()
+	defer j.m.Unlock()
+
+	if seq > j.after {
+		j.after = seq
+	}
+	if j.next <= j.after {
+		j.next = j.after + 1
+	}
+}
+
+// open opens the file, unless it is open. Must be called with the lock held.
+func (j *Journal) open() error {
+	if j.f != nil {
+		return nil
+	}
+
+	first := uint64(1)
+	var records []Record
+
+	if !j.truncate {
+		var err error
+		records, first, err = read(j.filePath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	if first <= j.after {
+		first = j.after + 1
+	}
+
+	// rewriting the valid records drops a record torn by a crash
+	if err := j.rewrite(records, first); err != nil {
+		return err
+	}
+
+	j.truncate = false
+	return nil
+}
+
+// rewrite atomically replaces the journal file with records, first is the
+// seq of the first record. Must be called with the lock held.
+func (j *Journal) rewrite(records []Record, first uint64) (err error) {
+	if j.f != nil {
+		_ = j.f.Close()
+		j.f = nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(j.filePath), os.ModePerm); err != nil {
+		return fmt.Errorf("creating dir of %s: %w", j.filePath, err)
+	}
+
+	tempPath := j.filePath + tempSuffix
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tempPath)
+		}
+	}()
+
+	buf := append([]byte{}, magic...)
+	buf = append(buf, version)
+	buf = appendUvarint(buf, first)
+	for _, r := range records {
+		buf = appendRecord(buf, r)
+	}
+
+	if err = ioutil.WriteFile(tempPath, buf, 0644); err != nil {
+		return fmt.Errorf("writing file %s: %w", tempPath, err)
+	}
+
+	f, err := os.OpenFile(tempPath, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("opening file %s: %w", tempPath, err)
+	}
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("syncing file %s: %w", tempPath, err)
+	}
+
+	// the renamed file keeps being the one open for appending
+	if err = os.Rename(tempPath, j.filePath); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("renaming %s: %w", tempPath, err)
+	}
+
+	j.f = f
+	j.next = first + uint64(len(records))
+
+	syncDir(filepath.Dir(j.filePath))
+
+	return nil
+}
+
+// Append appends a record of delta for key in the tick starting at at, and returns its seq.
+// If the record can not be written, nor kept in memory by SyncBatch, it is dropped and
+// the error is returned: the increase is then only in the state saved next.
+func (j *Journal) Append(key string, at time.Time, delta int64) (uint64, error) {
+	j.m.Lock()
+	defer j.m.Unlock()
+
+	if err := j.open(); err != nil {
+		return 0, fmt.Errorf("dropping record of %s: %w", key, err)
+	}
+
+	if j.policy == SyncBatch {
+		if last := len(j.pending) - 1; last >= 0 && j.pending[last].Key == key && j.pending[last].At.Equal(at) {
+			j.pending[last].Delta += delta
+			return j.pending[last].Seq, nil
+		}
+	}
+
+	r := Record{Seq: j.next, Key: key, At: at, Delta: delta}
+
+	if j.policy == SyncBatch {
+		j.next++
+		j.pending = append(j.pending, r)
+		return r.Seq, nil
+	}
+
+	if err := j.write(appendRecord(nil, r), j.policy == SyncAlways); err != nil {
+		return 0, fmt.Errorf("dropping record of %s: %w", key, err)
+	}
+	j.next++
+
+	return r.Seq, nil
+}
+
+// write writes b in the file, which is closed if it fails, so that it is opened
+// again by the next access. Must be called with the lock held.
+func (j *Journal) write(b []byte, sync bool) (err error) {
+	defer func() {
+		if err != nil {
+			_ = j.f.Close()
+			j.f = nil
+		}
+	}()
+
+	if _, err := j.f.Write(b); err != nil {
+		return fmt.Errorf("writing in file %s: %w", j.filePath, err)
+	}
+
+	if !sync {
+		return nil
+	}
+
+	if err := j.f.Sync(); err != nil {
+		return fmt.Errorf("syncing file %s: %w", j.filePath, err)
+	}
+
+	return nil
+}
+
+// Seq returns the seq of the last record appended
+func (j *Journal) Seq() uint64 {
+	j.m.Lock()
+	defer j.m.Unlock()
+
+	// a failed open is retried by the next access, which reports it
+	_ = j.open()
+
+	return j.next - 1
+}
+
+// Flush writes and syncs the records kept in memory by SyncBatch, they are
+// kept until they are written, so a failed flush is retried by the next one
+func (j *Journal) Flush() error {
+	j.m.Lock()
+	defer j.m.Unlock()
+
+	return j.flush()
+}
+
+// flush must be called with the lock held
+func (j *Journal) flush() error {
+	reopened := j.f == nil
+	if err := j.open(); err != nil {
+		return err
+	}
+
+	if reopened && len(j.pending) > 0 {
+		// the records written before a failed write are in the file opened again
+		i := 0
+		for ; i < len(j.pending) && j.pending[i].Seq < j.next; i++ {
+		}
+		j.pending = j.pending[i:]
+
+		if len(j.pending) > 0 {
+			j.next = j.pending[len(j.pending)-1].Seq + 1
+		}
+	}
+
+	if len(j.pending) == 0 {
+		return nil
+	}
+
+	var buf []byte
+	for _, r := range j.pending {
+		buf = appendRecord(buf, r)
+	}
+
+	if err := j.write(buf, j.policy != SyncNone); err != nil {
+		return err
+	}
+	j.pending = j.pending[:0]
+
+	return nil
+}
+
+// Compact drops the records up to seq, which are included in a saved state
+func (j *Journal) Compact(seq uint64) error {
+	j.m.Lock()
+	defer j.m.Unlock()
+
+	if err := j.flush(); err != nil {
+		return err
+	}
+
+	records, first, err := read(j.filePath)
+	if err != nil {
+		return err
+	}
+
+	if first > seq {
+		return nil
+	}
+
+	i := 0
+	for ; i < len(records) && records[i].Seq <= seq; i++ {
+	}
+
+	return j.rewrite(records[i:], seq+1)
+}
+
+// Close flushes and closes the journal file
+func (j *Journal) Close() error {
+	j.m.Lock()
+	defer j.m.Unlock()
+
+	if j.f == nil && len(j.pending) == 0 {
+		return nil
+	}
+
+	err := j.flush()
+	if j.f == nil {
+		return err
+	}
+
+	if cerr := j.f.Close(); cerr != nil && err == nil {
+		err = fmt.Errorf("closing file %s: %w", j.filePath, cerr)
+	}
+	j.f = nil
+
+	return err
+}
+
+// Read returns the records of the journal in filePath, it returns no
+// records if the file does not exist. Records after a torn or corrupt
+// one, as left by a crash while appending, are ignored.
+func Read(filePath string) ([]Record, error) {
+	records, _, err := read(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	return records, err
+}
+
+// read returns the valid records in filePath and the seq of the first one
+func read(filePath string) ([]Record, uint64, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, 0, fmt.Errorf("reading file %s: %w", filePath, err)
+	}
+
+	if !bytes.HasPrefix(data, magic) || len(data) == len(magic) {
+		return nil, 0, fmt.Errorf("file %s: missing journal header", filePath)
+	}
+	data = data[len(magic):]
+
+	if v := data[0]; v > version {
+		return nil, 0, fmt.Errorf("file %s: unsupported journal version %d, the latest supported is %d", filePath, v, version)
+	}
+	data = data[1:]
+
+	first, n := binary.Uvarint(data)
+	if n <= 0 {
+		return nil, 0, fmt.Errorf("file %s: missing journal header", filePath)
+	}
+	data = data[n:]
+
+	var records []Record
+	for seq := first; ; seq++ {
+		r, n := decodeRecord(data)
+		if n <= 0 {
+			break
+		}
+		r.Seq = seq
+		records = append(records, r)
+		data = data[n:]
+	}
+
+	return records, first, nil
+}
+
+// appendRecord appends to buf the record r, made of the length of the
+// payload, the payload and its checksum. The seq is implicit.
+func appendRecord(buf []byte, r Record) []byte {
+	var payload []byte
+	payload = appendUvarint(payload, uint64(len(r.Key)))
+	payload = append(payload, r.Key...)
+	payload = appendVarint(payload, r.At.UnixNano())
+	payload = appendVarint(payload, r.Delta)
+
+	buf = appendUvarint(buf, uint64(len(payload)))
+	buf = append(buf, payload...)
+
+	checksum := make([]byte, 4)
+	binary.BigEndian.PutUint32(checksum, crc32.Checksum(payload, crcTable))
+
+	return append(buf, checksum...)
+}
+
+// decodeRecord returns the record at the start of b and its length,
+// or a non positive length if there is no valid record
+func decodeRecord(b []byte) (Record, int) {
+	size, n := binary.Uvarint(b)
+	if n <= 0 || uint64(len(b)-n) < size+4 {
+		return Record{}, 0
+	}
+
+	payload := b[n : n+int(size)]
+	checksum := binary.BigEndian.Uint32(b[n+int(size):])
+	if crc32.Checksum(payload, crcTable) != checksum {
+		return Record{}, 0
+	}
+
+	keyLen, k := binary.Uvarint(payload)
+	if k <= 0 || uint64(len(payload)-k) < keyLen {
+		return Record{}, 0
+	}
+	key := string(payload[k : k+int(keyLen)])
+	payload = payload[k+int(keyLen):]
+
+	at, k := binary.Varint(payload)
+	if k <= 0 {
+		return Record{}, 0
+	}
+	delta, k2 := binary.Varint(payload[k:])
+	if k2 <= 0 {
+		return Record{}, 0
+	}
+
+	r := Record{Key: key, At: time.Unix(0, at), Delta: delta}
+
+	return r, n + int(size) + 4
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
+
+func appendVarint(buf []byte, v int64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
+
+// syncDir makes the renames in dir durable, see statefile
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+
+	_ = d.Sync()
+}
@@ -0,0 +1,192 @@
+package journal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestJournal(t *testing.T) {
+	at := time.Unix(1600000000, 0)
+
+	tests := map[Sync][]Record{
+		SyncAlways: {
+			{Seq: 1, Key: "a", At: at, Delta: 1},
+			{Seq: 2, Key: "a", At: at, Delta: 2},
+			{Seq: 3, Key: "b", At: at, Delta: -1},
+			{Seq: 4, Key: "a", At: at.Add(time.Second), Delta: 3},
+		},
+		SyncNone: {
+			{Seq: 1, Key: "a", At: at, Delta: 1},
+			{Seq: 2, Key: "a", At: at, Delta: 2},
+			{Seq: 3, Key: "b", At: at, Delta: -1},
+			{Seq: 4, Key: "a", At: at.Add(time.Second), Delta: 3},
+		},
+		// deltas of the same key and tick are merged
+		SyncBatch: {
+			{Seq: 1, Key: "a", At: at, Delta: 3},
+			{Seq: 2, Key: "b", At: at, Delta: -1},
+			{Seq: 3, Key: "a", At: at.Add(time.Second), Delta: 3},
+		},
+	}
+	for policy, want := range tests {
+		filePath := filepath.Join(t.TempDir(), "journal")
+		j := Create(filePath, policy)
+
+		j.Append("a", at, 1)
+		j.Append("a", at, 2)
+		j.Append("b", at, -1)
+		if seq, _ := j.Append("a", at.Add(time.Second), 3); seq != want[len(want)-1].Seq {
+			t.Errorf("policy %d: Append() = %d, want %d", policy, seq, want[len(want)-1].Seq)
+		}
+
+		if err := j.Flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := Read(filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("policy %d: Read() = %v, want %v", policy, got, want)
+		}
+	}
+}
+
+func TestJournal_Compact(t *testing.T) {
+	at := time.Unix(1600000000, 0)
+	filePath := filepath.Join(t.TempDir(), "journal")
+
+	j := Create(filePath, SyncAlways)
+	for i := int64(1); i <= 3; i++ {
+		j.Append("a", at, i)
+	}
+
+	if err := j.Compact(2); err != nil {
+		t.Fatal(err)
+	}
+	j.Append("a", at, 4)
+
+	want := []Record{
+		{Seq: 3, Key: "a", At: at, Delta: 3},
+		{Seq: 4, Key: "a", At: at, Delta: 4},
+	}
+	got, err := Read(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Read() = %v, want %v", got, want)
+	}
+
+	// the seq goes on after all the records are dropped and the journal is reopened
+	if err := j.Compact(4); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	j = Open(filePath, SyncAlways)
+	if seq, _ := j.Append("a", at, 5); seq != 5 {
+		t.Errorf("Append() after reopening = %d, want 5", seq)
+	}
+
+	// a new journal drops the records at the first write
+	j = Create(filePath, SyncAlways)
+	if seq, _ := j.Append("a", at, 1); seq != 1 {
+		t.Errorf("Append() to a new journal = %d, want 1", seq)
+	}
+}
+
+func TestRead(t *testing.T) {
+	at := time.Unix(1600000000, 0)
+	filePath := filepath.Join(t.TempDir(), "journal")
+
+	if records, err := Read(filePath); err != nil || records != nil {
+		t.Errorf("Read() of a missing journal = %v, %v, want no records", records, err)
+	}
+
+	j := Create(filePath, SyncAlways)
+	j.Append("a", at, 1)
+	j.Append("a", at, 2)
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a crash while appending the second record
+	if err := ioutil.WriteFile(filePath, content[:len(content)-2], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Record{{Seq: 1, Key: "a", At: at, Delta: 1}}
+	got, err := Read(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Read() = %v, want %v", got, want)
+	}
+
+	// the torn record is dropped when the journal is reopened
+	j = Open(filePath, SyncAlways)
+	if seq, _ := j.Append("a", at, 3); seq != 2 {
+		t.Errorf("Append() after a torn record = %d, want 2", seq)
+	}
+
+	if err := ioutil.WriteFile(filePath, []byte("not a journal"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Read(filePath); err == nil {
+		t.Errorf("Read() of an invalid journal no error")
+	}
+
+	if _, err := os.Stat(filePath + tempSuffix); !os.IsNotExist(err) {
+		t.Errorf("temporary file left: %v", err)
+	}
+}
+
+func TestJournal_Recover(t *testing.T) {
+	at := time.Unix(1600000000, 0)
+
+	for _, policy := range []Sync{SyncAlways, SyncBatch} {
+		dir := t.TempDir()
+
+		// the dir of the journal can not be created while a file is in its place
+		blocker := filepath.Join(dir, "state")
+		if err := ioutil.WriteFile(blocker, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		j := Create(Path(filepath.Join(blocker, "counter")), policy)
+
+		if _, err := j.Append("a", at, 1); err == nil {
+			t.Errorf("policy %d: Append() without a file, no error", policy)
+		}
+		if err := j.Compact(0); err == nil {
+			t.Errorf("policy %d: Compact() without a file, no error", policy)
+		}
+
+		// the file is opened by the next access, the dropped record took no seq
+		if err := os.Remove(blocker); err != nil {
+			t.Fatal(err)
+		}
+		if seq, err := j.Append("a", at, 2); err != nil || seq != 1 {
+			t.Errorf("policy %d: Append() after recovering = %d, %v, want 1 and no error", policy, seq, err)
+		}
+		if err := j.Compact(1); err != nil {
+			t.Errorf("policy %d: Compact() after recovering error = %v", policy, err)
+		}
+		if err := j.Close(); err != nil {
+			t.Errorf("policy %d: Close() error = %v", policy, err)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+// Package lifecycle tracks the background routines of the rate structures,
+// so that their owners can stop them, wait for them and know their failures
+package lifecycle
+
+import (
+	"sync"
+	"time"
+)
+
+// Lifecycle is the state of the routines of a structure, the zero value is ready to use
+//
+// a routine calls Begin when it starts and End when it returns, and stops when
+// Stopped is closed. Failures that the routine survives, e.g. a save that is
+// retried, are reported with Fail and cleared with Recover.
+type Lifecycle struct {
+	m       sync.Mutex
+	stop    chan struct{} // closed by Close
+	closed  bool
+	running int
+	idle    chan struct{} // closed when no routine is running
+	err     error         // returned by the last routine that failed
+	lastErr error         // last failure not recovered, see Err
+}
+
+// Stopped returns a channel closed by Close
+func (lc *Lifecycle) Stopped() <-chan struct{} {
+	lc.m.Lock()
+	defer lc.m.Unlock()
+
+	return lc.stopChan()
+}
+
+// stopChan returns the channel closed by Close. Must be called with the lock held.
+func (lc *Lifecycle) stopChan() chan struct{} {
+	if lc.stop == nil {
+		lc.stop = make(chan struct{})
+	}
+	return lc.stop
+}
+
+// Begin records the start of a routine, it returns false if Close was called,
+// in that case the routine must not start
+func (lc *Lifecycle) Begin() bool {
+	lc.m.Lock()
+	defer lc.m.Unlock()
+
+	if lc.closed {
+		return false
+	}
+
+	if lc.running == 0 {
+		lc.idle = make(chan struct{})
+	}
+	lc.running++
+
+	return true
+}
+
+// End records that a routine started with Begin returned err
+func (lc *Lifecycle) End(err error) {
+	lc.m.Lock()
+	defer lc.m.Unlock()
+
+	if err != nil {
+		lc.err = err
+	}
+
+	lc.running--
+	if lc.running == 0 {
+		close(lc.idle)
+	}
+}
+
+// Close stops the routines and waits for them, see Wait
+func (lc *Lifecycle) Close() error {
+	lc.m.Lock()
+	if !lc.closed {
+		lc.closed = true
+		close(lc.stopChan())
+	}
+	lc.m.Unlock()
+
+	return lc.Wait()
+}
+
+// Wait waits for the routines started before it, and returns the error of the last one that failed
+func (lc *Lifecycle) Wait() error {
+	lc.m.Lock()
+	idle := lc.idle
+	lc.m.Unlock()
+
+	if idle != nil {
+		<-idle
+	}
+
+	lc.m.Lock()
+	defer lc.m.Unlock()
+
+	return lc.err
+}
+
+// Fail records a failure the routine survived, it is returned by Err until Recover
+func (lc *Lifecycle) Fail(err error) {
+	lc.m.Lock()
+	defer lc.m.Unlock()
+
+	lc.lastErr = err
+}
+
+// Recover clears the failure recorded by Fail
+func (lc *Lifecycle) Recover() {
+	lc.m.Lock()
+	defer lc.m.Unlock()
+
+	lc.lastErr = nil
+}
+
+// Err returns the last failure recorded by Fail, nil if the routine recovered from it
+func (lc *Lifecycle) Err() error {
+	lc.m.Lock()
+	defer lc.m.Unlock()
+
+	return lc.lastErr
+}
+
+// Backoff returns the delay of the retry following one delayed by prev,
+// doubling from min up to max. A zero prev is the first retry.
+func Backoff(prev, min, max time.Duration) time.Duration {
+	next := 2 * prev
+	if next < min {
+		next = min
+	}
+	if next > max {
+		next = max
+	}
+	return next
+}
@@ -0,0 +1,74 @@
+package lifecycle
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLifecycle(t *testing.T) {
+	var lc Lifecycle
+
+	if err := lc.Wait(); err != nil {
+		t.Errorf("Wait() without routines = %v", err)
+	}
+
+	errStop := errors.New("stop")
+	for i := 0; i < 2; i++ {
+		if !lc.Begin() {
+			t.Fatal("Begin() = false before Close")
+		}
+		go func(i int) {
+			<-lc.Stopped()
+			if i == 1 {
+				lc.End(errStop)
+				return
+			}
+			lc.End(nil)
+		}(i)
+	}
+
+	if err := lc.Close(); !errors.Is(err, errStop) {
+		t.Errorf("Close() = %v, want errStop", err)
+	}
+	if lc.Begin() {
+		t.Error("Begin() = true after Close")
+	}
+	// Close can be called again
+	if err := lc.Close(); !errors.Is(err, errStop) {
+		t.Errorf("second Close() = %v, want errStop", err)
+	}
+}
+
+func TestLifecycle_Err(t *testing.T) {
+	var lc Lifecycle
+
+	errSave := errors.New("save")
+	lc.Fail(errSave)
+	if err := lc.Err(); !errors.Is(err, errSave) {
+		t.Errorf("Err() = %v, want errSave", err)
+	}
+
+	lc.Recover()
+	if err := lc.Err(); err != nil {
+		t.Errorf("Err() after Recover = %v, want nil", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	min, max := 100*time.Millisecond, time.Second
+
+	var got []time.Duration
+	var backoff time.Duration
+	for i := 0; i < 6; i++ {
+		backoff = Backoff(backoff, min, max)
+		got = append(got, backoff)
+	}
+
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("retry %d: Backoff() = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package limiter
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// ErrInvalidCost is returned when a request costs no units, or a negative number of them
+var ErrInvalidCost = errors.New("cost must be positive")
+
+// Decision is the outcome of a request to a limiter, along with the state of the limit after it
+type Decision struct {
+	// Allowed is true if the request fits under the limit, in that case it has been accounted
+	Allowed bool
+	// Limit is the number of units allowed per window
+	Limit int64
+	// Remaining is the number of units still available now
+	Remaining int64
+	// ResetAt is the time the whole limit is available again, if no other request is made
+	ResetAt time.Time
+	// RetryAfter is the time until the request fits under the limit, zero if it is allowed.
+	// A request costing more than the limit never fits, it is a window. A request costing
+	// no units, or a negative number of them, is rejected and nothing is accounted.
+	RetryAfter time.Duration
+}
+
+// ceilDuration returns the time it takes to cover units at limit units per window, rounded up
+func ceilDuration(units float64, window time.Duration, limit int64) time.Duration {
+	if units <= 0 {
+		return 0
+	}
+	if limit <= 0 {
+		return window
+	}
+	return time.Duration(math.Ceil(units * float64(window) / float64(limit)))
+}
@@ -0,0 +1,118 @@
+package limiter
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/clock"
+)
+
+func TestDecideN(t *testing.T) {
+	type step struct {
+		advance    time.Duration
+		allowed    bool
+		remaining  int64
+		retryAfter time.Duration
+		resetAt    time.Duration // since the first request
+	}
+	tests := []struct {
+		alg   Algorithm
+		steps []step
+	}{
+		{
+			alg: AlgorithmWindow,
+			// the units expire a window after their tick
+			steps: []step{
+				{allowed: true, remaining: 1, resetAt: time.Second},
+				{advance: 300 * time.Millisecond, allowed: true, remaining: 0, resetAt: 1300 * time.Millisecond},
+				{allowed: false, remaining: 0, retryAfter: 700 * time.Millisecond, resetAt: 1300 * time.Millisecond},
+			},
+		},
+		{
+			alg: AlgorithmTokenBucket,
+			// a token each 500ms
+			steps: []step{
+				{allowed: true, remaining: 1, resetAt: 500 * time.Millisecond},
+				{advance: 300 * time.Millisecond, allowed: true, remaining: 0, resetAt: time.Second},
+				{allowed: false, remaining: 0, retryAfter: 200 * time.Millisecond, resetAt: time.Second},
+			},
+		},
+		{
+			alg: AlgorithmGCRA,
+			// an emission interval of 500ms
+			steps: []step{
+				{allowed: true, remaining: 1, resetAt: 500 * time.Millisecond},
+				{advance: 300 * time.Millisecond, allowed: true, remaining: 0, resetAt: time.Second},
+				{allowed: false, remaining: 0, retryAfter: 200 * time.Millisecond, resetAt: time.Second},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.alg), func(t *testing.T) {
+			start := time.Unix(0, 0)
+			clk := clock.NewFake(start)
+			l, err := NewAlgorithm(tt.alg, time.Second, 2, WithClock(clk), WithTickless())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, s := range tt.steps {
+				clk.Advance(s.advance)
+
+				d := l.DecideN(1)
+				if d.Allowed != s.allowed || d.Limit != 2 || d.Remaining != s.remaining {
+					t.Errorf("step %d: Allowed %v, Limit %d, Remaining %d, want %v, 2, %d",
+						i, d.Allowed, d.Limit, d.Remaining, s.allowed, s.remaining)
+				}
+				// the token bucket works with floats
+				if !near(d.RetryAfter, s.retryAfter) {
+					t.Errorf("step %d: RetryAfter %v, want %v", i, d.RetryAfter, s.retryAfter)
+				}
+				if resetAt := d.ResetAt.Sub(start); !near(resetAt, s.resetAt) {
+					t.Errorf("step %d: ResetAt after %v, want %v", i, resetAt, s.resetAt)
+				}
+			}
+
+			if d := l.DecideN(3); d.Allowed || d.RetryAfter != time.Second {
+				t.Errorf("DecideN(3) over the limit: Allowed %v, RetryAfter %v, want false and the window", d.Allowed, d.RetryAfter)
+			}
+		})
+	}
+}
+
+func near(a, b time.Duration) bool {
+	d := a - b
+	return d > -time.Microsecond && d < time.Microsecond
+}
+
+func TestDecideN_InvalidCost(t *testing.T) {
+	for _, alg := range []Algorithm{AlgorithmWindow, AlgorithmTokenBucket, AlgorithmGCRA} {
+		t.Run(string(alg), func(t *testing.T) {
+			l, err := NewAlgorithm(alg, time.Second, 2, WithClock(clock.NewFake(time.Unix(0, 0))), WithTickless())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, n := range []int64{0, -2} {
+				if l.AllowN(n) {
+					t.Errorf("AllowN(%d) = true, want false", n)
+				}
+				if d := l.DecideN(n); d.Allowed {
+					t.Errorf("DecideN(%d) allowed", n)
+				}
+			}
+
+			// a negative cost refunds nothing
+			if !l.AllowN(2) || l.AllowN(1) {
+				t.Error("the limit of 2 is not the same after the invalid costs")
+			}
+		})
+	}
+
+	m := MustMap(time.Second, 2)
+	defer m.Close()
+	if _, err := m.DecideN("a", 0); !errors.Is(err, ErrInvalidCost) {
+		t.Errorf("Map.DecideN(0) error = %v, want ErrInvalidCost", err)
+	}
+}
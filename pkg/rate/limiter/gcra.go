@@ -0,0 +1,201 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// GCRA is the generic cell rate algorithm limiter: each unit is spaced by an
+// emission interval of window/limit, and the only state is the theoretical
+// arrival time (TAT) of the next unit. A request is allowed if it does not
+// push the TAT further than burst emission intervals from now.
+//
+// it allows the same rate and bursts as a TokenBucket, exactly and without a routine
+type GCRA struct {
+	sync.Mutex
+	window time.Duration
+	limit  int64
+	bursts int64
+	tat    time.Time
+
+	config
+}
+
+// NewGCRA is the constructor of GCRA, it allows limit units per duration
+// and bursts of limit units, see WithBurst
+func NewGCRA(duration time.Duration, limit int64, options ...Option) (*GCRA, error) {
+	g := &GCRA{
+		window: duration,
+		limit:  limit,
+		bursts: limit,
+		config: defaultConfig(),
+	}
+
+	for _, opt := range options {
+		opt(&g.config)
+	}
+
+	if g.isBurstSet {
+		g.bursts = g.burst
+	}
+
+	if err := g.validate(); err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
+// NewGCRAFromJSON create a GCRA starting from a JSON input
+func NewGCRAFromJSON(bytes []byte, options ...Option) (*GCRA, error) {
+	g := &GCRA{
+		config: defaultConfig(),
+	}
+
+	for _, opt := range options {
+		opt(&g.config)
+	}
+
+	if err := g.unmarshalJSON(bytes); err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
+// NewGCRAFromBinary create a GCRA starting from a binary input, see MarshalBinary
+func NewGCRAFromBinary(bytes []byte, options ...Option) (*GCRA, error) {
+	g := &GCRA{
+		config: defaultConfig(),
+	}
+
+	for _, opt := range options {
+		opt(&g.config)
+	}
+
+	if err := g.unmarshalBinary(bytes); err != nil {
+		return nil, fmt.Errorf("unmarshalling binary: %w", err)
+	}
+
+	return g, nil
+}
+
+// validate checks the window and the burst of the limiter
+func (g *GCRA) validate() error {
+	if g.window <= 0 {
+		return fmt.Errorf("gcra window %v must be positive", g.window)
+	}
+	if g.bursts < 0 {
+		return fmt.Errorf("gcra burst %d must not be negative", g.bursts)
+	}
+	return nil
+}
+
+// reconcile sets the rate and the burst of a restored GCRA to the ones set by
+// WithLimit and WithBurst. Without WithBurst the burst follows the limit set by WithLimit.
+// Must be called with the lock held.
+func (g *GCRA) reconcile() {
+	if g.isLimitSet && (g.duration != g.window || g.configLimit != g.limit) {
+		g.logf("gcra: changing rate from %d per %v to %d per %v\n",
+			g.limit, g.window, g.configLimit, g.duration)
+		g.window = g.duration
+		g.limit = g.configLimit
+	}
+
+	bursts := g.bursts
+	switch {
+	case g.isBurstSet:
+		bursts = g.burst
+	case g.isLimitSet:
+		bursts = g.limit
+	}
+
+	if bursts == g.bursts {
+		return
+	}
+
+	g.logf("gcra: changing burst from %d to %d\n", g.bursts, bursts)
+	g.bursts = bursts
+}
+
+// interval returns the emission interval of n units
+func (g *GCRA) interval(n int64) time.Duration {
+	return time.Duration(n * int64(g.window) / g.limit)
+}
+
+// empty returns true if the TAT is not in the future, i.e. the limiter is as if it was new
+func (g *GCRA) empty() bool {
+	g.Lock()
+	defer g.Unlock()
+
+	return !g.tat.After(g.clock.Now())
+}
+
+// Start does nothing, the limiter has no routine
+func (g *GCRA) Start(context.Context) {}
+
+// Close does nothing, the limiter has no routine
+func (g *GCRA) Close() error {
+	return nil
+}
+
+// IsAllowed returns true if a request fits under the limit, in that case it is accounted
+func (g *GCRA) IsAllowed() bool {
+	return g.AllowN(1)
+}
+
+// AllowN returns true if a request costing n units fits under the limit,
+// in that case the TAT is moved forward by the emission interval of n units
+func (g *GCRA) AllowN(n int64) bool {
+	return g.DecideN(n).Allowed
+}
+
+// DecideN is the same as AllowN, but it returns the details of the decision:
+// the whole limit is available again at the TAT
+func (g *GCRA) DecideN(n int64) Decision {
+	if n <= 0 {
+		return Decision{Limit: g.limit}
+	}
+
+	g.Lock()
+	defer g.Unlock()
+
+	now := g.clock.Now()
+	d := Decision{Limit: g.limit, ResetAt: now}
+
+	if g.limit <= 0 {
+		d.RetryAfter = g.window
+		return d
+	}
+
+	tolerance := g.interval(g.bursts)
+
+	tat := g.tat
+	if tat.Before(now) {
+		tat = now
+	}
+	// a TAT beyond the tolerance is left by a clock stepped back,
+	// the limiter is as if it had just allowed a full burst
+	if limit := now.Add(tolerance); tat.After(limit) {
+		tat = limit
+	}
+
+	switch newTAT := tat.Add(g.interval(n)); {
+	case n > g.bursts:
+		d.RetryAfter = g.window
+	case newTAT.Sub(now) > tolerance:
+		d.RetryAfter = newTAT.Sub(now) - tolerance
+	default:
+		tat = newTAT
+		d.Allowed = true
+	}
+
+	g.tat = tat
+
+	d.Remaining = int64(tolerance-tat.Sub(now)) * g.limit / int64(g.window)
+	d.ResetAt = tat
+
+	return d
+}
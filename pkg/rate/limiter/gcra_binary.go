@@ -0,0 +1,57 @@
+package limiter
+
+import (
+	"fmt"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/clock"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/wire"
+)
+
+// MarshalBinary encodes the GCRA in the compact binary format of package wire
+func (g *GCRA) MarshalBinary() ([]byte, error) {
+	g.Lock()
+	defer g.Unlock()
+
+	e := wire.NewEncoder()
+	e.String(string(AlgorithmGCRA))
+	e.Duration(g.window)
+	e.Varint(g.limit)
+	e.Varint(g.bursts)
+	e.Time(g.tat)
+
+	return e.Bytes(), nil
+}
+
+func (g *GCRA) UnmarshalBinary(bytes []byte) error {
+	g.Lock()
+	defer g.Unlock()
+
+	if g.clock == nil {
+		g.clock = clock.New()
+	}
+
+	return g.unmarshalBinary(bytes)
+}
+
+// unmarshalBinary must be called with the lock held
+func (g *GCRA) unmarshalBinary(bytes []byte) error {
+	d, err := wire.NewDecoder(bytes)
+	if err != nil {
+		return fmt.Errorf("decoding header: %w", err)
+	}
+
+	if alg := decodeAlgorithm(d); d.Err() == nil && alg != AlgorithmGCRA {
+		return fmt.Errorf("decoding %q limiter as a %q one", alg, AlgorithmGCRA)
+	}
+
+	window := d.Duration()
+	limit := d.Varint()
+	burst := d.Varint()
+	tat := d.Time()
+
+	if err := d.Err(); err != nil {
+		return fmt.Errorf("decoding: %w", err)
+	}
+
+	return g.restore(window, limit, burst, tat)
+}
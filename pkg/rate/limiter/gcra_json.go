@@ -0,0 +1,81 @@
+package limiter
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/clock"
+)
+
+type GCRAJSON struct {
+	Version   int           `json:"version"`
+	Algorithm Algorithm     `json:"algorithm"`
+	Window    time.Duration `json:"window"`
+	Limit     int64         `json:"limit"`
+	Burst     int64         `json:"burst"`
+	TAT       time.Time     `json:"tat"`
+}
+
+func (g *GCRA) MarshalJSON() ([]byte, error) {
+	g.Lock()
+	defer g.Unlock()
+
+	gJSON := GCRAJSON{
+		Version:   gcraSchema.Version(),
+		Algorithm: AlgorithmGCRA,
+		Window:    g.window,
+		Limit:     g.limit,
+		Burst:     g.bursts,
+		TAT:       g.tat,
+	}
+
+	return json.Marshal(gJSON)
+}
+
+func (g *GCRA) UnmarshalJSON(bytes []byte) error {
+	g.Lock()
+	defer g.Unlock()
+
+	if g.clock == nil {
+		g.clock = clock.New()
+	}
+
+	return g.unmarshalJSON(bytes)
+}
+
+// unmarshalJSON must be called with the lock held
+func (g *GCRA) unmarshalJSON(bytes []byte) error {
+	bytes, err := gcraSchema.Migrate(bytes)
+	if err != nil {
+		return err
+	}
+
+	gJSON := GCRAJSON{}
+	if err := json.Unmarshal(bytes, &gJSON); err != nil {
+		return fmt.Errorf("unmarshalling JSON: %w", err)
+	}
+
+	if gJSON.Algorithm != AlgorithmGCRA {
+		return fmt.Errorf("unmarshalling %q limiter as a %q one", gJSON.Algorithm, AlgorithmGCRA)
+	}
+
+	return g.restore(gJSON.Window, gJSON.Limit, gJSON.Burst, gJSON.TAT)
+}
+
+// restore sets the state of a restored limiter. Must be called with the lock held.
+func (g *GCRA) restore(window time.Duration, limit, burst int64, tat time.Time) error {
+	g.window = window
+	g.limit = limit
+	g.bursts = burst
+	g.tat = tat
+
+	// the state is validated before it is reconciled with the options
+	if err := g.validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidState, err)
+	}
+
+	g.reconcile()
+
+	return nil
+}
@@ -0,0 +1,214 @@
+package limiter
+
+import (
+	"encoding/json"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/clock"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/store"
+)
+
+func TestGCRA_AllowN(t *testing.T) {
+	type step struct {
+		advance time.Duration
+		n       int64
+		want    bool
+	}
+	tests := []struct {
+		name    string
+		limit   int64
+		options []Option
+		steps   []step
+	}{
+		{
+			name:  "burst of limit",
+			limit: 10,
+			steps: []step{
+				{n: 10, want: true},
+				{n: 1, want: false},
+				// one unit each 100ms
+				{advance: 100 * time.Millisecond, n: 1, want: true},
+				{n: 1, want: false},
+				{advance: 250 * time.Millisecond, n: 2, want: true},
+				{n: 1, want: false},
+			},
+		},
+		{
+			name:    "burst smaller than limit",
+			limit:   10,
+			options: []Option{WithBurst(3)},
+			steps: []step{
+				{n: 3, want: true},
+				{n: 1, want: false},
+				{advance: time.Minute, n: 4, want: false},
+				{n: 3, want: true},
+			},
+		},
+		{
+			name:  "interval not a whole number of nanoseconds",
+			limit: 3,
+			steps: []step{
+				{n: 3, want: true},
+				{n: 1, want: false},
+				{advance: time.Second / 3, n: 1, want: true},
+				{n: 1, want: false},
+			},
+		},
+		{
+			name:  "no limit allows nothing",
+			limit: 0,
+			steps: []step{
+				{n: 1, want: false},
+				{advance: time.Minute, n: 1, want: false},
+			},
+		},
+		{
+			name:  "clock stepped back",
+			limit: 10,
+			steps: []step{
+				{n: 10, want: true},
+				{advance: -time.Hour, n: 1, want: false},
+				{advance: 100 * time.Millisecond, n: 1, want: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := clock.NewFake(time.Unix(0, 0))
+			g, err := NewGCRA(time.Second, tt.limit, append(tt.options, WithClock(clk))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, s := range tt.steps {
+				clk.Set(clk.Now().Add(s.advance))
+				if got := g.AllowN(s.n); got != s.want {
+					t.Errorf("step %d: AllowN(%d) = %v, want %v", i, s.n, got, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestGCRA_SameAsTokenBucket(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	rnd := rand.New(rand.NewSource(1))
+
+	g, err := NewGCRA(time.Second, 20, WithClock(clk), WithBurst(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tb, err := NewTokenBucket(time.Second, 20, WithClock(clk), WithBurst(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 1000; i++ {
+		// multiples of the 50ms emission interval, so that both are exact
+		clk.Advance(time.Duration(rnd.Intn(3)) * 50 * time.Millisecond)
+		n := int64(rnd.Intn(3) + 1)
+
+		if got, want := g.AllowN(n), tb.AllowN(n); got != want {
+			t.Fatalf("request %d: AllowN(%d) = %v, token bucket %v", i, n, got, want)
+		}
+	}
+}
+
+func TestGCRA_Persistence(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1600000000, 0))
+	g, err := NewGCRA(time.Second, 10, WithClock(clk), WithBurst(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !g.AllowN(4) {
+		t.Fatal("AllowN(4) not allowed")
+	}
+
+	jsonBytes, err := json.Marshal(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binaryBytes, err := g.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(binaryBytes) > 32 {
+		t.Errorf("binary state is %d bytes, want at most 32", len(binaryBytes))
+	}
+
+	restoreJSON := func() (Interface, error) { return NewAlgorithmFromJSON(jsonBytes, WithClock(clk)) }
+	restoreBinary := func() (Interface, error) { return NewAlgorithmFromBinary(binaryBytes, WithClock(clk)) }
+
+	for name, restore := range map[string]func() (Interface, error){"json": restoreJSON, "binary": restoreBinary} {
+		t.Run(name, func(t *testing.T) {
+			restored, err := restore()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, ok := restored.(*GCRA); !ok {
+				t.Fatalf("restored %T, want *GCRA", restored)
+			}
+			want := []bool{true, false}
+			for i := range want {
+				if got := restored.IsAllowed(); got != want[i] {
+					t.Errorf("request %d: IsAllowed() = %v, want %v", i, got, want[i])
+				}
+			}
+		})
+	}
+
+	// a token bucket state is not a GCRA one
+	tb, err := NewTokenBucket(time.Second, 10, WithClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbBytes, err := tb.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewGCRAFromBinary(tbBytes, WithClock(clk)); err == nil {
+		t.Error("NewGCRAFromBinary() of a token bucket, want error")
+	}
+}
+
+func TestMap_GCRA(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1600000000, 0))
+	states := store.NewMemory()
+	options := []MapOption{
+		WithMapClock(clk),
+		WithPersistence(states, "limiter", time.Second),
+		WithLimitersAlgorithm(AlgorithmGCRA),
+	}
+
+	m := MustMap(time.Second, 2, options...)
+	for _, key := range []string{"a", "a", "b"} {
+		if allowed, _ := m.AllowN(key, 1); !allowed {
+			t.Fatalf("%s not allowed", key)
+		}
+	}
+	if err := m.saveState(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := NewFromStore(states, "limiter", options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+
+	if _, ok := mustGet(t, restored, "a").(*GCRA); !ok {
+		t.Errorf("restored a %T, want *GCRA", mustGet(t, restored, "a"))
+	}
+	if got := mustGet(t, restored, "a").IsAllowed(); got {
+		t.Errorf("a IsAllowed() = %v, want false", got)
+	}
+	if got := mustGet(t, restored, "b").IsAllowed(); !got {
+		t.Errorf("b IsAllowed() = %v, want true", got)
+	}
+}
@@ -0,0 +1,142 @@
+package limiter
+
+import (
+	"context"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/counter"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/journal"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/wire"
+)
+
+// ErrInvalidState is returned when a decoded state is inconsistent, e.g. with a zero window,
+// it is the same error of the counters, see counter.ErrInvalidState
+var ErrInvalidState = counter.ErrInvalidState
+
+// Interface is implemented by all the limiters of a key
+type Interface interface {
+	// IsAllowed returns true if a request fits under the limit, in that case it is accounted
+	IsAllowed() bool
+	// AllowN returns true if a request costing n units fits under the limit, in that case the n units are
+	// accounted. A non positive n is never allowed, see ErrInvalidCost.
+	AllowN(n int64) bool
+	// DecideN is the same as AllowN, but it returns the details of the decision
+	DecideN(n int64) Decision
+	// Start starts the limiter routine, if any, until ctx is cancelled or Close is called
+	Start(ctx context.Context)
+	// Close stops the limiter routine and waits for it
+	Close() error
+
+	json.Marshaler
+	json.Unmarshaler
+	encoding.BinaryMarshaler
+	encoding.BinaryUnmarshaler
+}
+
+// Algorithm identifies an implementation of Interface, it is stored
+// in the persisted state so that a restore picks the right one
+type Algorithm string
+
+const (
+	// AlgorithmWindow is the sliding window count, see Limiter
+	AlgorithmWindow Algorithm = "window"
+	// AlgorithmTokenBucket is the token bucket with a burst capacity, see TokenBucket
+	AlgorithmTokenBucket Algorithm = "token_bucket"
+	// AlgorithmGCRA is the generic cell rate algorithm, see GCRA
+	AlgorithmGCRA Algorithm = "gcra"
+)
+
+var (
+	_ Interface = (*Limiter)(nil)
+	_ Interface = (*TokenBucket)(nil)
+	_ Interface = (*GCRA)(nil)
+
+	_ journaled = (*Limiter)(nil)
+)
+
+// journaled is implemented by the limiters whose increases are appended to the journal of their Map
+type journaled interface {
+	replay(r journal.Record)
+	journalSeq() uint64
+}
+
+// emptier is implemented by the limiters that can tell whether they hold no units,
+// so that the Map can drop them without changing any decision, see WithIdleTTL
+type emptier interface {
+	empty() bool
+}
+
+// NewAlgorithm creates a limiter of the given algorithm allowing limit units per duration
+func NewAlgorithm(alg Algorithm, duration time.Duration, limit int64, options ...Option) (Interface, error) {
+	switch alg {
+	case AlgorithmWindow, "":
+		return NewLimiter(duration, limit, options...)
+	case AlgorithmTokenBucket:
+		return NewTokenBucket(duration, limit, options...)
+	case AlgorithmGCRA:
+		return NewGCRA(duration, limit, options...)
+	default:
+		return nil, fmt.Errorf("unknown limiter algorithm %q", alg)
+	}
+}
+
+// NewAlgorithmFromJSON create a limiter starting from a JSON input, the algorithm
+// of the limiter is the one stored in the input. Inputs without one are window Limiters.
+func NewAlgorithmFromJSON(bytes []byte, options ...Option) (Interface, error) {
+	algJSON := struct {
+		Algorithm Algorithm `json:"algorithm"`
+	}{}
+
+	if err := json.Unmarshal(bytes, &algJSON); err != nil {
+		return nil, fmt.Errorf("unmarshalling JSON: %w", err)
+	}
+
+	switch algJSON.Algorithm {
+	case AlgorithmWindow, "":
+		return NewLimiterFromJSON(bytes, options...)
+	case AlgorithmTokenBucket:
+		return NewTokenBucketFromJSON(bytes, options...)
+	case AlgorithmGCRA:
+		return NewGCRAFromJSON(bytes, options...)
+	default:
+		return nil, fmt.Errorf("unknown limiter algorithm %q", algJSON.Algorithm)
+	}
+}
+
+// NewAlgorithmFromBinary create a limiter starting from a binary input, the algorithm
+// of the limiter is the one stored in the input. Inputs of version 2, saved before
+// the algorithm was stored, are window Limiters.
+func NewAlgorithmFromBinary(bytes []byte, options ...Option) (Interface, error) {
+	d, err := wire.NewDecoder(bytes)
+	if err != nil {
+		return nil, fmt.Errorf("decoding header: %w", err)
+	}
+
+	alg := decodeAlgorithm(d)
+	if err := d.Err(); err != nil {
+		return nil, fmt.Errorf("decoding algorithm: %w", err)
+	}
+
+	switch alg {
+	case AlgorithmWindow:
+		return NewLimiterFromBinary(bytes, options...)
+	case AlgorithmTokenBucket:
+		return NewTokenBucketFromBinary(bytes, options...)
+	case AlgorithmGCRA:
+		return NewGCRAFromBinary(bytes, options...)
+	default:
+		return nil, fmt.Errorf("unknown limiter algorithm %q", alg)
+	}
+}
+
+// decodeAlgorithm reads the algorithm at the start of a binary limiter
+func decodeAlgorithm(d *wire.Decoder) Algorithm {
+	// states of version 2 have only window Limiters
+	if d.Version() < 3 {
+		return AlgorithmWindow
+	}
+	return Algorithm(d.String())
+}
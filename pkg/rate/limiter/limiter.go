@@ -8,88 +8,244 @@ import (
 	"time"
 
 	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/counter"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/journal"
 )
 
 const (
 	defaultResolution = 1000
 )
 
+// Limiter is the sliding window limiter: it allows limit units in any window of
+// duration, counted by a window counter, see counter.Interface
 type Limiter struct {
 	sync.Mutex
-	c     *counter.Counter
+	c     counter.Interface
 	limit int64
+
+	// reservations not yet accounted in the window, see Reserve
+	pending  []*Reservation
+	pendingN int64
+
+	config
 }
 
 // NewLimiter is the constructor of Limiter
-func NewLimiter(duration time.Duration, limit int64) (*Limiter, error) {
-	wc, err := counter.New(duration, defaultResolution)
-	if err != nil {
-		return nil, fmt.Errorf("creating counter: %v", err)
+func NewLimiter(duration time.Duration, limit int64, options ...Option) (*Limiter, error) {
+	l := &Limiter{
+		limit:  limit,
+		config: defaultConfig(),
 	}
+	l.kind = counter.KindRing
 
-	l := &Limiter{
-		sync.Mutex{},
-		wc,
-		limit,
+	for _, opt := range options {
+		opt(&l.config)
 	}
 
+	wc, err := counter.NewKind(l.kind, duration, defaultResolution, l.counterOptions()...)
+	if err != nil {
+		return nil, fmt.Errorf("creating counter: %w", err)
+	}
+	l.c = wc
+
 	return l, nil
 }
 
-func NewLimiterFromJSON(bytes []byte) (*Limiter, error) {
+// NewLimiterFromJSON create a Limiter starting from a JSON input
+func NewLimiterFromJSON(bytes []byte, options ...Option) (*Limiter, error) {
+	l := &Limiter{
+		config: defaultConfig(),
+	}
+
+	for _, opt := range options {
+		opt(&l.config)
+	}
+
+	bytes, err := limiterSchema.Migrate(bytes)
+	if err != nil {
+		return nil, err
+	}
+
 	lJSON := LimiterJSON{}
 
-	err := json.Unmarshal(bytes, &lJSON)
+	err = json.Unmarshal(bytes, &lJSON)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshalling JSON: %w", err)
+	}
+
+	// the kind of the counter is the one stored
+	counter, err := counter.NewKindFromJSON(lJSON.Counter, l.counterOptions()...)
 	if err != nil {
-		return nil, fmt.Errorf("unmarshalling JSON: %v", err)
+		return nil, fmt.Errorf("creating new counterFromJSON: %w", err)
 	}
 
-	counterJSON, err := json.Marshal(lJSON.Counter)
+	l.c = counter
+	l.limit = lJSON.Limit
+	l.reconcile()
+
+	return l, nil
+}
+
+// NewLimiterFromBinary create a Limiter starting from a binary input, see MarshalBinary
+func NewLimiterFromBinary(bytes []byte, options ...Option) (*Limiter, error) {
+	l := &Limiter{
+		config: defaultConfig(),
+	}
+
+	for _, opt := range options {
+		opt(&l.config)
+	}
+
+	limit, counterBytes, err := decodeLimiter(bytes)
 	if err != nil {
-		return nil, fmt.Errorf("marshalling counter: %v", err)
+		return nil, fmt.Errorf("unmarshalling binary: %w", err)
 	}
 
-	counter, err := counter.NewFromJSON(counterJSON)
+	// the kind of the counter is the one stored
+	c, err := counter.NewKindFromBinary(counterBytes, l.counterOptions()...)
 	if err != nil {
-		return nil, fmt.Errorf("creating new counterFromJSON")
+		return nil, fmt.Errorf("creating new counterFromBinary: %w", err)
 	}
 
-	return &Limiter{
-		Mutex: sync.Mutex{},
-		c:     counter,
-		limit: lJSON.Limit,
-	}, nil
+	l.c = c
+	l.limit = limit
+	l.reconcile()
+
+	return l, nil
+}
+
+func (l *Limiter) counterOptions() []counter.Option {
+	options := []counter.Option{
+		counter.WithClock(l.clock),
+	}
+
+	if l.tickless {
+		options = append(options, counter.WithTickless())
+	}
+
+	if l.journal != nil {
+		options = append(options, counter.WithSharedJournal(l.journal, l.journalKey))
+	}
+
+	if l.isLimitSet {
+		options = append(options, counter.WithWindow(l.duration, defaultResolution))
+	}
+
+	if l.logger != nil {
+		options = append(options, counter.WithLogger(l.logger))
+	}
+
+	return options
+}
+
+// reconcile sets the limit of a restored Limiter to the one set by WithLimit
+func (l *Limiter) reconcile() {
+	if !l.isLimitSet || l.configLimit == l.limit {
+		return
+	}
+
+	l.logf("limiter: changing limit from %d to %d\n", l.limit, l.configLimit)
+	l.limit = l.configLimit
 }
 
 // Must is same as NewLimiter but panics if there is some error
-func Must(duration time.Duration, limit int64) *Limiter {
-	l, err := NewLimiter(duration, limit)
+func Must(duration time.Duration, limit int64, options ...Option) *Limiter {
+	l, err := NewLimiter(duration, limit, options...)
 	if err != nil {
 		panic(err)
 	}
 	return l
 }
 
-// Start starts the limiter routine, it panics if it encounter some errors during the run
+// Start starts the limiter routine, it runs until ctx is cancelled or Close is called.
+// Its failure, if any, is returned by Close.
 func (l *Limiter) Start(ctx context.Context) {
 	go func() {
-		if err := l.c.Run(ctx); err != nil {
-			panic(err)
-		}
+		_ = l.c.Run(ctx)
 	}()
 }
 
+// Close stops the limiter routine and waits for it, it returns the failure of the routine, if any
+func (l *Limiter) Close() error {
+	return l.c.Close()
+}
+
+// empty returns true if the window holds no units and no reservation is pending
+func (l *Limiter) empty() bool {
+	l.Lock()
+	defer l.Unlock()
+
+	l.settle()
+	return l.c.Value() == 0 && l.pendingN == 0
+}
+
 // IsAllowed returns true if the number of request in the windows are under the limit
 func (l *Limiter) IsAllowed() bool {
+	return l.AllowN(1)
+}
+
+// AllowN returns true if a request costing n units fits under the limit,
+// in that case the n units are accounted in the window. The units of the
+// pending reservations count against the limit, see Reserve.
+func (l *Limiter) AllowN(n int64) bool {
+	if n <= 0 {
+		return false
+	}
+
 	l.Lock()
 	defer l.Unlock()
 
+	l.settle()
 	c := l.c.Value()
 
-	if c >= l.limit {
+	if c+l.pendingN+n > l.limit {
 		return false
 	}
 
-	l.c.Increase()
+	l.c.IncreaseBy(n)
 	return true
 }
+
+// replay applies a record of the journal of the Map to the counter, see counter.Journaled
+func (l *Limiter) replay(r journal.Record) {
+	l.c.Replay(r)
+}
+
+// journalSeq returns the seq of the last journal record included in the counter
+func (l *Limiter) journalSeq() uint64 {
+	return l.c.JournalSeq()
+}
+
+// DecideN is the same as AllowN, but it returns the details of the decision: ResetAt
+// and RetryAfter are computed from the ticks of the window, see Reserve
+func (l *Limiter) DecideN(n int64) Decision {
+	if n <= 0 {
+		return Decision{Limit: l.limit}
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	l.settle()
+	now := l.clock.Now()
+	c := l.c.Value()
+
+	d := Decision{Limit: l.limit}
+
+	switch excess := c + l.pendingN + n - l.limit; {
+	case excess <= 0:
+		l.c.IncreaseBy(n)
+		c += n
+		d.Allowed = true
+	case n > l.limit:
+		d.RetryAfter = l.c.Window()
+	default:
+		d.RetryAfter = l.expiry(excess, c, now).Sub(now)
+	}
+
+	if remaining := l.limit - c - l.pendingN; remaining > 0 {
+		d.Remaining = remaining
+	}
+	d.ResetAt = l.expiry(c+l.pendingN, c, now)
+
+	return d
+}
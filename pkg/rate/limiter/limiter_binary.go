@@ -0,0 +1,72 @@
+package limiter
+
+import (
+	"fmt"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/clock"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/counter"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/wire"
+)
+
+// MarshalBinary encodes the Limiter in the compact binary format of package wire
+func (l *Limiter) MarshalBinary() ([]byte, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	counterBytes, err := l.c.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("marshalling counter: %w", err)
+	}
+
+	e := wire.NewEncoder()
+	e.String(string(AlgorithmWindow))
+	e.Varint(l.limit)
+	e.Blob(counterBytes)
+
+	return e.Bytes(), nil
+}
+
+func (l *Limiter) UnmarshalBinary(bytes []byte) error {
+	l.Lock()
+	defer l.Unlock()
+
+	limit, counterBytes, err := decodeLimiter(bytes)
+	if err != nil {
+		return err
+	}
+
+	if l.clock == nil {
+		l.clock = clock.New()
+	}
+
+	c, err := counter.NewKindFromBinary(counterBytes, l.counterOptions()...)
+	if err != nil {
+		return fmt.Errorf("creating counter: %w", err)
+	}
+
+	l.limit = limit
+	l.c = c
+	l.reconcile()
+
+	return nil
+}
+
+func decodeLimiter(bytes []byte) (limit int64, counterBytes []byte, err error) {
+	d, err := wire.NewDecoder(bytes)
+	if err != nil {
+		return 0, nil, fmt.Errorf("decoding header: %w", err)
+	}
+
+	if alg := decodeAlgorithm(d); d.Err() == nil && alg != AlgorithmWindow {
+		return 0, nil, fmt.Errorf("decoding %q limiter as a %q one", alg, AlgorithmWindow)
+	}
+
+	limit = d.Varint()
+	counterBytes = d.Blob()
+
+	if err := d.Err(); err != nil {
+		return 0, nil, fmt.Errorf("decoding: %w", err)
+	}
+
+	return limit, counterBytes, nil
+}
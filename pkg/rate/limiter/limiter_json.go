@@ -2,21 +2,30 @@ package limiter
 
 import (
 	"encoding/json"
+	"fmt"
 
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/clock"
 	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/counter"
 )
 
 type LimiterJSON struct {
-	Counter *counter.Counter `json:"counter"`
-	Limit   int64            `json:"limit"`
+	Version int             `json:"version"`
+	Counter json.RawMessage `json:"counter"`
+	Limit   int64           `json:"limit"`
 }
 
 func (l *Limiter) MarshalJSON() ([]byte, error) {
 	l.Lock()
 	defer l.Unlock()
 
+	counterJSON, err := l.c.MarshalJSON()
+	if err != nil {
+		return nil, fmt.Errorf("marshalling counter: %w", err)
+	}
+
 	lJSON := LimiterJSON{
-		Counter: l.c,
+		Version: limiterSchema.Version(),
+		Counter: counterJSON,
 		Limit:   l.limit,
 	}
 
@@ -27,13 +36,28 @@ func (l *Limiter) UnmarshalJSON(bytes []byte) error {
 	l.Lock()
 	defer l.Unlock()
 
+	bytes, err := limiterSchema.Migrate(bytes)
+	if err != nil {
+		return err
+	}
+
 	lJSON := LimiterJSON{}
 	if err := json.Unmarshal(bytes, &lJSON); err != nil {
 		return err
 	}
 
+	if l.clock == nil {
+		l.clock = clock.New()
+	}
+
+	c, err := counter.NewKindFromJSON(lJSON.Counter, l.counterOptions()...)
+	if err != nil {
+		return fmt.Errorf("creating counter: %w", err)
+	}
+
 	l.limit = lJSON.Limit
-	l.c = lJSON.Counter
+	l.c = c
+	l.reconcile()
 
 	return nil
 }
@@ -2,8 +2,21 @@ package limiter
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/clock"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/counter"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/journal"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/schema"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/store"
 )
 
 func makeBoolSlice(size int, value bool) []bool {
@@ -20,6 +33,17 @@ func makeBoolSlice(size int, value bool) []bool {
 	return s
 }
 
+// mustGet returns the Limiter of key in m, failing the test if it cannot be created
+func mustGet(t *testing.T, m *Map, key string) Interface {
+	t.Helper()
+
+	l, err := m.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
 // TODO: improve tests
 func TestLimiter_Allow(t *testing.T) {
 	type fields struct {
@@ -61,3 +85,487 @@ func TestLimiter_Allow(t *testing.T) {
 		})
 	}
 }
+
+func TestLimiter_Reset(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	l := Must(time.Second, 3, WithClock(clk))
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	l.Start(ctx)
+	clk.BlockUntil(1)
+
+	want := []bool{true, true, true, false}
+	for i := range want {
+		if got := l.IsAllowed(); got != want[i] {
+			t.Errorf("request %d, IsAllowed() = %v, want %v", i, got, want[i])
+		}
+	}
+
+	// one tick more than the window, to be sure the last tick has been handled
+	clk.Advance(time.Second + time.Millisecond)
+
+	for i := range want {
+		if got := l.IsAllowed(); got != want[i] {
+			t.Errorf("after reset, request %d, IsAllowed() = %v, want %v", i, got, want[i])
+		}
+	}
+}
+
+func TestLimiter_TicklessReset(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	l := Must(time.Second, 3, WithClock(clk), WithTickless())
+
+	want := []bool{true, true, true, false}
+	for i := range want {
+		if got := l.IsAllowed(); got != want[i] {
+			t.Errorf("request %d, IsAllowed() = %v, want %v", i, got, want[i])
+		}
+	}
+
+	clk.Advance(time.Second)
+
+	for i := range want {
+		if got := l.IsAllowed(); got != want[i] {
+			t.Errorf("after reset, request %d, IsAllowed() = %v, want %v", i, got, want[i])
+		}
+	}
+}
+
+func TestLimiter_AllowN(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	l := Must(time.Second, 10, WithClock(clk), WithTickless())
+
+	tests := []struct {
+		n    int64
+		want bool
+	}{
+		{n: 4, want: true},
+		{n: 7, want: false},
+		{n: 6, want: true},
+		{n: 1, want: false},
+	}
+	for i, tt := range tests {
+		if got := l.AllowN(tt.n); got != tt.want {
+			t.Errorf("request %d, AllowN(%d) = %v, want %v", i, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestLimiter_CounterKind(t *testing.T) {
+	kinds := []counter.Kind{counter.KindRing, counter.KindSharded, counter.KindLog, counter.KindWeighted}
+
+	for _, kind := range kinds {
+		t.Run(string(kind), func(t *testing.T) {
+			clk := clock.NewFake(time.Unix(0, 0))
+			l := Must(time.Second, 3, WithClock(clk), WithTickless(), WithCounterKind(kind))
+
+			want := []bool{true, true, true, false}
+			for i := range want {
+				if got := l.IsAllowed(); got != want[i] {
+					t.Errorf("request %d, IsAllowed() = %v, want %v", i, got, want[i])
+				}
+				// the sharded counter accounts the increases at the next tick
+				clk.Advance(time.Millisecond)
+			}
+
+			bytes, err := json.Marshal(l)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			restored, err := NewLimiterFromJSON(bytes, WithClock(clk), WithTickless())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := restored.IsAllowed(); got {
+				t.Errorf("restored IsAllowed() = %v, want false", got)
+			}
+			if reflect.TypeOf(restored.c) != reflect.TypeOf(l.c) {
+				t.Errorf("restored counter %T, want %T", restored.c, l.c)
+			}
+		})
+	}
+}
+
+func TestMap_Binary(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1600000000, 0))
+	m := MustMap(time.Second, 2, WithMapClock(clk))
+
+	for _, key := range []string{"a", "a", "b"} {
+		if !mustGet(t, m, key).IsAllowed() {
+			t.Fatalf("%s not allowed", key)
+		}
+	}
+
+	bytes, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonBytes, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bytes) >= len(jsonBytes) {
+		t.Errorf("binary state is %d bytes, JSON is %d bytes", len(bytes), len(jsonBytes))
+	}
+
+	filePath := filepath.Join(t.TempDir(), "limiter")
+	for name, state := range map[string][]byte{"binary": bytes, "json": jsonBytes} {
+		t.Run(name, func(t *testing.T) {
+			if err := ioutil.WriteFile(filePath, state, 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			restored, err := NewFromFile(filePath, WithMapClock(clk))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if restored.limit != 2 || restored.duration != time.Second {
+				t.Errorf("restored limit %d duration %v, want 2 and 1s", restored.limit, restored.duration)
+			}
+			if got := mustGet(t, restored, "a").IsAllowed(); got {
+				t.Errorf("a IsAllowed() = %v, want false", got)
+			}
+			if got := mustGet(t, restored, "b").IsAllowed(); !got {
+				t.Errorf("b IsAllowed() = %v, want true", got)
+			}
+		})
+	}
+}
+
+func TestMap_Journal(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1600000000, 0))
+	states := store.NewFS(t.TempDir())
+	options := []MapOption{
+		WithMapClock(clk),
+		WithPersistence(states, "limiter", time.Second),
+		WithJournal(journal.SyncAlways, 0),
+	}
+
+	m := MustMap(time.Second, 2, options...)
+	if !mustGet(t, m, "a").IsAllowed() {
+		t.Fatalf("a not allowed")
+	}
+	if err := m.saveState(); err != nil {
+		t.Fatal(err)
+	}
+
+	// decisions after the save are only in the journal, b is not in the state
+	for _, key := range []string{"a", "b", "b"} {
+		if !mustGet(t, m, key).IsAllowed() {
+			t.Fatalf("%s not allowed", key)
+		}
+	}
+
+	restored, err := NewFromStore(states, "limiter", options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"a", "b"} {
+		if got := mustGet(t, restored, key).IsAllowed(); got {
+			t.Errorf("%s IsAllowed() = %v, want false", key, got)
+		}
+	}
+
+	clk.Advance(time.Second)
+	if got := mustGet(t, restored, "a").IsAllowed(); !got {
+		t.Errorf("after the window a IsAllowed() = %v, want true", got)
+	}
+}
+
+func TestNewFromJSON_Versions(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1600000000, 0))
+
+	// saved before the versions were introduced
+	legacy := `{"key_to_limiter":{"a":{"counter":{"windowDuration":1000000000,"counter":2,"prev_counter":0,` +
+		`"resolution":2,"counters":[0,0],"head":0,"tail":0,"at":"2020-09-13T12:26:40Z"},"limit":2}},` +
+		`"duration":1000000000,"limit":2}`
+
+	m, err := NewFromJSON([]byte(legacy), WithMapClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := mustGet(t, m, "a").IsAllowed(); got {
+		t.Errorf("legacy a IsAllowed() = %v, want false", got)
+	}
+
+	tests := map[string]string{
+		"map":     `{"version":2,"key_to_limiter":{},"duration":1000000000,"limit":2}`,
+		"limiter": `{"version":1,"key_to_limiter":{"a":{"version":2}},"duration":1000000000,"limit":2}`,
+		"counter": `{"version":1,"key_to_limiter":{"a":{"version":1,"counter":{"version":2},"limit":2}},"duration":1000000000,"limit":2}`,
+	}
+	for name, input := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := NewFromJSON([]byte(input)); !errors.Is(err, schema.ErrUnsupportedVersion) {
+				t.Errorf("NewFromJSON() error = %v, want %v", err, schema.ErrUnsupportedVersion)
+			}
+		})
+	}
+}
+
+func TestNewFromStore_Limit(t *testing.T) {
+	for _, format := range []counter.Format{counter.FormatBinary, counter.FormatJSON} {
+		clk := clock.NewFake(time.Unix(1600000000, 0))
+		states := store.NewMemory()
+		options := []MapOption{
+			WithMapClock(clk),
+			WithPersistence(states, "limiter", time.Second),
+			WithFormat(format),
+		}
+
+		m := MustMap(time.Second, 2, options...)
+		for i := 0; i < 2; i++ {
+			if !mustGet(t, m, "a").IsAllowed() {
+				t.Fatalf("format %d: a not allowed", format)
+			}
+		}
+		if err := m.saveState(); err != nil {
+			t.Fatal(err)
+		}
+
+		restored, err := NewFromStore(states, "limiter", append(options, WithMapLimit(2*time.Second, 3))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if restored.duration != 2*time.Second || restored.limit != 3 {
+			t.Errorf("format %d: restored window %v and limit %d, want %v and %d",
+				format, restored.duration, restored.limit, 2*time.Second, 3)
+		}
+
+		// the restored limiter keeps its increases under the new limit
+		a := mustGet(t, restored, "a")
+		if got := a.IsAllowed(); !got {
+			t.Errorf("format %d: a IsAllowed() = %v, want true", format, got)
+		}
+		if got := a.IsAllowed(); got {
+			t.Errorf("format %d: over the new limit a IsAllowed() = %v, want false", format, got)
+		}
+
+		// and in the new window
+		clk.Advance(time.Second)
+		if got := a.IsAllowed(); got {
+			t.Errorf("format %d: in the new window a IsAllowed() = %v, want false", format, got)
+		}
+
+		clk.Advance(time.Second)
+		if got := a.IsAllowed(); !got {
+			t.Errorf("format %d: after the new window a IsAllowed() = %v, want true", format, got)
+		}
+
+		// new keys get the new limit too
+		for i := 0; i < 3; i++ {
+			if !mustGet(t, restored, "b").IsAllowed() {
+				t.Errorf("format %d: b not allowed %d", format, i)
+			}
+		}
+	}
+}
+
+// failingStore fails the saves while failing is set
+type failingStore struct {
+	store.Store
+	m       sync.Mutex
+	failing bool
+}
+
+func (s *failingStore) Save(key string, data []byte) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.failing {
+		return errors.New("disk full")
+	}
+	return s.Store.Save(key, data)
+}
+
+func (s *failingStore) setFailing(failing bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.failing = failing
+}
+
+func TestMap_Close(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	states := &failingStore{Store: store.NewMemory(), failing: true}
+
+	handled := make(chan error, 1)
+	m := MustMap(time.Minute, 10,
+		WithMapClock(clk),
+		WithPersistence(states, "limiter", time.Second),
+		WithMapErrorHandler(func(err error) { handled <- err }),
+	)
+
+	done := make(chan error)
+	go func() {
+		done <- m.Run(context.Background())
+	}()
+
+	if allowed, _ := m.AllowN("a", 4); !allowed {
+		t.Fatal("AllowN() = false, want true")
+	}
+	// the save ticker
+	clk.BlockUntil(1)
+
+	// a failed save does not stop Run
+	clk.Advance(time.Second)
+	if err := <-handled; err == nil {
+		t.Fatal("handled a nil error")
+	}
+	if err := m.Err(); err == nil {
+		t.Error("Err() = nil after a failed save")
+	}
+
+	states.setFailing(false)
+	if err := m.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Run() error = %v", err)
+	}
+
+	// the routine of the limiter stopped
+	if err := mustGet(t, m, "a").Close(); err != nil {
+		t.Errorf("limiter Close() error = %v", err)
+	}
+
+	restored, err := NewFromStore(states, "limiter", WithMapClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allowed, _ := restored.AllowN("a", 7); allowed {
+		t.Error("restored AllowN(7) = true, want false")
+	}
+	if allowed, _ := restored.AllowN("a", 6); !allowed {
+		t.Error("restored AllowN(6) = false, want true")
+	}
+}
+
+func TestMap_IdleTTL(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	states := store.NewMemory()
+	m := MustMap(time.Second, 2,
+		WithMapClock(clk),
+		WithPersistence(states, "limiter", time.Minute),
+		WithIdleTTL(500*time.Millisecond),
+	)
+
+	for _, key := range []string{"a", "b"} {
+		if allowed, _ := m.AllowN(key, 2); !allowed {
+			t.Fatalf("AllowN(%s, 2) = false, want true", key)
+		}
+	}
+
+	// idle for the TTL, but the windows still hold the units
+	clk.Advance(600 * time.Millisecond)
+	mustGet(t, m, "b")
+	m.evictIdle()
+	if got := m.Stats(); got.Keys != 2 || got.IdleEvictions != 0 {
+		t.Errorf("Stats() = %+v, want 2 keys and no eviction", got)
+	}
+
+	// the window of a emptied, b was used since
+	clk.Advance(450 * time.Millisecond)
+	m.evictIdle()
+	if got := m.Stats(); got.Keys != 1 || got.IdleEvictions != 1 {
+		t.Errorf("Stats() = %+v, want 1 key and 1 idle eviction", got)
+	}
+
+	// the evicted key is dropped from the state
+	if err := m.saveState(); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := NewFromStore(states, "limiter", WithMapClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := restored.Stats().Keys; got != 1 {
+		t.Errorf("restored Stats().Keys = %d, want 1", got)
+	}
+	if _, ok := restored.keyToLimiter["b"]; !ok {
+		t.Error("restored Map without b")
+	}
+}
+
+func TestMap_MaxKeys(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	m := MustMap(time.Second, 2, WithMapClock(clk), WithMaxKeys(2))
+	defer m.Close()
+
+	for _, key := range []string{"a", "b", "a"} {
+		if allowed, _ := m.AllowN(key, 1); !allowed {
+			t.Fatalf("AllowN(%s, 1) = false, want true", key)
+		}
+	}
+
+	// b is the least recently used
+	mustGet(t, m, "c")
+	if got := m.Stats(); got.Keys != 2 || got.LRUEvictions != 1 {
+		t.Errorf("Stats() = %+v, want 2 keys and 1 LRU eviction", got)
+	}
+	if allowed, _ := m.AllowN("a", 1); allowed {
+		t.Error("AllowN(a, 1) = true, the window of a is full")
+	}
+
+	// so it is created again, with an empty window
+	if allowed, _ := m.AllowN("b", 2); !allowed {
+		t.Error("AllowN(b, 2) = false after the eviction of b")
+	}
+	if got := m.Stats(); got.Keys != 2 || got.LRUEvictions != 2 {
+		t.Errorf("Stats() = %+v, want 2 keys and 2 LRU evictions", got)
+	}
+}
+
+func TestMap_Tickless(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	m := MustMap(time.Second, 2, WithMapClock(clk))
+	defer m.Close()
+
+	for i := 0; i < 100; i++ {
+		if allowed, _ := m.AllowN(strconv.Itoa(i), 2); !allowed {
+			t.Fatalf("AllowN(%d, 2) = false, want true", i)
+		}
+	}
+	// no limiter has a routine to tick the window
+	clk.Advance(500 * time.Millisecond)
+	if allowed, _ := m.AllowN("0", 1); allowed {
+		t.Error("AllowN(0, 1) = true, the window is full")
+	}
+
+	// the windows emptied, they are advanced on use
+	clk.Advance(500 * time.Millisecond)
+	if allowed, _ := m.AllowN("0", 2); !allowed {
+		t.Error("AllowN(0, 2) = false, the window is empty")
+	}
+}
+
+func TestNewMap_Invalid(t *testing.T) {
+	tests := map[string]struct {
+		duration time.Duration
+		options  []MapOption
+	}{
+		"unknown algorithm": {duration: time.Second, options: []MapOption{WithLimitersAlgorithm("unknown")}},
+		"no window":         {duration: 0},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := NewMap(tt.duration, 2, tt.options...); err == nil {
+				t.Error("NewMap() no error, Get would fail")
+			}
+		})
+	}
+
+	// a restored Map creates its new keys with the algorithm of the options
+	state, err := json.Marshal(MustMap(time.Second, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFromJSON(state, WithLimitersAlgorithm("unknown")); err == nil {
+		t.Error("NewFromJSON() no error, Get would fail")
+	}
+}
@@ -1,154 +1,529 @@
 package limiter
 
 import (
+	"container/list"
 	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"os"
+	"log"
+	"path/filepath"
 	"sync"
 	"time"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/clock"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/counter"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/journal"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/lifecycle"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/store"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/wire"
 )
 
 type Map struct {
 	sync.Mutex
-	keyToLimiter         map[string]*Limiter
+	keyToLimiter         map[string]Interface
 	duration             time.Duration
 	limit                int64
-	persistenceFilePath  string
+	stateStore           store.Store
+	stateKey             string
 	savePeriod           time.Duration
 	isPersistenceEnabled bool
+	clock                clock.Clock
+	counterKind          counter.Kind
+	format               counter.Format
+
+	// algorithm of the new Limiters, see WithLimitersAlgorithm
+	algorithm  Algorithm
+	burst      int64
+	isBurstSet bool
+
+	// journal
+	journal            *journal.Journal
+	journalSync        journal.Sync
+	journalFlushPeriod time.Duration
+	isJournalEnabled   bool
+
+	// window and limit of a restored Map, see WithMapLimit
+	configDuration time.Duration
+	configLimit    int64
+	isLimitSet     bool
+
+	logger *log.Logger
+
+	// eviction of the Limiters, see WithIdleTTL and WithMaxKeys
+	idleTTL  time.Duration
+	maxKeys  int
+	recency  *list.List // of *keyUse, the most recently used first
+	keyToUse map[string]*list.Element
+	stats    MapStats
+
+	// routine of Run, see Close
+	life         lifecycle.Lifecycle
+	errorHandler func(err error)
 }
 
-func NewMap(duration time.Duration, limit int64, options ...MapOption) *Map {
+const (
+	// delays of the retries of a failed save
+	minSaveBackoff = 100 * time.Millisecond
+	maxSaveBackoff = time.Minute
+)
+
+// NewMap is the constructor of Map, it returns an error if the Limiters of the
+// new keys can not be created, e.g. with an unknown algorithm or a zero window
+func NewMap(duration time.Duration, limit int64, options ...MapOption) (*Map, error) {
 	m := &Map{
 		Mutex:        sync.Mutex{},
-		keyToLimiter: make(map[string]*Limiter),
+		keyToLimiter: make(map[string]Interface),
 		duration:     duration,
 		limit:        limit,
+		clock:        clock.New(),
 	}
 
 	for _, opt := range options {
 		opt(m)
 	}
 
+	if filePath, ok := m.journalPath(); ok {
+		m.journal = journal.Create(filePath, m.journalSync)
+	}
+
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// MustMap is equal to NewMap but panics if there is some error
+func MustMap(duration time.Duration, limit int64, options ...MapOption) *Map {
+	m, err := NewMap(duration, limit, options...)
+	if err != nil {
+		panic(err)
+	}
 	return m
 }
 
-func NewFromFile(filePath string, options ...MapOption) (m *Map, err error) {
-	f, cerr := os.Open(filePath)
-	if cerr != nil {
-		return nil, fmt.Errorf("opening file %s: %v", filePath, cerr)
+// validate creates a Limiter as Get does for a new key, so that an invalid
+// algorithm, window or limit is returned by the constructors and Get never fails
+func (m *Map) validate() error {
+	if _, err := NewAlgorithm(m.algorithm, m.duration, m.limit, m.limiterOptions("")...); err != nil {
+		return fmt.Errorf("creating limiters: %w", err)
 	}
-	defer func() {
-		if cerr = f.Close(); err == nil {
-			err = cerr
-		}
-	}()
+	return nil
+}
 
-	bytes, err := ioutil.ReadAll(f)
+// NewFromFile create a Map starting from a state file, saved with a store.FS
+func NewFromFile(filePath string, options ...MapOption) (*Map, error) {
+	return NewFromStore(store.NewFS(filepath.Dir(filePath)), filepath.Base(filePath), options...)
+}
+
+// NewFromStore create a Map starting from the state saved in s under key
+func NewFromStore(s store.Store, key string, options ...MapOption) (*Map, error) {
+	bytes, err := s.Load(key)
 	if err != nil {
-		return nil, fmt.Errorf("reading file %s: %v", filePath, err)
+		return nil, err
+	}
+
+	if wire.IsBinary(bytes) {
+		return NewFromBinary(bytes, options...)
 	}
 
 	return NewFromJSON(bytes, options...)
 }
 
 func NewFromJSON(bytes []byte, options ...MapOption) (*Map, error) {
+	bytes, err := mapSchema.Migrate(bytes)
+	if err != nil {
+		return nil, err
+	}
+
 	mJSON := MapJSON{}
 	if err := json.Unmarshal(bytes, &mJSON); err != nil {
-		return nil, fmt.Errorf("unmarshalling json: %v", err)
+		return nil, fmt.Errorf("unmarshalling json: %w", err)
 	}
 
-	keyToLimiter := make(map[string]*Limiter)
+	m := &Map{
+		Mutex:        sync.Mutex{},
+		keyToLimiter: make(map[string]Interface),
+		clock:        clock.New(),
+	}
 
-	// we must init each Limiter
-	for key, limiter := range mJSON.KeyToLimiter {
+	for _, opt := range options {
+		opt(m)
+	}
 
-		lJSON, err := json.Marshal(limiter)
-		if err != nil {
-			return nil, err
-		}
+	if filePath, ok := m.journalPath(); ok {
+		m.journal = journal.Open(filePath, m.journalSync)
+	}
 
-		initLimiter, err := NewLimiterFromJSON(lJSON)
+	for key, lJSON := range mJSON.KeyToLimiter {
+
+		initLimiter, err := NewAlgorithmFromJSON(lJSON, m.limiterOptions(key)...)
 		if err != nil {
 			return nil, err
 		}
-		initLimiter.Start(context.Background())
 
-		keyToLimiter[key] = initLimiter
+		m.keyToLimiter[key] = initLimiter
+	}
+
+	m.reconcile(mJSON.Duration, mJSON.Limit)
+
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+
+	if err := m.replayJournal(); err != nil {
+		return nil, err
 	}
 
+	m.resetRecency()
+
+	return m, nil
+}
+
+// NewFromBinary create a Map starting from a binary input, see MarshalBinary
+func NewFromBinary(bytes []byte, options ...MapOption) (*Map, error) {
 	m := &Map{
 		Mutex:        sync.Mutex{},
-		keyToLimiter: keyToLimiter,
-		duration:     mJSON.Duration,
-		limit:        mJSON.Limit,
+		keyToLimiter: make(map[string]Interface),
+		clock:        clock.New(),
 	}
 
 	for _, opt := range options {
 		opt(m)
 	}
 
+	if filePath, ok := m.journalPath(); ok {
+		m.journal = journal.Open(filePath, m.journalSync)
+	}
+
+	if err := m.UnmarshalBinary(bytes); err != nil {
+		return nil, fmt.Errorf("unmarshalling binary: %w", err)
+	}
+
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+
+	if err := m.replayJournal(); err != nil {
+		return nil, err
+	}
+
 	return m, nil
 }
 
-func (m *Map) Run(ctx context.Context) error {
+// reconcile sets the window and the limit of a restored Map, saved as duration
+// and limit, to the ones set by WithMapLimit. Its Limiters are reconciled by
+// WithLimit while they are restored. Must be called with the lock held, or
+// before the Map is returned by its constructor, when nothing else can share it.
+func (m *Map) reconcile(duration time.Duration, limit int64) {
+	m.duration = duration
+	m.limit = limit
+
+	if !m.isLimitSet || (m.configDuration == duration && m.configLimit == limit) {
+		return
+	}
+
+	if m.logger != nil {
+		m.logger.Printf("limiter map %s: changing window from %v to %v and limit from %d to %d of %d limiters\n",
+			m.stateKey, duration, m.configDuration, limit, m.configLimit, len(m.keyToLimiter))
+	}
+
+	m.duration = m.configDuration
+	m.limit = m.configLimit
+}
+
+// journalPath returns the path of the journal enabled by WithJournal, if any
+func (m *Map) journalPath() (string, bool) {
+	if !m.isJournalEnabled || !m.isPersistenceEnabled {
+		return "", false
+	}
+
+	locator, ok := m.stateStore.(store.Locator)
+	if !ok {
+		return "", false
+	}
+
+	return journal.Path(locator.Path(m.stateKey)), true
+}
+
+// replayJournal replays the journal of the Map on top of the Limiters just
+// restored, the Limiters of the keys not in the state are created
+func (m *Map) replayJournal() error {
+	if m.journal == nil {
+		return nil
+	}
+
+	filePath, _ := m.journalPath()
+	records, err := journal.Read(filePath)
+	if err != nil {
+		return fmt.Errorf("reading journal: %w", err)
+	}
+
+	for _, r := range records {
+		l, ok := m.keyToLimiter[r.Key]
+		if !ok {
+			if l, err = NewAlgorithm(m.algorithm, m.duration, m.limit, m.limiterOptions(r.Key)...); err != nil {
+				return fmt.Errorf("creating limiter %s: %w", r.Key, err)
+			}
+			m.keyToLimiter[r.Key] = l
+			m.touch(r.Key)
+		}
+
+		// only the window Limiters append to the journal
+		if j, ok := l.(journaled); ok {
+			j.replay(r)
+		}
+	}
+
+	for _, l := range m.keyToLimiter {
+		if j, ok := l.(journaled); ok {
+			m.journal.Skip(j.journalSeq())
+		}
+	}
+
+	return nil
+}
+
+// Run saves the state of the Map each save period, if persistence is enabled, and
+// evicts the idle Limiters each idle TTL, if WithIdleTTL is set, until ctx is cancelled
+// or Close is called. The state is saved a last time before returning.
+//
+// a failed save is reported and retried with an exponential backoff, see
+// WithMapErrorHandler, only the failure of the last save is returned
+func (m *Map) Run(ctx context.Context) (err error) {
+	if (!m.isPersistenceEnabled && m.idleTTL <= 0) || !m.life.Begin() {
+		return nil
+	}
+	defer func() { m.life.End(err) }()
+
+	// a nil channel is never ready
+	var save <-chan time.Time
 	if m.isPersistenceEnabled {
+		saveTicker := m.clock.NewTicker(m.savePeriod)
+		defer saveTicker.Stop()
+		save = saveTicker.C()
+	}
+
+	var sweep <-chan time.Time
+	if m.idleTTL > 0 {
+		sweepTicker := m.clock.NewTicker(m.idleTTL)
+		defer sweepTicker.Stop()
+		sweep = sweepTicker.C()
+	}
+
+	var flush <-chan time.Time
+	if m.journal != nil && m.journalSync == journal.SyncBatch {
+		flushTicker := m.clock.NewTicker(m.journalFlushPeriod)
+		defer flushTicker.Stop()
+		flush = flushTicker.C()
+	}
+
+	var (
+		retry   <-chan time.Time
+		backoff time.Duration
+	)
 
-		ticker := time.NewTicker(m.savePeriod)
+	trySave := func() {
+		if err := m.saveState(); err != nil {
+			backoff = lifecycle.Backoff(backoff, minSaveBackoff, maxSaveBackoff)
+			m.fail(fmt.Errorf("saving state of %s, retrying in %v: %w", m.stateKey, backoff, err))
+			retry = m.clock.After(backoff)
+			return
+		}
+		backoff, retry = 0, nil
+		m.life.Recover()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return m.saveFinalState()
+
+		case <-m.life.Stopped():
+			return m.saveFinalState()
+
+		case <-save:
+			// while backing off the saves wait for the retry
+			if retry == nil {
+				trySave()
+			}
+
+		case <-retry:
+			trySave()
 
-		for {
-			select {
-			case <-ctx.Done():
-				ticker.Stop()
-				return nil
+		case <-sweep:
+			m.evictIdle()
 
-			case <-ticker.C:
-				if err := m.saveState(); err != nil {
-					return err
-				}
+		case <-flush:
+			if err := m.journal.Flush(); err != nil {
+				m.fail(fmt.Errorf("flushing journal of %s: %w", m.stateKey, err))
 			}
 		}
 	}
+}
 
+// saveFinalState saves the state when Run stops, so that the increases since the last save are not lost
+func (m *Map) saveFinalState() error {
+	if !m.isPersistenceEnabled {
+		return nil
+	}
+	if err := m.saveState(); err != nil {
+		return fmt.Errorf("saving final state: %w", err)
+	}
 	return nil
 }
 
-func (m *Map) saveState() (err error) {
-	f, cerr := os.Create(m.persistenceFilePath)
-	if cerr != nil {
-		return fmt.Errorf("creating file %s: %v", m.persistenceFilePath, cerr)
+// Close stops the routine of Run, which saves the state a last time, waits for it and
+// closes the Limiters. It returns the error of the last save, if it failed.
+func (m *Map) Close() error {
+	m.Lock()
+	limiters := make([]Interface, 0, len(m.keyToLimiter))
+	for _, l := range m.keyToLimiter {
+		limiters = append(limiters, l)
 	}
-	defer func() {
-		if cerr = f.Close(); err == nil {
-			err = cerr
+	m.Unlock()
+
+	err := m.life.Close()
+
+	for _, l := range limiters {
+		if lerr := l.Close(); lerr != nil && err == nil {
+			err = lerr
 		}
-	}()
+	}
+
+	return err
+}
+
+// Wait waits for Run to return, see Close
+func (m *Map) Wait() error {
+	return m.life.Wait()
+}
 
-	bytes, err := json.Marshal(m)
+// Err returns the last failure of Run, nil if it recovered from it,
+// e.g. a save failed and the following retry succeeded
+func (m *Map) Err() error {
+	return m.life.Err()
+}
+
+// fail reports a failure of Run, see WithMapErrorHandler
+func (m *Map) fail(err error) {
+	m.life.Fail(err)
+
+	if m.errorHandler != nil {
+		m.errorHandler(err)
+		return
+	}
+	if m.logger != nil {
+		m.logger.Printf("limiter map %s: %v\n", m.stateKey, err)
+	}
+}
+
+// saveState saves the state of the Map, then drops from
+// the journal the records included in the state
+func (m *Map) saveState() (err error) {
+	var seq uint64
+	if m.journal != nil {
+		// the records appended before the marshalling are in the state
+		seq = m.journal.Seq()
+	}
+
+	var bytes []byte
+	if m.format == counter.FormatJSON {
+		bytes, err = json.Marshal(m)
+	} else {
+		bytes, err = m.MarshalBinary()
+	}
 	if err != nil {
-		return fmt.Errorf("marshalling json: %w", err)
+		return fmt.Errorf("marshalling state: %w", err)
+	}
+
+	if err := m.stateStore.Save(m.stateKey, bytes); err != nil {
+		return err
+	}
+
+	if m.journal == nil {
+		return nil
 	}
 
-	if _, err = f.Write(bytes); err != nil {
-		return fmt.Errorf("writing in file %s: %w", m.persistenceFilePath, err)
+	if err := m.journal.Compact(seq); err != nil {
+		return fmt.Errorf("compacting journal: %w", err)
 	}
 
 	return nil
 }
 
-func (m *Map) Get(key string) *Limiter {
+// Get returns the Limiter of key, creating it with the algorithm of the Map if
+// it is new, see WithLimitersAlgorithm. The constructors of the Map check that
+// the Limiters can be created, see validate, so it fails only if they did not.
+//
+// a new key evicts the least recently used ones over the cap set by WithMaxKeys
+func (m *Map) Get(key string) (Interface, error) {
 	m.Lock()
-	defer m.Unlock()
 
+	var evicted []Interface
 	l, ok := m.keyToLimiter[key]
 	if !ok {
-		l = Must(m.duration, m.limit)
+		var err error
+		if l, err = NewAlgorithm(m.algorithm, m.duration, m.limit, m.limiterOptions(key)...); err != nil {
+			m.Unlock()
+			return nil, fmt.Errorf("creating limiter of key %s: %w", key, err)
+		}
+		evicted = m.makeRoom()
 		m.keyToLimiter[key] = l
-		// TODO: manage context?
-		l.Start(context.Background())
+	}
+	m.touch(key)
+
+	m.Unlock()
+
+	m.closeEvicted(evicted)
+
+	return l, nil
+}
+
+// AllowN returns true if a request of key costing n units fits under the limit of
+// the Limiter of key, see Interface. It fails for a non positive n, see ErrInvalidCost,
+// or if the Limiter of key cannot be created, see Get. It implements Backend.
+func (m *Map) AllowN(key string, n int64) (bool, error) {
+	d, err := m.DecideN(key, n)
+	return d.Allowed, err
+}
+
+// DecideN is the same as AllowN, but it returns the details of the decision, see Interface
+func (m *Map) DecideN(key string, n int64) (Decision, error) {
+	if n <= 0 {
+		return Decision{}, fmt.Errorf("key %s: %d units: %w", key, n, ErrInvalidCost)
+	}
+	l, err := m.Get(key)
+	if err != nil {
+		return Decision{}, err
+	}
+	return l.DecideN(n), nil
+}
+
+// limiterOptions returns the options of the Limiter of key
+func (m *Map) limiterOptions(key string) []Option {
+	options := []Option{
+		WithClock(m.clock),
+	}
+
+	if m.journal != nil {
+		options = append(options, withJournal(m.journal, key))
+	}
+
+	// no Limiter has a routine, the windows are advanced when they are used
+	options = append(options, WithTickless())
+
+	if m.counterKind != "" {
+		options = append(options, WithCounterKind(m.counterKind))
+	}
+
+	if m.isLimitSet {
+		options = append(options, WithLimit(m.configDuration, m.configLimit))
+	}
+
+	if m.isBurstSet {
+		options = append(options, WithBurst(m.burst))
 	}
 
-	return l
+	return options
 }
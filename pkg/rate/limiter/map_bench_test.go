@@ -0,0 +1,90 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// the window of the per IP limiters of the server
+const benchWindow = 20 * time.Second
+
+var benchKeys = []int{10000, 100000}
+
+// BenchmarkMap_Tickless measures a Map of keys Limiters: they are tickless, so
+// the Map runs no routine to advance their windows, whatever the number of keys
+func BenchmarkMap_Tickless(b *testing.B) {
+	for _, keys := range benchKeys {
+		b.Run(fmt.Sprintf("keys=%d", keys), func(b *testing.B) {
+			m := MustMap(benchWindow, 10)
+			defer m.Close()
+
+			for i := 0; i < keys; i++ {
+				m.AllowN(strconv.Itoa(i), 1)
+			}
+
+			measureRoutines(b, benchWindow/defaultResolution)
+		})
+	}
+}
+
+// BenchmarkLimiter_Routines measures keys Limiters each advancing its window with its own routine,
+// as the Map did before its Limiters were tickless. It stops at 10k keys, which already take about
+// a core: the tickers of 100k keys starve a small machine.
+func BenchmarkLimiter_Routines(b *testing.B) {
+	for _, keys := range benchKeys[:1] {
+		b.Run(fmt.Sprintf("keys=%d", keys), func(b *testing.B) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			limiters := make([]*Limiter, keys)
+			for i := range limiters {
+				limiters[i] = Must(benchWindow, 10)
+				limiters[i].IsAllowed()
+				limiters[i].Start(ctx)
+			}
+			defer func() {
+				for _, l := range limiters {
+					_ = l.Close()
+				}
+			}()
+
+			measureRoutines(b, benchWindow/defaultResolution)
+		})
+	}
+}
+
+// measureRoutines sleeps a tick period per iteration, then it reports the goroutines
+// running and the CPU they used, as a percentage of a core
+func measureRoutines(b *testing.B, period time.Duration) {
+	// let the routines start
+	time.Sleep(period)
+	goroutines := runtime.NumGoroutine()
+
+	start, wall := cpuTime(b), time.Now()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		time.Sleep(period)
+	}
+
+	b.StopTimer()
+	b.ReportMetric(float64(goroutines), "goroutines")
+	b.ReportMetric(100*float64(cpuTime(b)-start)/float64(time.Since(wall)), "cpu%")
+}
+
+// cpuTime returns the user and system CPU time used by the process
+func cpuTime(b *testing.B) time.Duration {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		b.Fatal(err)
+	}
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+}
@@ -0,0 +1,73 @@
+package limiter
+
+import (
+	"fmt"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/clock"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/wire"
+)
+
+// MarshalBinary encodes the Map in the compact binary format of package wire
+func (m *Map) MarshalBinary() ([]byte, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	e := wire.NewEncoder()
+	e.Duration(m.duration)
+	e.Varint(m.limit)
+
+	e.Uvarint(uint64(len(m.keyToLimiter)))
+	for key, l := range m.keyToLimiter {
+		lBytes, err := l.MarshalBinary()
+		if err != nil {
+			return nil, fmt.Errorf("marshalling limiter %s: %w", key, err)
+		}
+		e.String(key)
+		e.Blob(lBytes)
+	}
+
+	return e.Bytes(), nil
+}
+
+func (m *Map) UnmarshalBinary(bytes []byte) error {
+	m.Lock()
+	defer m.Unlock()
+
+	d, err := wire.NewDecoder(bytes)
+	if err != nil {
+		return fmt.Errorf("decoding header: %w", err)
+	}
+
+	if m.clock == nil {
+		m.clock = clock.New()
+	}
+
+	duration := d.Duration()
+	limit := d.Varint()
+
+	n := d.Uvarint()
+	keyToLimiter := make(map[string]Interface)
+	for ; n > 0 && d.Err() == nil; n-- {
+		key := d.String()
+		lBytes := d.Blob()
+		if d.Err() != nil {
+			break
+		}
+
+		l, err := NewAlgorithmFromBinary(lBytes, m.limiterOptions(key)...)
+		if err != nil {
+			return fmt.Errorf("creating limiter %s: %w", key, err)
+		}
+		keyToLimiter[key] = l
+	}
+
+	if err := d.Err(); err != nil {
+		return fmt.Errorf("decoding: %w", err)
+	}
+
+	m.keyToLimiter = keyToLimiter
+	m.resetRecency()
+	m.reconcile(duration, limit)
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package limiter
+
+import (
+	"container/list"
+	"time"
+)
+
+// MapStats are the keys of a Map and the counters of their evictions, see Map.Stats
+type MapStats struct {
+	// Keys is the number of Limiters in the Map
+	Keys int `json:"keys"`
+	// IdleEvictions is the number of Limiters evicted once idle, see WithIdleTTL
+	IdleEvictions uint64 `json:"idle_evictions"`
+	// LRUEvictions is the number of Limiters evicted to make room for new keys, see WithMaxKeys
+	LRUEvictions uint64 `json:"lru_evictions"`
+}
+
+// keyUse is the last time a key was used, the element of the recency list of a Map
+type keyUse struct {
+	key string
+	at  time.Time
+}
+
+// Stats returns the number of keys of the Map and the counters of their evictions
+func (m *Map) Stats() MapStats {
+	m.Lock()
+	defer m.Unlock()
+
+	stats := m.stats
+	stats.Keys = len(m.keyToLimiter)
+	return stats
+}
+
+// touch records that key is used now, the least recently used keys are evicted
+// first. Must be called with the lock held.
+func (m *Map) touch(key string) {
+	if m.recency == nil {
+		m.recency = list.New()
+		m.keyToUse = make(map[string]*list.Element)
+	}
+
+	now := m.clock.Now()
+	if e, ok := m.keyToUse[key]; ok {
+		e.Value.(*keyUse).at = now
+		m.recency.MoveToFront(e)
+		return
+	}
+
+	m.keyToUse[key] = m.recency.PushFront(&keyUse{key: key, at: now})
+}
+
+// resetRecency records that all the keys are used now, e.g. once they are
+// restored, since the state does not keep their uses. Must be called with the lock held.
+func (m *Map) resetRecency() {
+	m.recency, m.keyToUse = nil, nil
+	for key := range m.keyToLimiter {
+		m.touch(key)
+	}
+}
+
+// evict drops the Limiter of key from the Map, and so from its state. The Limiter
+// is returned to be closed without the lock. Must be called with the lock held.
+func (m *Map) evict(key string) Interface {
+	l := m.keyToLimiter[key]
+	delete(m.keyToLimiter, key)
+
+	if e, ok := m.keyToUse[key]; ok {
+		m.recency.Remove(e)
+		delete(m.keyToUse, key)
+	}
+
+	return l
+}
+
+// makeRoom evicts the least recently used Limiters until a new key fits under the
+// cap set by WithMaxKeys, and returns them. Must be called with the lock held.
+func (m *Map) makeRoom() []Interface {
+	if m.maxKeys <= 0 || len(m.keyToLimiter) < m.maxKeys {
+		return nil
+	}
+	if m.recency == nil {
+		m.resetRecency()
+	}
+
+	var evicted []Interface
+	for len(m.keyToLimiter) >= m.maxKeys {
+		oldest := m.recency.Back()
+		if oldest == nil {
+			break
+		}
+		evicted = append(evicted, m.evict(oldest.Value.(*keyUse).key))
+		m.stats.LRUEvictions++
+	}
+
+	return evicted
+}
+
+// evictIdle evicts the Limiters not used for the idle TTL that hold no units,
+// so that dropping them changes no decision, see WithIdleTTL
+func (m *Map) evictIdle() {
+	m.Lock()
+
+	if m.recency == nil {
+		m.resetRecency()
+	}
+
+	now := m.clock.Now()
+
+	var evicted []Interface
+	// the keys are sorted by use, the least recent last
+	for e := m.recency.Back(); e != nil; {
+		use := e.Value.(*keyUse)
+		if now.Sub(use.at) < m.idleTTL {
+			break
+		}
+		e = e.Prev()
+
+		if l, ok := m.keyToLimiter[use.key].(emptier); ok && l.empty() {
+			evicted = append(evicted, m.evict(use.key))
+			m.stats.IdleEvictions++
+		}
+	}
+
+	m.Unlock()
+
+	m.closeEvicted(evicted)
+}
+
+// closeEvicted stops the routines of the evicted Limiters
+func (m *Map) closeEvicted(evicted []Interface) {
+	for _, l := range evicted {
+		if err := l.Close(); err != nil && m.logger != nil {
+			m.logger.Printf("limiter map %s: closing evicted limiter: %v\n", m.stateKey, err)
+		}
+	}
+}
@@ -2,25 +2,38 @@ package limiter
 
 import (
 	"encoding/json"
+	"fmt"
 	"time"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/clock"
 )
 
 type MapJSON struct {
-	KeyToLimiter map[string]*Limiter `json:"key_to_limiter"`
-	Duration     time.Duration       `json:"duration"`
-	Limit        int64               `json:"limit"`
+	Version      int                        `json:"version"`
+	KeyToLimiter map[string]json.RawMessage `json:"key_to_limiter"`
+	Duration     time.Duration              `json:"duration"`
+	Limit        int64                      `json:"limit"`
 }
 
-func (m Map) MarshalJSON() ([]byte, error) {
+func (m *Map) MarshalJSON() ([]byte, error) {
 	m.Lock()
 	defer m.Unlock()
 
 	mJSON := MapJSON{
-		KeyToLimiter: m.keyToLimiter,
+		Version:      mapSchema.Version(),
+		KeyToLimiter: make(map[string]json.RawMessage, len(m.keyToLimiter)),
 		Duration:     m.duration,
 		Limit:        m.limit,
 	}
 
+	for key, l := range m.keyToLimiter {
+		lJSON, err := l.MarshalJSON()
+		if err != nil {
+			return nil, fmt.Errorf("marshalling limiter %s: %w", key, err)
+		}
+		mJSON.KeyToLimiter[key] = lJSON
+	}
+
 	return json.Marshal(mJSON)
 }
 
@@ -28,15 +41,33 @@ func (m *Map) UnmarshalJSON(bytes []byte) error {
 	m.Lock()
 	defer m.Unlock()
 
+	bytes, err := mapSchema.Migrate(bytes)
+	if err != nil {
+		return err
+	}
+
 	mJSON := MapJSON{}
-	err := json.Unmarshal(bytes, &mJSON)
+	err = json.Unmarshal(bytes, &mJSON)
 	if err != nil {
 		return err
 	}
 
-	m.duration = mJSON.Duration
-	m.limit = mJSON.Limit
-	m.keyToLimiter = mJSON.KeyToLimiter
+	if m.clock == nil {
+		m.clock = clock.New()
+	}
+
+	keyToLimiter := make(map[string]Interface, len(mJSON.KeyToLimiter))
+	for key, lJSON := range mJSON.KeyToLimiter {
+		l, err := NewAlgorithmFromJSON(lJSON, m.limiterOptions(key)...)
+		if err != nil {
+			return fmt.Errorf("creating limiter %s: %w", key, err)
+		}
+		keyToLimiter[key] = l
+	}
+
+	m.keyToLimiter = keyToLimiter
+	m.resetRecency()
+	m.reconcile(mJSON.Duration, mJSON.Limit)
 
 	return nil
 }
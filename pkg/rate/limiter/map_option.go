@@ -1,17 +1,121 @@
 package limiter
 
-import "time"
+import (
+	"log"
+	"time"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/clock"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/counter"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/journal"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/store"
+)
 
 type MapOption func(m *Map)
 
-// WithPersistence set the Counter to save its state in a file
+// WithPersistence set the Map to save its state in a Store
 //
-// filePath is the path where the state file will be saved, the state
-// is stored each savePeriod, overwriting the previous saved state
-func WithPersistence(filePath string, savePeriod time.Duration) MapOption {
+// key is the key the state is saved under in s, the state is
+// stored each savePeriod, overwriting the previous saved state
+func WithPersistence(s store.Store, key string, savePeriod time.Duration) MapOption {
 	return func(m *Map) {
-		m.persistenceFilePath = filePath
+		m.stateStore = s
+		m.stateKey = key
 		m.savePeriod = savePeriod
 		m.isPersistenceEnabled = true
 	}
 }
+
+// WithMapClock set the Clock used by the Map and by all its Limiters
+func WithMapClock(clk clock.Clock) MapOption {
+	return func(m *Map) {
+		m.clock = clk
+	}
+}
+
+// WithLimitersCounterKind set the kind of counter used by all the Limiters of the Map,
+// see WithCounterKind
+func WithLimitersCounterKind(kind counter.Kind) MapOption {
+	return func(m *Map) {
+		m.counterKind = kind
+	}
+}
+
+// WithLimitersAlgorithm set the algorithm of the new Limiters of the Map, by default
+// AlgorithmWindow. Limiters restored from a saved state keep the algorithm of the state.
+func WithLimitersAlgorithm(alg Algorithm) MapOption {
+	return func(m *Map) {
+		m.algorithm = alg
+	}
+}
+
+// WithLimitersBurst set the burst of all the token bucket and GCRA Limiters of the Map, see WithBurst
+func WithLimitersBurst(burst int64) MapOption {
+	return func(m *Map) {
+		m.burst = burst
+		m.isBurstSet = true
+	}
+}
+
+// WithIdleTTL set the Map to evict the Limiters not used for ttl whose window has emptied,
+// so that evicting them changes no decision. The evicted keys are dropped from the saved
+// state. The Limiters are checked each ttl by Run, so they are evicted within twice ttl.
+func WithIdleTTL(ttl time.Duration) MapOption {
+	return func(m *Map) {
+		m.idleTTL = ttl
+	}
+}
+
+// WithMaxKeys set the maximum number of Limiters of the Map: a new key evicts the least
+// recently used Limiters over it, even if their window has not emptied. A restored state is
+// trimmed to it by the first new key, the restored keys count as used when restored.
+func WithMaxKeys(n int) MapOption {
+	return func(m *Map) {
+		m.maxKeys = n
+	}
+}
+
+// WithFormat set the encoding of the state saved with WithPersistence, by default
+// counter.FormatBinary. States are restored whatever their encoding.
+func WithFormat(format counter.Format) MapOption {
+	return func(m *Map) {
+		m.format = format
+	}
+}
+
+// WithJournal set the Map to append the increases of its Limiters to a journal between
+// the saves of its state, see counter.WithJournal. It has effect only together with
+// WithPersistence on a store.Locator, e.g. store.FS: the journal is kept next to the state file.
+func WithJournal(policy journal.Sync, flushPeriod time.Duration) MapOption {
+	return func(m *Map) {
+		m.journalSync = policy
+		m.journalFlushPeriod = flushPeriod
+		m.isJournalEnabled = true
+	}
+}
+
+// WithMapLimit set the window and the limit of a restored Map: a state saved with
+// different ones is reconciled to them, and so are all its Limiters, see WithLimit.
+// NewMap ignores it.
+func WithMapLimit(duration time.Duration, limit int64) MapOption {
+	return func(m *Map) {
+		m.configDuration = duration
+		m.configLimit = limit
+		m.isLimitSet = true
+	}
+}
+
+// WithMapLogger set the logger of the adjustments made to a restored Map, by default nothing is logged
+func WithMapLogger(logger *log.Logger) MapOption {
+	return func(m *Map) {
+		m.logger = logger
+	}
+}
+
+// WithMapErrorHandler set a function called by Run at each failed save or journal flush,
+// which are retried. By default the failures are logged, see WithMapLogger. The last
+// failure is returned by Err until a save succeeds.
+func WithMapErrorHandler(handler func(err error)) MapOption {
+	return func(m *Map) {
+		m.errorHandler = handler
+	}
+}
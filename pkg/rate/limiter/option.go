@@ -0,0 +1,107 @@
+package limiter
+
+import (
+	"log"
+	"time"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/clock"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/counter"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/journal"
+)
+
+type Option func(c *config)
+
+// config is the configuration shared by all the limiters
+type config struct {
+	clock clock.Clock
+
+	// counter of the window Limiter
+	kind     counter.Kind
+	tickless bool
+
+	// journal shared with the other Limiters of a Map, see WithJournal
+	journal    *journal.Journal
+	journalKey string
+
+	// window and limit of a restored limiter, see WithLimit
+	duration    time.Duration
+	configLimit int64
+	isLimitSet  bool
+
+	// capacity of the TokenBucket and of the GCRA, see WithBurst
+	burst      int64
+	isBurstSet bool
+
+	logger *log.Logger
+}
+
+func defaultConfig() config {
+	return config{
+		clock: clock.New(),
+	}
+}
+
+// logf logs an adjustment made to a restored limiter, see WithLogger
+func (cfg *config) logf(format string, args ...interface{}) {
+	if cfg.logger == nil {
+		return
+	}
+	cfg.logger.Printf(format, args...)
+}
+
+// WithClock set the Clock used by the Limiter, by default the system clock is used
+func WithClock(clk clock.Clock) Option {
+	return func(c *config) {
+		c.clock = clk
+	}
+}
+
+// WithTickless set the Limiter counter to run without a routine, see counter.WithTickless
+func WithTickless() Option {
+	return func(c *config) {
+		c.tickless = true
+	}
+}
+
+// WithCounterKind set the kind of counter used by the Limiter, by default counter.KindRing.
+// Limiters restored from a saved state keep the kind of the state.
+func WithCounterKind(kind counter.Kind) Option {
+	return func(c *config) {
+		c.kind = kind
+	}
+}
+
+// withJournal set the Limiter counter to append its increases to the journal of its Map
+func withJournal(j *journal.Journal, key string) Option {
+	return func(c *config) {
+		c.journal = j
+		c.journalKey = key
+	}
+}
+
+// WithLimit set the window and the limit of a restored limiter: a state saved with
+// different ones is reconciled to them, see counter.WithWindow. NewLimiter ignores it.
+func WithLimit(duration time.Duration, limit int64) Option {
+	return func(c *config) {
+		c.duration = duration
+		c.configLimit = limit
+		c.isLimitSet = true
+	}
+}
+
+// WithBurst set the capacity of a TokenBucket or a GCRA, the units it allows at once
+// after being idle, by default equal to its limit. A restored one is reconciled to it.
+// The window Limiter ignores it.
+func WithBurst(burst int64) Option {
+	return func(c *config) {
+		c.burst = burst
+		c.isBurstSet = true
+	}
+}
+
+// WithLogger set the logger of the adjustments made to a restored Limiter, by default nothing is logged
+func WithLogger(logger *log.Logger) Option {
+	return func(c *config) {
+		c.logger = logger
+	}
+}
@@ -0,0 +1,260 @@
+package limiter
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/clock"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/resp"
+)
+
+const (
+	defaultRedisResolution = 20
+	defaultRedisPrefix     = "rate:"
+	defaultRedisRetries    = 16
+)
+
+// ErrContention is returned when the transaction of a request keeps conflicting
+// with the ones of other instances on the same key, see WithRedisRetries
+var ErrContention = errors.New("too many conflicting requests on the key")
+
+// Backend decides whether the requests of a key fit under its limit.
+// It is implemented by Map, keeping the limiters in memory, and by
+// Redis, sharing them among the instances using the same server.
+type Backend interface {
+	// AllowN returns true if a request of key costing n units fits under the limit,
+	// in that case the n units are accounted in the window of key
+	AllowN(key string, n int64) (bool, error)
+	// DecideN is the same as AllowN, but it returns the details of the decision
+	DecideN(key string, n int64) (Decision, error)
+}
+
+var (
+	_ Backend = (*Map)(nil)
+	_ Backend = (*Redis)(nil)
+)
+
+// Redis is a Backend keeping the sliding window of each key in a Redis compatible
+// server, so that instances sharing the server share the limit of each key
+//
+// the window of a key is a hash of buckets, each one counting the units of a
+// duration/resolution slice of time. A request reads the hash, sums the buckets
+// in the window and, if it fits under the limit, increases the current bucket and
+// deletes the expired ones in a transaction. The transaction is optimistic:
+// WATCH makes it fail if another instance changed the key in between, in that
+// case the request is decided again on the new hash. The whole hash expires
+// when its last bucket does, so idle keys take no memory on the server.
+//
+// a bucket leaves the window as a whole, so a unit can stop being counted up to
+// duration/resolution earlier than it would by the Limiter, whose window slides
+// by ticks of duration/1000. With the default 20 buckets that is 5% of the window
+// instead of 0.1%, and a key can have a little more than limit units admitted in a
+// window of duration crossing the end of a bucket. See WithRedisResolution.
+type Redis struct {
+	client   *resp.Client
+	duration time.Duration
+	limit    int64
+
+	clock      clock.Clock
+	resolution uint64
+	prefix     string
+	retries    int
+}
+
+// NewRedis returns a Redis allowing limit units per key each duration, on the server of client
+func NewRedis(client *resp.Client, duration time.Duration, limit int64, options ...RedisOption) (*Redis, error) {
+	r := &Redis{
+		client:     client,
+		duration:   duration,
+		limit:      limit,
+		clock:      clock.New(),
+		resolution: defaultRedisResolution,
+		prefix:     defaultRedisPrefix,
+		retries:    defaultRedisRetries,
+	}
+
+	for _, opt := range options {
+		opt(r)
+	}
+
+	if r.resolution == 0 {
+		return nil, fmt.Errorf("resolution must be positive")
+	}
+	if r.period() < time.Millisecond {
+		return nil, fmt.Errorf("bucket period less than 1ms: %v", r.period())
+	}
+
+	return r, nil
+}
+
+func (r *Redis) period() time.Duration {
+	return r.duration / time.Duration(r.resolution)
+}
+
+// IsAllowed returns true if a request of key fits under the limit, see AllowN
+func (r *Redis) IsAllowed(key string) (bool, error) {
+	return r.AllowN(key, 1)
+}
+
+// AllowN returns true if a request of key costing n units fits under the limit,
+// in that case the n units are accounted in the window of key
+func (r *Redis) AllowN(key string, n int64) (bool, error) {
+	d, err := r.DecideN(key, n)
+	return d.Allowed, err
+}
+
+// DecideN is the same as AllowN, but it returns the details of the decision:
+// ResetAt and RetryAfter are computed from the buckets of the window
+func (r *Redis) DecideN(key string, n int64) (Decision, error) {
+	if n <= 0 {
+		return Decision{}, fmt.Errorf("key %s: %d units: %w", key, n, ErrInvalidCost)
+	}
+
+	conn, err := r.client.Get()
+	if err != nil {
+		return Decision{}, err
+	}
+	defer r.client.Put(conn)
+
+	for i := 0; i <= r.retries; i++ {
+		d, committed, err := r.try(conn, r.prefix+key, n)
+		if err != nil {
+			// the connection goes back to the pool without the key watched
+			_, _ = conn.Do("UNWATCH")
+			return Decision{}, fmt.Errorf("key %s: %w", key, err)
+		}
+		if committed {
+			return d, nil
+		}
+	}
+
+	return Decision{}, fmt.Errorf("key %s: %w", key, ErrContention)
+}
+
+// bucket is the count of a bucket of the window
+type bucket struct {
+	index int64
+	count int64
+}
+
+// try decides a request on the current hash of key, committed is false if
+// the transaction conflicted with another one and the request must be retried
+func (r *Redis) try(conn *resp.Conn, key string, n int64) (d Decision, committed bool, err error) {
+	if _, err := conn.Do("WATCH", key); err != nil {
+		return d, false, fmt.Errorf("watching: %w", err)
+	}
+
+	reply, err := conn.Do("HGETALL", key)
+	if err != nil {
+		return d, false, fmt.Errorf("reading window: %w", err)
+	}
+	fields, ok := reply.([]interface{})
+	if !ok || len(fields)%2 != 0 {
+		return d, false, fmt.Errorf("reading window: unexpected reply %v", reply)
+	}
+
+	now := r.clock.Now()
+	current := now.UnixNano() / int64(r.period())
+	oldest := current - int64(r.resolution) + 1
+
+	var sum int64
+	var expired []string
+	var buckets []bucket
+	for i := 0; i < len(fields); i += 2 {
+		name, _ := fields[i].([]byte)
+		index, err := strconv.ParseInt(string(name), 10, 64)
+		if err != nil {
+			return d, false, fmt.Errorf("reading window: invalid bucket %q", name)
+		}
+		if index < oldest {
+			expired = append(expired, string(name))
+			continue
+		}
+
+		count, err := resp.Int(fields[i+1])
+		if err != nil {
+			return d, false, fmt.Errorf("reading window: bucket %d: %w", index, err)
+		}
+		sum += count
+		buckets = append(buckets, bucket{index: index, count: count})
+	}
+
+	if sum+n > r.limit {
+		if _, err := conn.Do("UNWATCH"); err != nil {
+			return d, false, fmt.Errorf("unwatching: %w", err)
+		}
+		return r.decision(now, buckets, sum, n, false), true, nil
+	}
+
+	// the hash outlives its newest bucket by a window
+	ttl := (r.duration + r.period()) / time.Millisecond
+
+	_ = conn.Send("MULTI")
+	_ = conn.Send("HINCRBY", key, strconv.FormatInt(current, 10), strconv.FormatInt(n, 10))
+	if len(expired) > 0 {
+		_ = conn.Send(append([]string{"HDEL", key}, expired...)...)
+	}
+	_ = conn.Send("PEXPIRE", key, strconv.FormatInt(int64(ttl), 10))
+
+	reply, err = conn.Do("EXEC")
+	if err != nil {
+		return d, false, fmt.Errorf("updating window: %w", err)
+	}
+	if reply == nil {
+		// another instance changed the key
+		return d, false, nil
+	}
+
+	replies, ok := reply.([]interface{})
+	if !ok {
+		return d, false, fmt.Errorf("updating window: unexpected reply %v", reply)
+	}
+	for _, reply := range replies {
+		if err, ok := reply.(resp.Error); ok {
+			return d, false, fmt.Errorf("updating window: %w", err)
+		}
+	}
+
+	buckets = append(buckets, bucket{index: current, count: n})
+	return r.decision(now, buckets, sum+n, n, true), true, nil
+}
+
+// decision returns the decision of a request costing n units on the buckets of the
+// window, holding sum units after it. A bucket leaves the window a window after its start.
+func (r *Redis) decision(now time.Time, buckets []bucket, sum, n int64, allowed bool) Decision {
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].index < buckets[j].index })
+
+	expiry := func(index int64) time.Time {
+		return time.Unix(0, (index+int64(r.resolution))*int64(r.period()))
+	}
+
+	d := Decision{Allowed: allowed, Limit: r.limit, ResetAt: now}
+	if remaining := r.limit - sum; remaining > 0 {
+		d.Remaining = remaining
+	}
+	if len(buckets) > 0 {
+		d.ResetAt = expiry(buckets[len(buckets)-1].index)
+	}
+
+	if allowed {
+		return d
+	}
+	if n > r.limit {
+		d.RetryAfter = r.duration
+		return d
+	}
+
+	excess := sum + n - r.limit
+	for _, b := range buckets {
+		excess -= b.count
+		if excess <= 0 {
+			d.RetryAfter = expiry(b.index).Sub(now)
+			break
+		}
+	}
+
+	return d
+}
@@ -0,0 +1,41 @@
+package limiter
+
+import (
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/clock"
+)
+
+type RedisOption func(r *Redis)
+
+// WithRedisClock set the Clock used to pick the bucket of the requests, by default the system clock.
+// The instances sharing a server should have clocks synchronized within a bucket.
+func WithRedisClock(clk clock.Clock) RedisOption {
+	return func(r *Redis) {
+		r.clock = clk
+	}
+}
+
+// WithRedisResolution set the number of buckets the window is split in, by default 20.
+// More buckets slide the window more smoothly, at the cost of bigger hashes on the server:
+// 1000 buckets admit the same units as the in memory Limiter, but each request then
+// reads up to 1000 fields. The buckets must be at least 1ms long.
+func WithRedisResolution(resolution uint64) RedisOption {
+	return func(r *Redis) {
+		r.resolution = resolution
+	}
+}
+
+// WithRedisPrefix set the prefix of the keys on the server, by default "rate:",
+// limiters with different windows or limits must use different prefixes
+func WithRedisPrefix(prefix string) RedisOption {
+	return func(r *Redis) {
+		r.prefix = prefix
+	}
+}
+
+// WithRedisRetries set how many times a request is retried when its transaction
+// conflicts with another instance, by default 16. See ErrContention.
+func WithRedisRetries(retries int) RedisOption {
+	return func(r *Redis) {
+		r.retries = retries
+	}
+}
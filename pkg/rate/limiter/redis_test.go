@@ -0,0 +1,257 @@
+package limiter
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/clock"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/resp"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/resp/resptest"
+)
+
+func newRedisServer(t *testing.T, clk clock.Clock) *resptest.Server {
+	t.Helper()
+
+	srv, err := resptest.NewServer(clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = srv.Close() })
+
+	return srv
+}
+
+func newRedis(t *testing.T, srv *resptest.Server, clk clock.Clock, duration time.Duration, limit int64, options ...RedisOption) *Redis {
+	t.Helper()
+
+	client := resp.NewClient(srv.Addr(), resp.WithTimeout(time.Second))
+	t.Cleanup(func() { _ = client.Close() })
+
+	r, err := NewRedis(client, duration, limit, append([]RedisOption{WithRedisClock(clk)}, options...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return r
+}
+
+func TestRedis_AllowN(t *testing.T) {
+	type step struct {
+		advance time.Duration
+		key     string
+		n       int64
+		want    bool
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "limit",
+			steps: []step{
+				{key: "a", n: 6, want: true},
+				{key: "a", n: 4, want: true},
+				{key: "a", n: 1, want: false},
+				// keys have their own windows
+				{key: "b", n: 10, want: true},
+				{key: "a", n: 1, want: false},
+			},
+		},
+		{
+			name: "over the limit",
+			steps: []step{
+				{key: "a", n: 11, want: false},
+				// a denied request is not accounted
+				{key: "a", n: 10, want: true},
+			},
+		},
+		{
+			name: "sliding window",
+			steps: []step{
+				{key: "a", n: 5, want: true},
+				{advance: 500 * time.Millisecond, key: "a", n: 5, want: true},
+				{advance: 400 * time.Millisecond, key: "a", n: 1, want: false},
+				// the first 5 units slide out of the window
+				{advance: 100 * time.Millisecond, key: "a", n: 5, want: true},
+				{key: "a", n: 1, want: false},
+				{advance: 500 * time.Millisecond, key: "a", n: 5, want: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := clock.NewFake(time.Unix(0, 0))
+			r := newRedis(t, newRedisServer(t, clk), clk, time.Second, 10, WithRedisResolution(10))
+
+			for i, s := range tt.steps {
+				clk.Advance(s.advance)
+				got, err := r.AllowN(s.key, s.n)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != s.want {
+					t.Errorf("step %d: AllowN(%s, %d) = %v, want %v", i, s.key, s.n, got, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRedis_DecideN(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	r := newRedis(t, newRedisServer(t, clk), clk, time.Second, 10, WithRedisResolution(10))
+
+	steps := []struct {
+		advance    time.Duration
+		n          int64
+		allowed    bool
+		remaining  int64
+		retryAfter time.Duration
+		resetAt    time.Duration
+	}{
+		{n: 6, allowed: true, remaining: 4, resetAt: time.Second},
+		// the 6 units of the first bucket leave the window after 1s
+		{advance: 500 * time.Millisecond, n: 5, remaining: 4, retryAfter: 500 * time.Millisecond, resetAt: time.Second},
+		{n: 4, allowed: true, remaining: 0, resetAt: 1500 * time.Millisecond},
+		// a request over the limit never fits
+		{n: 11, remaining: 0, retryAfter: time.Second, resetAt: 1500 * time.Millisecond},
+	}
+	for i, s := range steps {
+		clk.Advance(s.advance)
+
+		d, err := r.DecideN("a", s.n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Allowed != s.allowed || d.Limit != 10 || d.Remaining != s.remaining {
+			t.Errorf("step %d: Allowed %v, Limit %d, Remaining %d, want %v, 10, %d",
+				i, d.Allowed, d.Limit, d.Remaining, s.allowed, s.remaining)
+		}
+		if d.RetryAfter != s.retryAfter {
+			t.Errorf("step %d: RetryAfter %v, want %v", i, d.RetryAfter, s.retryAfter)
+		}
+		if resetAt := d.ResetAt.Sub(time.Unix(0, 0)); resetAt != s.resetAt {
+			t.Errorf("step %d: ResetAt after %v, want %v", i, resetAt, s.resetAt)
+		}
+	}
+}
+
+func TestRedis_SameAsLimiter(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	r := newRedis(t, newRedisServer(t, clk), clk, time.Second, 10)
+	l := Must(time.Second, 10, WithClock(clk), WithTickless())
+
+	for i := 0; i < 40; i++ {
+		got, err := r.IsAllowed("key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := l.IsAllowed(); got != want {
+			t.Errorf("request %d: IsAllowed() = %v, Limiter.IsAllowed() = %v", i, got, want)
+		}
+		clk.Advance(100 * time.Millisecond)
+	}
+}
+
+func TestRedis_Shared(t *testing.T) {
+	const limit = 25
+
+	clk := clock.NewFake(time.Now())
+	srv := newRedisServer(t, clk)
+
+	// two instances, each with its own connections
+	instances := []*Redis{
+		newRedis(t, srv, clk, time.Minute, limit, WithRedisRetries(1000)),
+		newRedis(t, srv, clk, time.Minute, limit, WithRedisRetries(1000)),
+	}
+
+	var allowed int64
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(r *Redis) {
+			defer wg.Done()
+			ok, err := r.IsAllowed("client")
+			if err != nil {
+				errs <- err
+				return
+			}
+			if ok {
+				atomic.AddInt64(&allowed, 1)
+			}
+		}(instances[i%len(instances)])
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if allowed != limit {
+		t.Errorf("allowed %d requests, want %d", allowed, limit)
+	}
+}
+
+func TestRedis_Error(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	srv := newRedisServer(t, clk)
+	r := newRedis(t, srv, clk, time.Second, 10, WithRedisRetries(0))
+
+	other := resp.NewClient(srv.Addr())
+	defer other.Close()
+
+	// the key holds a value of another type
+	if _, err := other.Do("SET", "rate:key", "x"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.AllowN("key", 1); err == nil {
+		t.Fatal("AllowN() on a string: want an error")
+	}
+
+	// the failed request left no key watched on the pooled connection,
+	// so changing it does not make the next transaction conflict
+	if _, err := other.Do("DEL", "rate:key"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := r.AllowN("another", 1); err != nil || !ok {
+		t.Errorf("AllowN() = %v, %v, want true", ok, err)
+	}
+}
+
+func TestRedis_Expire(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	srv := newRedisServer(t, clk)
+	r := newRedis(t, srv, clk, time.Second, 10, WithRedisPrefix("test:"))
+
+	if _, err := r.AllowN("key", 3); err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err := r.client.Do("HGETALL", "test:key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fields, _ := reply.([]interface{}); len(fields) != 2 {
+		t.Fatalf("HGETALL = %v, want a bucket", reply)
+	}
+
+	// an idle key is deleted by the server
+	clk.Advance(time.Second + r.period())
+	if reply, err := r.client.Do("PTTL", "test:key"); err != nil || reply != int64(-2) {
+		t.Errorf("PTTL of an idle key = %v, %v, want -2", reply, err)
+	}
+}
+
+func TestNewRedis_Invalid(t *testing.T) {
+	client := resp.NewClient("127.0.0.1:0")
+
+	if _, err := NewRedis(client, time.Second, 10, WithRedisResolution(0)); err == nil {
+		t.Error("NewRedis() with resolution 0: want an error")
+	}
+	if _, err := NewRedis(client, time.Second, 10, WithRedisResolution(10000)); err == nil {
+		t.Error("NewRedis() with a bucket shorter than 1ms: want an error")
+	}
+}
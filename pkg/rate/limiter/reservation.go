@@ -0,0 +1,207 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ErrExceedsLimit is returned when a request costs more units than the limit, so it never fits
+var ErrExceedsLimit = errors.New("cost exceeds the limit")
+
+// Reservation is a request of n units that fits under the limit of a Limiter at a
+// time, possibly in the future, see Limiter.Reserve. Until that time its units are
+// pending: they count against the limit, and they are accounted in the window then.
+type Reservation struct {
+	l  *Limiter
+	n  int64
+	ok bool
+	at time.Time // time the request may proceed
+
+	// guarded by the lock of l
+	accounted bool
+	cancelled bool
+}
+
+// OK returns false if the request never fits under the limit, i.e. it costs more than the limit
+func (r *Reservation) OK() bool {
+	return r.ok
+}
+
+// Delay returns the time until the request may proceed, zero if it may proceed now
+func (r *Reservation) Delay() time.Duration {
+	if !r.ok {
+		return 0
+	}
+	return maxDuration(0, r.at.Sub(r.l.clock.Now()))
+}
+
+// Cancel gives the units of the reservation back to the Limiter, either pending
+// or already accounted in the window, so that other requests may use them
+func (r *Reservation) Cancel() {
+	if !r.ok {
+		return
+	}
+
+	l := r.l
+	l.Lock()
+	defer l.Unlock()
+
+	if r.cancelled {
+		return
+	}
+	r.cancelled = true
+
+	l.settle()
+
+	if r.accounted {
+		l.c.DecreaseBy(r.n)
+		return
+	}
+
+	for i, p := range l.pending {
+		if p == r {
+			l.pending = append(l.pending[:i], l.pending[i+1:]...)
+			l.pendingN -= r.n
+			return
+		}
+	}
+}
+
+// Reserve reserves a request, see ReserveN
+func (l *Limiter) Reserve() *Reservation {
+	return l.ReserveN(1)
+}
+
+// ReserveN reserves a request costing n units at the first time it fits under the limit:
+// now, in that case the units are accounted at once, or when enough units expire from the
+// window. The delay is computed from the ticks of the window, see counter.Interface.ExpiresIn.
+//
+// a request costing more than the limit, or a non positive n, is never reserved.
+// pending reservations are not persisted with the state of the Limiter
+func (l *Limiter) ReserveN(n int64) *Reservation {
+	l.Lock()
+	defer l.Unlock()
+
+	r := &Reservation{l: l, n: n}
+	if n <= 0 || n > l.limit {
+		return r
+	}
+	r.ok = true
+
+	l.settle()
+	now := l.clock.Now()
+	c := l.c.Value()
+
+	// units that must leave the window before the request fits
+	excess := c + l.pendingN + n - l.limit
+	if excess <= 0 {
+		l.c.IncreaseBy(n)
+		r.at = now
+		r.accounted = true
+		return r
+	}
+
+	r.at = l.expiry(excess, c, now)
+
+	// the pending reservations are sorted by time
+	i := sort.Search(len(l.pending), func(i int) bool { return l.pending[i].at.After(r.at) })
+	l.pending = append(l.pending, nil)
+	copy(l.pending[i+1:], l.pending[i:])
+	l.pending[i] = r
+	l.pendingN += n
+
+	return r
+}
+
+// expiry returns the time excess units leave the window holding c units, the pending
+// reservations included. Must be called with the lock held, and with excess at most
+// c plus the pending units.
+func (l *Limiter) expiry(excess, c int64, now time.Time) time.Time {
+	if excess <= 0 {
+		return now
+	}
+
+	if delay, ok := l.c.ExpiresIn(excess); ok {
+		return now.Add(delay)
+	}
+
+	// the pending reservations must expire too: they are accounted
+	// in the window at their time, and leave it a window later
+	excess -= c
+	for _, p := range l.pending {
+		excess -= p.n
+		if excess <= 0 {
+			return p.at.Add(l.c.Window())
+		}
+	}
+
+	return now.Add(l.c.Window())
+}
+
+// settle accounts in the window the pending reservations whose time has come.
+// Must be called with the lock held.
+func (l *Limiter) settle() {
+	if len(l.pending) == 0 {
+		return
+	}
+
+	now := l.clock.Now()
+
+	i := 0
+	for ; i < len(l.pending) && !l.pending[i].at.After(now); i++ {
+		r := l.pending[i]
+		l.c.IncreaseBy(r.n)
+		l.pendingN -= r.n
+		r.accounted = true
+	}
+
+	l.pending = l.pending[i:]
+}
+
+// Wait blocks until a request fits under the limit, see WaitN
+func (l *Limiter) Wait(ctx context.Context) error {
+	return l.WaitN(ctx, 1)
+}
+
+// WaitN blocks until a request costing n units fits under the limit, then accounts it.
+// If ctx is done first the reservation is cancelled and the error of ctx is returned.
+func (l *Limiter) WaitN(ctx context.Context, n int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if n <= 0 {
+		return fmt.Errorf("waiting for %d units: %w", n, ErrInvalidCost)
+	}
+
+	r := l.ReserveN(n)
+	if !r.OK() {
+		return fmt.Errorf("waiting for %d units under the limit of %d: %w", n, l.limit, ErrExceedsLimit)
+	}
+
+	delay := r.Delay()
+	if delay == 0 {
+		return nil
+	}
+
+	select {
+	case <-l.clock.After(delay):
+		l.Lock()
+		l.settle()
+		l.Unlock()
+		return nil
+
+	case <-ctx.Done():
+		r.Cancel()
+		return ctx.Err()
+	}
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,108 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/clock"
+)
+
+func TestLimiter_Reserve(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	l := Must(time.Second, 2, WithClock(clk), WithTickless())
+
+	for i := 0; i < 2; i++ {
+		if r := l.Reserve(); !r.OK() || r.Delay() != 0 {
+			t.Fatalf("reservation %d: OK() = %v, Delay() = %v, want true and 0", i, r.OK(), r.Delay())
+		}
+	}
+
+	clk.Advance(300 * time.Millisecond)
+
+	// both units of the window expire a window after their tick
+	first, second := l.Reserve(), l.Reserve()
+	for i, r := range []*Reservation{first, second} {
+		if got := r.Delay(); got != 700*time.Millisecond {
+			t.Errorf("reservation %d: Delay() = %v, want 700ms", i, got)
+		}
+	}
+
+	// then the pending reservations must expire too
+	third := l.Reserve()
+	if got := third.Delay(); got != 1700*time.Millisecond {
+		t.Errorf("third Delay() = %v, want 1.7s", got)
+	}
+
+	if r := l.ReserveN(3); r.OK() {
+		t.Error("ReserveN(3) over a limit of 2, OK() = true")
+	}
+
+	// the pending reservations hold the units freed by the window
+	third.Cancel()
+	clk.Advance(700 * time.Millisecond)
+	if l.IsAllowed() {
+		t.Error("IsAllowed() = true, the units are reserved")
+	}
+
+	// a cancelled reservation gives its units back, even once accounted
+	second.Cancel()
+	second.Cancel()
+	if !l.IsAllowed() {
+		t.Error("IsAllowed() = false after Cancel")
+	}
+	if l.IsAllowed() {
+		t.Error("IsAllowed() = true over the limit")
+	}
+}
+
+func TestLimiter_Wait(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	l := Must(time.Second, 1, WithClock(clk), WithTickless())
+
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- l.Wait(context.Background())
+	}()
+
+	clk.BlockUntil(1)
+	clk.Advance(time.Second)
+	if err := <-done; err != nil {
+		t.Errorf("Wait() error = %v", err)
+	}
+	if l.IsAllowed() {
+		t.Error("IsAllowed() = true, the unit is taken by Wait")
+	}
+
+	// a cancelled wait gives its reservation back
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		done <- l.Wait(ctx)
+	}()
+
+	clk.BlockUntil(1)
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait() error = %v, want %v", err, context.Canceled)
+	}
+
+	clk.Advance(time.Second)
+	if !l.IsAllowed() {
+		t.Error("IsAllowed() = false after a cancelled Wait")
+	}
+
+	if err := l.WaitN(context.Background(), 2); !errors.Is(err, ErrExceedsLimit) {
+		t.Errorf("WaitN(2) error = %v, want %v", err, ErrExceedsLimit)
+	}
+	if err := l.WaitN(context.Background(), -1); !errors.Is(err, ErrInvalidCost) {
+		t.Errorf("WaitN(-1) error = %v, want %v", err, ErrInvalidCost)
+	}
+	if r := l.ReserveN(0); r.OK() {
+		t.Error("ReserveN(0) OK, want a rejected reservation")
+	}
+}
@@ -0,0 +1,11 @@
+package limiter
+
+import "github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/schema"
+
+// schemas of the JSON documents, documents of older versions are migrated when unmarshalled
+var (
+	limiterSchema     = schema.New("limiter", 1).Register(0, schema.Unchanged)
+	tokenBucketSchema = schema.New("token bucket", 1)
+	gcraSchema        = schema.New("gcra", 1)
+	mapSchema         = schema.New("limiter map", 1).Register(0, schema.Unchanged)
+)
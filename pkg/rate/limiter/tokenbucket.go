@@ -0,0 +1,201 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// TokenBucket is the token bucket limiter: the bucket holds up to capacity tokens,
+// refilled at limit tokens per window, and each unit allowed takes a token.
+// Unlike the window Limiter it allows a burst of capacity units after being idle,
+// and it keeps O(1) state without a routine.
+type TokenBucket struct {
+	sync.Mutex
+	window   time.Duration
+	limit    int64
+	capacity int64
+	tokens   float64
+	at       time.Time // time of the last refill
+
+	config
+}
+
+// NewTokenBucket is the constructor of TokenBucket, it allows limit units per duration
+// and bursts of limit units, see WithBurst. The bucket starts full.
+func NewTokenBucket(duration time.Duration, limit int64, options ...Option) (*TokenBucket, error) {
+	tb := &TokenBucket{
+		window:   duration,
+		limit:    limit,
+		capacity: limit,
+		config:   defaultConfig(),
+	}
+
+	for _, opt := range options {
+		opt(&tb.config)
+	}
+
+	if tb.isBurstSet {
+		tb.capacity = tb.burst
+	}
+
+	if err := tb.validate(); err != nil {
+		return nil, err
+	}
+
+	tb.tokens = float64(tb.capacity)
+	tb.at = tb.clock.Now()
+
+	return tb, nil
+}
+
+// NewTokenBucketFromJSON create a TokenBucket starting from a JSON input
+func NewTokenBucketFromJSON(bytes []byte, options ...Option) (*TokenBucket, error) {
+	tb := &TokenBucket{
+		config: defaultConfig(),
+	}
+
+	for _, opt := range options {
+		opt(&tb.config)
+	}
+
+	if err := tb.unmarshalJSON(bytes); err != nil {
+		return nil, err
+	}
+
+	return tb, nil
+}
+
+// NewTokenBucketFromBinary create a TokenBucket starting from a binary input, see MarshalBinary
+func NewTokenBucketFromBinary(bytes []byte, options ...Option) (*TokenBucket, error) {
+	tb := &TokenBucket{
+		config: defaultConfig(),
+	}
+
+	for _, opt := range options {
+		opt(&tb.config)
+	}
+
+	if err := tb.unmarshalBinary(bytes); err != nil {
+		return nil, fmt.Errorf("unmarshalling binary: %w", err)
+	}
+
+	return tb, nil
+}
+
+// validate checks the window and the capacity of the bucket
+func (tb *TokenBucket) validate() error {
+	if tb.window <= 0 {
+		return fmt.Errorf("token bucket window %v must be positive", tb.window)
+	}
+	if tb.capacity < 0 {
+		return fmt.Errorf("token bucket burst %d must not be negative", tb.capacity)
+	}
+	return nil
+}
+
+// reconcile sets the rate and the capacity of a restored TokenBucket to the ones set by
+// WithLimit and WithBurst. Without WithBurst the capacity follows the limit set by WithLimit.
+// Must be called with the lock held.
+func (tb *TokenBucket) reconcile() {
+	if tb.isLimitSet && (tb.duration != tb.window || tb.configLimit != tb.limit) {
+		tb.logf("token bucket: changing rate from %d per %v to %d per %v\n",
+			tb.limit, tb.window, tb.configLimit, tb.duration)
+		tb.window = tb.duration
+		tb.limit = tb.configLimit
+	}
+
+	capacity := tb.capacity
+	switch {
+	case tb.isBurstSet:
+		capacity = tb.burst
+	case tb.isLimitSet:
+		capacity = tb.limit
+	}
+
+	if capacity == tb.capacity {
+		return
+	}
+
+	tb.logf("token bucket: changing burst from %d to %d\n", tb.capacity, capacity)
+	tb.capacity = capacity
+	if tb.tokens > float64(capacity) {
+		tb.tokens = float64(capacity)
+	}
+}
+
+// refill adds the tokens gained since the last refill. A clock stepped
+// back restarts the refill from now. Must be called with the lock held.
+func (tb *TokenBucket) refill() {
+	now := tb.clock.Now()
+	elapsed := now.Sub(tb.at)
+	tb.at = now
+
+	if elapsed <= 0 {
+		return
+	}
+
+	tb.tokens += float64(elapsed) * float64(tb.limit) / float64(tb.window)
+	if tb.tokens > float64(tb.capacity) {
+		tb.tokens = float64(tb.capacity)
+	}
+}
+
+// empty returns true if the bucket is full again, i.e. as if it was new
+func (tb *TokenBucket) empty() bool {
+	tb.Lock()
+	defer tb.Unlock()
+
+	tb.refill()
+	return tb.tokens >= float64(tb.capacity)
+}
+
+// Start does nothing, the bucket is refilled when it is used
+func (tb *TokenBucket) Start(context.Context) {}
+
+// Close does nothing, the bucket has no routine
+func (tb *TokenBucket) Close() error {
+	return nil
+}
+
+// IsAllowed returns true if the bucket holds a token, in that case it is taken
+func (tb *TokenBucket) IsAllowed() bool {
+	return tb.AllowN(1)
+}
+
+// AllowN returns true if a request costing n units fits in the tokens of
+// the bucket, in that case the n tokens are taken
+func (tb *TokenBucket) AllowN(n int64) bool {
+	return tb.DecideN(n).Allowed
+}
+
+// DecideN is the same as AllowN, but it returns the details of the decision:
+// the whole limit is available again when the bucket is full
+func (tb *TokenBucket) DecideN(n int64) Decision {
+	if n <= 0 {
+		return Decision{Limit: tb.limit}
+	}
+
+	tb.Lock()
+	defer tb.Unlock()
+
+	tb.refill()
+
+	d := Decision{Limit: tb.limit}
+
+	switch {
+	case float64(n) <= tb.tokens:
+		tb.tokens -= float64(n)
+		d.Allowed = true
+	case n > tb.capacity:
+		d.RetryAfter = tb.window
+	default:
+		d.RetryAfter = ceilDuration(float64(n)-tb.tokens, tb.window, tb.limit)
+	}
+
+	d.Remaining = int64(tb.tokens)
+	d.ResetAt = tb.at.Add(ceilDuration(float64(tb.capacity)-tb.tokens, tb.window, tb.limit))
+
+	return d
+}
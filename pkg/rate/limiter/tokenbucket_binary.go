@@ -0,0 +1,60 @@
+package limiter
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/clock"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/wire"
+)
+
+// MarshalBinary encodes the TokenBucket in the compact binary format of package wire
+func (tb *TokenBucket) MarshalBinary() ([]byte, error) {
+	tb.Lock()
+	defer tb.Unlock()
+
+	e := wire.NewEncoder()
+	e.String(string(AlgorithmTokenBucket))
+	e.Duration(tb.window)
+	e.Varint(tb.limit)
+	e.Varint(tb.capacity)
+	e.Uvarint(math.Float64bits(tb.tokens))
+	e.Time(tb.at)
+
+	return e.Bytes(), nil
+}
+
+func (tb *TokenBucket) UnmarshalBinary(bytes []byte) error {
+	tb.Lock()
+	defer tb.Unlock()
+
+	if tb.clock == nil {
+		tb.clock = clock.New()
+	}
+
+	return tb.unmarshalBinary(bytes)
+}
+
+// unmarshalBinary must be called with the lock held
+func (tb *TokenBucket) unmarshalBinary(bytes []byte) error {
+	d, err := wire.NewDecoder(bytes)
+	if err != nil {
+		return fmt.Errorf("decoding header: %w", err)
+	}
+
+	if alg := decodeAlgorithm(d); d.Err() == nil && alg != AlgorithmTokenBucket {
+		return fmt.Errorf("decoding %q limiter as a %q one", alg, AlgorithmTokenBucket)
+	}
+
+	window := d.Duration()
+	limit := d.Varint()
+	burst := d.Varint()
+	tokens := math.Float64frombits(d.Uvarint())
+	at := d.Time()
+
+	if err := d.Err(); err != nil {
+		return fmt.Errorf("decoding: %w", err)
+	}
+
+	return tb.restore(window, limit, burst, tokens, at)
+}
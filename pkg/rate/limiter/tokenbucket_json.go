@@ -0,0 +1,84 @@
+package limiter
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/clock"
+)
+
+type TokenBucketJSON struct {
+	Version   int           `json:"version"`
+	Algorithm Algorithm     `json:"algorithm"`
+	Window    time.Duration `json:"window"`
+	Limit     int64         `json:"limit"`
+	Burst     int64         `json:"burst"`
+	Tokens    float64       `json:"tokens"`
+	At        time.Time     `json:"at"`
+}
+
+func (tb *TokenBucket) MarshalJSON() ([]byte, error) {
+	tb.Lock()
+	defer tb.Unlock()
+
+	tbJSON := TokenBucketJSON{
+		Version:   tokenBucketSchema.Version(),
+		Algorithm: AlgorithmTokenBucket,
+		Window:    tb.window,
+		Limit:     tb.limit,
+		Burst:     tb.capacity,
+		Tokens:    tb.tokens,
+		At:        tb.at,
+	}
+
+	return json.Marshal(tbJSON)
+}
+
+func (tb *TokenBucket) UnmarshalJSON(bytes []byte) error {
+	tb.Lock()
+	defer tb.Unlock()
+
+	if tb.clock == nil {
+		tb.clock = clock.New()
+	}
+
+	return tb.unmarshalJSON(bytes)
+}
+
+// unmarshalJSON must be called with the lock held
+func (tb *TokenBucket) unmarshalJSON(bytes []byte) error {
+	bytes, err := tokenBucketSchema.Migrate(bytes)
+	if err != nil {
+		return err
+	}
+
+	tbJSON := TokenBucketJSON{}
+	if err := json.Unmarshal(bytes, &tbJSON); err != nil {
+		return fmt.Errorf("unmarshalling JSON: %w", err)
+	}
+
+	if tbJSON.Algorithm != AlgorithmTokenBucket {
+		return fmt.Errorf("unmarshalling %q limiter as a %q one", tbJSON.Algorithm, AlgorithmTokenBucket)
+	}
+
+	return tb.restore(tbJSON.Window, tbJSON.Limit, tbJSON.Burst, tbJSON.Tokens, tbJSON.At)
+}
+
+// restore sets the state of a restored bucket. Must be called with the lock held.
+func (tb *TokenBucket) restore(window time.Duration, limit, burst int64, tokens float64, at time.Time) error {
+	tb.window = window
+	tb.limit = limit
+	tb.capacity = burst
+	tb.tokens = tokens
+	tb.at = at
+
+	// the state is validated before it is reconciled with the options
+	if err := tb.validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidState, err)
+	}
+
+	tb.reconcile()
+
+	return nil
+}
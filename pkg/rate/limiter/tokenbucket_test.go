@@ -0,0 +1,257 @@
+package limiter
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/clock"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/counter"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/store"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/wire"
+)
+
+func TestTokenBucket_AllowN(t *testing.T) {
+	type step struct {
+		advance time.Duration
+		n       int64
+		want    bool
+	}
+	tests := []struct {
+		name    string
+		options []Option
+		steps   []step
+	}{
+		{
+			name: "burst of limit",
+			steps: []step{
+				{n: 10, want: true},
+				{n: 1, want: false},
+				// one token each 100ms
+				{advance: 100 * time.Millisecond, n: 1, want: true},
+				{n: 1, want: false},
+				{advance: 250 * time.Millisecond, n: 2, want: true},
+				{n: 1, want: false},
+			},
+		},
+		{
+			name:    "burst smaller than limit",
+			options: []Option{WithBurst(3)},
+			steps: []step{
+				{n: 3, want: true},
+				{n: 1, want: false},
+				// the tokens are capped at the burst
+				{advance: time.Minute, n: 4, want: false},
+				{n: 3, want: true},
+			},
+		},
+		{
+			name:    "burst larger than limit",
+			options: []Option{WithBurst(25)},
+			steps: []step{
+				{n: 25, want: true},
+				{n: 1, want: false},
+				{advance: 2 * time.Second, n: 20, want: true},
+				{n: 1, want: false},
+			},
+		},
+		{
+			name: "request larger than burst",
+			steps: []step{
+				{n: 11, want: false},
+				{n: 10, want: true},
+			},
+		},
+		{
+			name: "clock stepped back",
+			steps: []step{
+				{n: 10, want: true},
+				{advance: -time.Hour, n: 1, want: false},
+				{advance: 100 * time.Millisecond, n: 1, want: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := clock.NewFake(time.Unix(0, 0))
+			tb, err := NewTokenBucket(time.Second, 10, append(tt.options, WithClock(clk))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, s := range tt.steps {
+				clk.Set(clk.Now().Add(s.advance))
+				if got := tb.AllowN(s.n); got != s.want {
+					t.Errorf("step %d: AllowN(%d) = %v, want %v", i, s.n, got, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestNewTokenBucket_Invalid(t *testing.T) {
+	if _, err := NewTokenBucket(0, 10); err == nil {
+		t.Error("NewTokenBucket() with a zero window, want error")
+	}
+	if _, err := NewTokenBucket(time.Second, 10, WithBurst(-1)); err == nil {
+		t.Error("NewTokenBucket() with a negative burst, want error")
+	}
+}
+
+func TestTokenBucket_Persistence(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1600000000, 0))
+	tb, err := NewTokenBucket(time.Second, 10, WithClock(clk), WithBurst(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tb.AllowN(4) {
+		t.Fatal("AllowN(4) not allowed")
+	}
+
+	jsonBytes, err := json.Marshal(tb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binaryBytes, err := tb.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, state := range map[string][]byte{"json": jsonBytes, "binary": binaryBytes} {
+		t.Run(name, func(t *testing.T) {
+			var restored Interface
+			if wire.IsBinary(state) {
+				restored, err = NewAlgorithmFromBinary(state, WithClock(clk))
+			} else {
+				restored, err = NewAlgorithmFromJSON(state, WithClock(clk))
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, ok := restored.(*TokenBucket); !ok {
+				t.Fatalf("restored %T, want *TokenBucket", restored)
+			}
+			want := []bool{true, false}
+			for i := range want {
+				if got := restored.IsAllowed(); got != want[i] {
+					t.Errorf("request %d: IsAllowed() = %v, want %v", i, got, want[i])
+				}
+			}
+		})
+	}
+
+	// a restored bucket is reconciled to the configured rate and burst
+	restored, err := NewTokenBucketFromJSON(jsonBytes, WithClock(clk), WithLimit(time.Second, 20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.limit != 20 || restored.capacity != 20 {
+		t.Errorf("reconciled limit %d burst %d, want 20 and 20", restored.limit, restored.capacity)
+	}
+
+	// a window Limiter state is not a token bucket one
+	l := Must(time.Second, 10, WithClock(clk), WithTickless())
+	lBytes, err := l.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewTokenBucketFromBinary(lBytes, WithClock(clk)); err == nil {
+		t.Error("NewTokenBucketFromBinary() of a window limiter, want error")
+	}
+}
+
+func TestMap_Algorithm(t *testing.T) {
+	for _, format := range []counter.Format{counter.FormatBinary, counter.FormatJSON} {
+		clk := clock.NewFake(time.Unix(1600000000, 0))
+		states := store.NewMemory()
+		options := []MapOption{
+			WithMapClock(clk),
+			WithPersistence(states, "limiter", time.Second),
+			WithFormat(format),
+		}
+
+		// a window Map, its limiters are restored as window ones
+		window := MustMap(time.Second, 2, options...)
+		if !mustGet(t, window, "window").AllowN(2) {
+			t.Fatalf("format %d: window not allowed", format)
+		}
+		if err := window.saveState(); err != nil {
+			t.Fatal(err)
+		}
+		if err := window.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		restored, err := NewFromStore(states, "limiter", append(options, WithLimitersAlgorithm(AlgorithmTokenBucket), WithLimitersBurst(4))...)
+		if err != nil {
+			t.Fatalf("format %d: %v", format, err)
+		}
+		if _, ok := mustGet(t, restored, "window").(*Limiter); !ok {
+			t.Errorf("format %d: restored window limiter %T, want *Limiter", format, mustGet(t, restored, "window"))
+		}
+
+		// the new keys get a token bucket
+		if !mustGet(t, restored, "bucket").AllowN(4) {
+			t.Errorf("format %d: burst of 4 not allowed", format)
+		}
+		if mustGet(t, restored, "bucket").IsAllowed() {
+			t.Errorf("format %d: request over the burst allowed", format)
+		}
+		if err := restored.saveState(); err != nil {
+			t.Fatal(err)
+		}
+		if err := restored.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		again, err := NewFromStore(states, "limiter", options...)
+		if err != nil {
+			t.Fatalf("format %d: %v", format, err)
+		}
+		if _, ok := mustGet(t, again, "bucket").(*TokenBucket); !ok {
+			t.Errorf("format %d: restored bucket %T, want *TokenBucket", format, mustGet(t, again, "bucket"))
+		}
+		if mustGet(t, again, "bucket").IsAllowed() {
+			t.Errorf("format %d: restored empty bucket allowed", format)
+		}
+		clk.Advance(time.Second)
+		if !mustGet(t, again, "bucket").AllowN(2) {
+			t.Errorf("format %d: refilled bucket not allowed", format)
+		}
+		if err := again.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestNewAlgorithmFromBinary_Version2(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1600000000, 0))
+	l := Must(time.Second, 1, WithClock(clk), WithTickless())
+	if !l.IsAllowed() {
+		t.Fatal("not allowed")
+	}
+
+	// a version 2 limiter has no algorithm before the limit
+	counterBytes, err := l.c.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := wire.NewEncoder()
+	e.Varint(1)
+	e.Blob(counterBytes)
+	state := e.Bytes()
+	// the version follows the 4 magic bytes
+	state[4] = 2
+
+	restored, err := NewAlgorithmFromBinary(state, WithClock(clk), WithTickless())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := restored.(*Limiter); !ok {
+		t.Fatalf("restored %T, want *Limiter", restored)
+	}
+	if restored.IsAllowed() {
+		t.Error("restored IsAllowed() = true, want false")
+	}
+}
@@ -0,0 +1,367 @@
+// Package resp implements a minimal client of the Redis serialization
+// protocol (RESP), enough to keep the rate structures in a Redis
+// compatible server
+//
+// replies are decoded as: string for simple strings, int64 for integers,
+// []byte for bulk strings, []interface{} for arrays, nil for null bulk
+// strings and arrays, and Error for errors.
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Error is an error reply of the server
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// ErrClosed is returned when using a closed Client
+var ErrClosed = errors.New("client closed")
+
+const (
+	defaultMaxIdle = 8
+	defaultTimeout = 5 * time.Second
+)
+
+// Client is a pool of connections to a server
+type Client struct {
+	addr    string
+	timeout time.Duration
+
+	m      sync.Mutex
+	idle   []*Conn
+	max    int
+	closed bool
+}
+
+// Option configures a Client
+type Option func(c *Client)
+
+// WithTimeout set the timeout of dialing and of each command, by default 5s
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// WithMaxIdle set the number of idle connections kept by the Client, by default 8
+func WithMaxIdle(n int) Option {
+	return func(c *Client) {
+		c.max = n
+	}
+}
+
+// NewClient returns a Client of the server at addr, connections are dialed when needed
+func NewClient(addr string, options ...Option) *Client {
+	c := &Client{
+		addr:    addr,
+		timeout: defaultTimeout,
+		max:     defaultMaxIdle,
+	}
+
+	for _, opt := range options {
+		opt(c)
+	}
+
+	return c
+}
+
+// Get returns an idle connection, or a new one. The connection must be
+// given back with Put, it is not shared until then.
+func (c *Client) Get() (*Conn, error) {
+	c.m.Lock()
+	if c.closed {
+		c.m.Unlock()
+		return nil, ErrClosed
+	}
+	if n := len(c.idle); n > 0 {
+		conn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.m.Unlock()
+		return conn, nil
+	}
+	c.m.Unlock()
+
+	nc, err := net.DialTimeout("tcp", c.addr, c.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("dialing %s: %w", c.addr, err)
+	}
+
+	return &Conn{
+		c:       nc,
+		r:       bufio.NewReader(nc),
+		w:       bufio.NewWriter(nc),
+		timeout: c.timeout,
+	}, nil
+}
+
+// Put gives back a connection returned by Get, broken connections are closed
+func (c *Client) Put(conn *Conn) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if conn.broken || c.closed || len(c.idle) >= c.max {
+		_ = conn.c.Close()
+		return
+	}
+
+	c.idle = append(c.idle, conn)
+}
+
+// Do sends a command on an idle connection and returns its reply,
+// an error reply is returned as an Error
+func (c *Client) Do(args ...string) (interface{}, error) {
+	conn, err := c.Get()
+	if err != nil {
+		return nil, err
+	}
+	defer c.Put(conn)
+
+	return conn.Do(args...)
+}
+
+// Close closes the idle connections, the ones in use are closed by Put
+func (c *Client) Close() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.closed = true
+	for _, conn := range c.idle {
+		_ = conn.c.Close()
+	}
+	c.idle = nil
+
+	return nil
+}
+
+// Conn is a connection to a server, it is not safe for concurrent use
+//
+// commands can be pipelined with Send, Flush and Receive. A connection
+// that encounters an I/O or protocol error is broken and not reused.
+type Conn struct {
+	c       net.Conn
+	r       *bufio.Reader
+	w       *bufio.Writer
+	timeout time.Duration
+	broken  bool
+	pending int // commands sent and not received
+}
+
+// Do sends a command and returns its reply, after the replies of the pending commands
+func (conn *Conn) Do(args ...string) (interface{}, error) {
+	if err := conn.Send(args...); err != nil {
+		return nil, err
+	}
+	if err := conn.Flush(); err != nil {
+		return nil, err
+	}
+
+	var reply interface{}
+	for conn.pending > 0 {
+		var err error
+		if reply, err = conn.Receive(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err, ok := reply.(Error); ok {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// Send buffers a command, see Flush
+func (conn *Conn) Send(args ...string) error {
+	buf := conn.w
+	buf.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		buf.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n")
+		buf.WriteString(arg)
+		buf.WriteString("\r\n")
+	}
+
+	conn.pending++
+	return nil
+}
+
+// Flush writes the buffered commands to the server
+func (conn *Conn) Flush() error {
+	if err := conn.c.SetWriteDeadline(time.Now().Add(conn.timeout)); err != nil {
+		return conn.fail(err)
+	}
+	if err := conn.w.Flush(); err != nil {
+		return conn.fail(fmt.Errorf("writing: %w", err))
+	}
+
+	return nil
+}
+
+// Receive returns the reply of the oldest pending command, an error reply is returned as an Error value
+func (conn *Conn) Receive() (interface{}, error) {
+	if err := conn.c.SetReadDeadline(time.Now().Add(conn.timeout)); err != nil {
+		return nil, conn.fail(err)
+	}
+
+	reply, err := readReply(conn.r)
+	if err != nil {
+		return nil, conn.fail(err)
+	}
+	conn.pending--
+
+	return reply, nil
+}
+
+func (conn *Conn) fail(err error) error {
+	conn.broken = true
+	return err
+}
+
+// readReply reads a reply from r
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, fmt.Errorf("empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+
+	case '-':
+		return Error(line[1:]), nil
+
+	case ':':
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer reply %q", line)
+		}
+		return n, nil
+
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < -1 {
+			return nil, fmt.Errorf("invalid bulk string length %q", line)
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, fmt.Errorf("reading bulk string: %w", err)
+		}
+		return data[:n], nil
+
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < -1 {
+			return nil, fmt.Errorf("invalid array length %q", line)
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		values := make([]interface{}, n)
+		for i := range values {
+			if values[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+
+	default:
+		return nil, fmt.Errorf("invalid reply type %q", line[0])
+	}
+}
+
+// readLine reads a line terminated by CRLF, without the terminator
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("reading: %w", err)
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("line not terminated by CRLF")
+	}
+
+	return line[:len(line)-2], nil
+}
+
+// ReadCommand reads a command sent by a client from r, it is meant for servers
+func ReadCommand(r *bufio.Reader) ([]string, error) {
+	reply, err := readReply(r)
+	if err != nil {
+		return nil, err
+	}
+
+	values, ok := reply.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("command is not an array")
+	}
+
+	args := make([]string, len(values))
+	for i, v := range values {
+		b, ok := v.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("command argument %d is not a bulk string", i)
+		}
+		args[i] = string(b)
+	}
+
+	return args, nil
+}
+
+// WriteReply encodes v as a reply on w, with the types of the replies decoded
+// by the Client, it is meant for servers
+func WriteReply(w *bufio.Writer, v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case string:
+		w.WriteString("+" + v + "\r\n")
+	case Error:
+		w.WriteString("-" + string(v) + "\r\n")
+	case int64:
+		w.WriteString(":" + strconv.FormatInt(v, 10) + "\r\n")
+	case []byte:
+		w.WriteString("$" + strconv.Itoa(len(v)) + "\r\n")
+		w.Write(v)
+		w.WriteString("\r\n")
+	case []interface{}:
+		if v == nil {
+			w.WriteString("*-1\r\n")
+			return
+		}
+		w.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for _, value := range v {
+			WriteReply(w, value)
+		}
+	default:
+		w.WriteString("-ERR unsupported reply type\r\n")
+	}
+}
+
+// Int returns the integer in reply, either an integer or a bulk string
+func Int(reply interface{}) (int64, error) {
+	switch reply := reply.(type) {
+	case int64:
+		return reply, nil
+	case []byte:
+		return strconv.ParseInt(string(reply), 10, 64)
+	case nil:
+		return 0, nil
+	default:
+		return 0, fmt.Errorf("unexpected reply type %T", reply)
+	}
+}
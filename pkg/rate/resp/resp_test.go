@@ -0,0 +1,190 @@
+package resp_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/clock"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/resp"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/resp/resptest"
+)
+
+func newServer(t *testing.T, clk clock.Clock) *resp.Client {
+	t.Helper()
+
+	srv, err := resptest.NewServer(clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = srv.Close() })
+
+	client := resp.NewClient(srv.Addr(), resp.WithTimeout(time.Second))
+	t.Cleanup(func() { _ = client.Close() })
+
+	return client
+}
+
+func TestClient_Do(t *testing.T) {
+	client := newServer(t, clock.NewFake(time.Now()))
+
+	tests := []struct {
+		args    []string
+		want    interface{}
+		wantErr bool
+	}{
+		{args: []string{"PING"}, want: "PONG"},
+		{args: []string{"GET", "k"}, want: nil},
+		{args: []string{"SET", "k", "a\r\nb"}, want: "OK"},
+		{args: []string{"GET", "k"}, want: []byte("a\r\nb")},
+		{args: []string{"INCRBY", "k", "1"}, wantErr: true},
+		{args: []string{"HINCRBY", "h", "f", "2"}, want: int64(2)},
+		{args: []string{"HINCRBY", "h", "g", "3"}, want: int64(3)},
+		{args: []string{"HGETALL", "h"}, want: []interface{}{[]byte("f"), []byte("2"), []byte("g"), []byte("3")}},
+		{args: []string{"HGETALL", "none"}, want: []interface{}{}},
+		{args: []string{"DEL", "k", "h", "none"}, want: int64(2)},
+		{args: []string{"NOPE"}, wantErr: true},
+	}
+
+	for i, tt := range tests {
+		got, err := client.Do(tt.args...)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("%d: Do(%q) error = %v, wantErr %v", i, tt.args, err, tt.wantErr)
+		}
+		if tt.wantErr {
+			var e resp.Error
+			if !errors.As(err, &e) {
+				t.Errorf("%d: Do(%q) error = %T, want a resp.Error", i, tt.args, err)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%d: Do(%q) = %#v, want %#v", i, tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestConn_Pipeline(t *testing.T) {
+	client := newServer(t, clock.NewFake(time.Now()))
+
+	conn, err := client.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Put(conn)
+
+	for i := 0; i < 3; i++ {
+		if err := conn.Send("INCRBY", "k", "2"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		got, err := conn.Receive()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := int64(2 * (i + 1)); got != want {
+			t.Errorf("reply %d = %v, want %d", i, got, want)
+		}
+	}
+
+	// Do returns the reply of its command, after the pending ones
+	_ = conn.Send("INCRBY", "k", "1")
+	got, err := conn.Do("GET", "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := resp.Int(got); err != nil || n != 7 {
+		t.Errorf("GET = %v, %v, want 7", got, err)
+	}
+}
+
+func TestConn_Transaction(t *testing.T) {
+	client := newServer(t, clock.NewFake(time.Now()))
+
+	a, err := client.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Put(a)
+
+	b, err := client.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Put(b)
+
+	exec := func(conn *resp.Conn) interface{} {
+		t.Helper()
+		for _, args := range [][]string{{"MULTI"}, {"INCRBY", "k", "1"}} {
+			if _, err := conn.Do(args...); err != nil {
+				t.Fatal(err)
+			}
+		}
+		reply, err := conn.Do("EXEC")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return reply
+	}
+
+	if _, err := a.Do("WATCH", "k"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a change of the watched key aborts the transaction
+	if _, err := b.Do("SET", "k", "10"); err != nil {
+		t.Fatal(err)
+	}
+	if reply := exec(a); reply != nil {
+		t.Errorf("EXEC after a conflicting change = %v, want nil", reply)
+	}
+
+	// EXEC unwatches the keys
+	if _, err := b.Do("SET", "k", "20"); err != nil {
+		t.Fatal(err)
+	}
+	if reply, want := exec(a), []interface{}{int64(21)}; !reflect.DeepEqual(reply, want) {
+		t.Errorf("EXEC = %v, want %v", reply, want)
+	}
+}
+
+func TestServer_Expire(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	client := newServer(t, clk)
+
+	for _, args := range [][]string{{"SET", "k", "v"}, {"PEXPIRE", "k", "1000"}} {
+		if _, err := client.Do(args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	clk.Advance(999 * time.Millisecond)
+	if got, err := client.Do("PTTL", "k"); err != nil || got != int64(1) {
+		t.Errorf("PTTL = %v, %v, want 1", got, err)
+	}
+
+	clk.Advance(time.Millisecond)
+	if got, err := client.Do("GET", "k"); err != nil || got != nil {
+		t.Errorf("expired GET = %v, %v, want nil", got, err)
+	}
+}
+
+func TestClient_Close(t *testing.T) {
+	client := newServer(t, clock.NewFake(time.Now()))
+
+	if _, err := client.Do("PING"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Do("PING"); !errors.Is(err, resp.ErrClosed) {
+		t.Errorf("Do after Close error = %v, want ErrClosed", err)
+	}
+}
@@ -0,0 +1,450 @@
+// Package resptest implements an in-process stand-in of a Redis compatible
+// server, for the tests of the clients of package resp
+//
+// it supports the subset of the commands used by this module: strings,
+// hashes, expiries and optimistic transactions (WATCH, MULTI and EXEC).
+// Expiries follow a clock.Clock, so that tests can use a fake one.
+package resptest
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/clock"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/resp"
+)
+
+var (
+	errSyntax      = resp.Error("ERR syntax error")
+	errInteger     = resp.Error("ERR value is not an integer or out of range")
+	errWrongType   = resp.Error("WRONGTYPE Operation against a key holding the wrong kind of value")
+	errNestedMulti = resp.Error("ERR MULTI calls can not be nested")
+	errNoMulti     = resp.Error("ERR EXEC without MULTI")
+	errWatchMulti  = resp.Error("ERR WATCH inside MULTI is not allowed")
+	errExecAbort   = resp.Error("EXECABORT Transaction discarded because of previous errors.")
+)
+
+// Server is an in-memory server listening on a local port
+type Server struct {
+	l     net.Listener
+	clock clock.Clock
+
+	m        sync.Mutex
+	keys     map[string]*value
+	versions map[string]uint64 // bumped at each write of a key, for WATCH
+	conns    map[net.Conn]struct{}
+	commands int
+}
+
+type value struct {
+	str      []byte
+	hash     map[string][]byte
+	expireAt time.Time
+}
+
+// NewServer starts a Server on a local port, expiring the keys by clk
+func NewServer(clk clock.Clock) (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		l:        l,
+		clock:    clk,
+		keys:     make(map[string]*value),
+		versions: make(map[string]uint64),
+		conns:    make(map[net.Conn]struct{}),
+	}
+
+	go s.serve()
+
+	return s, nil
+}
+
+// Addr returns the address the Server listens on
+func (s *Server) Addr() string {
+	return s.l.Addr().String()
+}
+
+// Commands returns the number of commands executed, the ones queued in a transaction included
+func (s *Server) Commands() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.commands
+}
+
+// Close stops the Server and closes its connections
+func (s *Server) Close() error {
+	err := s.l.Close()
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for c := range s.conns {
+		_ = c.Close()
+	}
+
+	return err
+}
+
+func (s *Server) serve() {
+	for {
+		c, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+
+		s.m.Lock()
+		s.conns[c] = struct{}{}
+		s.m.Unlock()
+
+		go s.handle(c)
+	}
+}
+
+// session is the state of a connection
+type session struct {
+	watched map[string]uint64
+	queued  [][]string
+	inMulti bool
+	dirty   bool // a command queued in the transaction is invalid
+}
+
+func (s *Server) handle(c net.Conn) {
+	defer func() {
+		s.m.Lock()
+		delete(s.conns, c)
+		s.m.Unlock()
+		_ = c.Close()
+	}()
+
+	r, w := bufio.NewReader(c), bufio.NewWriter(c)
+	sess := &session{}
+
+	for {
+		args, err := resp.ReadCommand(r)
+		if err != nil {
+			return
+		}
+
+		resp.WriteReply(w, s.exec(sess, args))
+
+		// pipelined commands are answered together
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// exec executes a command of sess
+func (s *Server) exec(sess *session, args []string) interface{} {
+	if len(args) == 0 {
+		return errSyntax
+	}
+	name := strings.ToUpper(args[0])
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	switch name {
+	case "MULTI":
+		if sess.inMulti {
+			return errNestedMulti
+		}
+		sess.inMulti = true
+		return "OK"
+
+	case "DISCARD":
+		if !sess.inMulti {
+			return resp.Error("ERR DISCARD without MULTI")
+		}
+		*sess = session{}
+		return "OK"
+
+	case "EXEC":
+		if !sess.inMulti {
+			return errNoMulti
+		}
+		defer func() { *sess = session{} }()
+
+		if sess.dirty {
+			return errExecAbort
+		}
+		for key, version := range sess.watched {
+			s.expire(key)
+			if s.versions[key] != version {
+				return []interface{}(nil)
+			}
+		}
+
+		replies := make([]interface{}, len(sess.queued))
+		for i, queued := range sess.queued {
+			replies[i] = s.command(queued)
+		}
+		return replies
+
+	case "WATCH":
+		if sess.inMulti {
+			return errWatchMulti
+		}
+		if sess.watched == nil {
+			sess.watched = make(map[string]uint64)
+		}
+		for _, key := range args[1:] {
+			s.expire(key)
+			sess.watched[key] = s.versions[key]
+		}
+		return "OK"
+
+	case "UNWATCH":
+		sess.watched = nil
+		return "OK"
+	}
+
+	if sess.inMulti {
+		if _, ok := commands[name]; !ok {
+			sess.dirty = true
+			return resp.Error("ERR unknown command '" + args[0] + "'")
+		}
+		sess.queued = append(sess.queued, args)
+		return "QUEUED"
+	}
+
+	return s.command(args)
+}
+
+// commands are the commands out of the transactions, with their minimum number of arguments
+var commands = map[string]int{
+	"PING":     0,
+	"TIME":     0,
+	"FLUSHALL": 0,
+	"GET":      1,
+	"SET":      2,
+	"DEL":      1,
+	"INCRBY":   2,
+	"PEXPIRE":  2,
+	"PTTL":     1,
+	"HGETALL":  1,
+	"HINCRBY":  3,
+	"HDEL":     2,
+}
+
+// command executes a command that is not about transactions. Must be called with the lock held.
+func (s *Server) command(args []string) interface{} {
+	name := strings.ToUpper(args[0])
+	min, ok := commands[name]
+	if !ok {
+		return resp.Error("ERR unknown command '" + args[0] + "'")
+	}
+	if len(args)-1 < min {
+		return resp.Error("ERR wrong number of arguments for '" + args[0] + "' command")
+	}
+	s.commands++
+
+	for _, key := range keysOf(name, args) {
+		s.expire(key)
+	}
+
+	switch name {
+	case "PING":
+		return "PONG"
+
+	case "TIME":
+		now := s.clock.Now()
+		return []interface{}{
+			[]byte(strconv.FormatInt(now.Unix(), 10)),
+			[]byte(strconv.FormatInt(int64(now.Nanosecond()/1000), 10)),
+		}
+
+	case "FLUSHALL":
+		for key := range s.keys {
+			s.write(key)
+		}
+		s.keys = make(map[string]*value)
+		return "OK"
+
+	case "GET":
+		v, ok := s.keys[args[1]]
+		if !ok {
+			return nil
+		}
+		if v.hash != nil {
+			return errWrongType
+		}
+		return v.str
+
+	case "SET":
+		s.keys[args[1]] = &value{str: []byte(args[2])}
+		s.write(args[1])
+		return "OK"
+
+	case "DEL":
+		var n int64
+		for _, key := range args[1:] {
+			if _, ok := s.keys[key]; ok {
+				delete(s.keys, key)
+				s.write(key)
+				n++
+			}
+		}
+		return n
+
+	case "INCRBY":
+		delta, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return errInteger
+		}
+		v, ok := s.keys[args[1]]
+		if !ok {
+			v = &value{}
+			s.keys[args[1]] = v
+		}
+		if v.hash != nil {
+			return errWrongType
+		}
+		n, err := parseInt(v.str)
+		if err != nil {
+			return errInteger
+		}
+		n += delta
+		v.str = []byte(strconv.FormatInt(n, 10))
+		s.write(args[1])
+		return n
+
+	case "PEXPIRE":
+		ms, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return errInteger
+		}
+		v, ok := s.keys[args[1]]
+		if !ok {
+			return int64(0)
+		}
+		v.expireAt = s.clock.Now().Add(time.Duration(ms) * time.Millisecond)
+		s.write(args[1])
+		return int64(1)
+
+	case "PTTL":
+		v, ok := s.keys[args[1]]
+		if !ok {
+			return int64(-2)
+		}
+		if v.expireAt.IsZero() {
+			return int64(-1)
+		}
+		return int64(v.expireAt.Sub(s.clock.Now()) / time.Millisecond)
+
+	case "HGETALL":
+		v, ok := s.keys[args[1]]
+		if !ok {
+			return []interface{}{}
+		}
+		if v.hash == nil {
+			return errWrongType
+		}
+		fields := make([]string, 0, len(v.hash))
+		for field := range v.hash {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		reply := make([]interface{}, 0, 2*len(fields))
+		for _, field := range fields {
+			reply = append(reply, []byte(field), v.hash[field])
+		}
+		return reply
+
+	case "HINCRBY":
+		delta, err := strconv.ParseInt(args[3], 10, 64)
+		if err != nil {
+			return errInteger
+		}
+		v, ok := s.keys[args[1]]
+		if !ok {
+			v = &value{hash: make(map[string][]byte)}
+			s.keys[args[1]] = v
+		}
+		if v.hash == nil {
+			return errWrongType
+		}
+		n, err := parseInt(v.hash[args[2]])
+		if err != nil {
+			return errInteger
+		}
+		n += delta
+		v.hash[args[2]] = []byte(strconv.FormatInt(n, 10))
+		s.write(args[1])
+		return n
+
+	case "HDEL":
+		v, ok := s.keys[args[1]]
+		if !ok {
+			return int64(0)
+		}
+		if v.hash == nil {
+			return errWrongType
+		}
+		var n int64
+		for _, field := range args[2:] {
+			if _, ok := v.hash[field]; ok {
+				delete(v.hash, field)
+				n++
+			}
+		}
+		if len(v.hash) == 0 {
+			delete(s.keys, args[1])
+		}
+		s.write(args[1])
+		return n
+	}
+
+	return errSyntax
+}
+
+// keysOf returns the keys accessed by a command
+func keysOf(name string, args []string) []string {
+	switch name {
+	case "PING", "TIME", "FLUSHALL":
+		return nil
+	case "DEL":
+		return args[1:]
+	default:
+		return args[1:2]
+	}
+}
+
+// expire deletes key if it is expired. Must be called with the lock held.
+func (s *Server) expire(key string) {
+	v, ok := s.keys[key]
+	if !ok || v.expireAt.IsZero() || s.clock.Now().Before(v.expireAt) {
+		return
+	}
+
+	delete(s.keys, key)
+	s.write(key)
+}
+
+// write records a write of key, failing the transactions watching it. Must be called with the lock held.
+func (s *Server) write(key string) {
+	s.versions[key]++
+}
+
+func parseInt(b []byte) (int64, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return 0, errors.New("not an integer")
+	}
+	return n, nil
+}
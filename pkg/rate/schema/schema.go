@@ -0,0 +1,107 @@
+// Package schema versions the JSON documents of the persisted states
+//
+// each document carries the version of its layout in the version field,
+// documents saved before the field was introduced are version 0. When a
+// document is loaded it is upgraded to the current version one step at
+// a time, by the migrations registered in its Schema.
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// versionField is the field of the documents holding their version
+const versionField = "version"
+
+// ErrUnsupportedVersion is returned when a document is newer than its Schema,
+// i.e. it has been saved by a newer version of the program
+var ErrUnsupportedVersion = errors.New("unsupported version")
+
+// Document is a JSON document being migrated, each field is left encoded
+type Document map[string]json.RawMessage
+
+// Migration upgrades a Document to the next version, editing it in place
+type Migration func(doc Document) error
+
+// Unchanged is the Migration to a version that only adds fields whose zero value is the default
+func Unchanged(Document) error {
+	return nil
+}
+
+// Schema is the version history of a kind of document
+type Schema struct {
+	name       string
+	version    int
+	migrations map[int]Migration // by the version they upgrade from
+}
+
+// New returns the Schema of the documents called name, at the current version
+func New(name string, version int) *Schema {
+	return &Schema{
+		name:       name,
+		version:    version,
+		migrations: make(map[int]Migration),
+	}
+}
+
+// Register registers the migration of the documents from version from to from+1,
+// it panics if the migration is already registered or if from is not an old version
+func (s *Schema) Register(from int, m Migration) *Schema {
+	if from < 0 || from >= s.version {
+		panic(fmt.Sprintf("%s schema: migration from version %d, the current is %d", s.name, from, s.version))
+	}
+	if _, ok := s.migrations[from]; ok {
+		panic(fmt.Sprintf("%s schema: migration from version %d already registered", s.name, from))
+	}
+
+	s.migrations[from] = m
+	return s
+}
+
+// Version returns the current version of the documents
+func (s *Schema) Version() int {
+	return s.version
+}
+
+// Migrate upgrades the JSON document in bytes to the current version,
+// documents already at the current version are returned as they are
+func (s *Schema) Migrate(bytes []byte) ([]byte, error) {
+	versionJSON := struct {
+		Version int `json:"version"`
+	}{}
+
+	if err := json.Unmarshal(bytes, &versionJSON); err != nil {
+		return nil, fmt.Errorf("unmarshalling %s version: %w", s.name, err)
+	}
+
+	version := versionJSON.Version
+	if version == s.version {
+		return bytes, nil
+	}
+	if version > s.version || version < 0 {
+		return nil, fmt.Errorf("%s document version %d: %w, the latest supported is %d",
+			s.name, version, ErrUnsupportedVersion, s.version)
+	}
+
+	doc := Document{}
+	if err := json.Unmarshal(bytes, &doc); err != nil {
+		return nil, fmt.Errorf("unmarshalling %s document: %w", s.name, err)
+	}
+
+	for ; version < s.version; version++ {
+		m, ok := s.migrations[version]
+		if !ok {
+			return nil, fmt.Errorf("%s document version %d: no migration to version %d", s.name, version, version+1)
+		}
+
+		if err := m(doc); err != nil {
+			return nil, fmt.Errorf("migrating %s document to version %d: %w", s.name, version+1, err)
+		}
+
+		doc[versionField] = json.RawMessage(fmt.Sprint(version + 1))
+	}
+
+	return json.Marshal(doc)
+}
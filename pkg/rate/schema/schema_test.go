@@ -0,0 +1,53 @@
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestSchema_Migrate(t *testing.T) {
+	s := New("test", 2).
+		Register(0, func(doc Document) error {
+			doc["a"] = json.RawMessage(`1`)
+			return nil
+		}).
+		Register(1, func(doc Document) error {
+			doc["b"] = doc["a"]
+			delete(doc, "a")
+			return nil
+		})
+
+	tests := map[string]struct {
+		input   string
+		want    string
+		wantErr error
+	}{
+		"no version":      {input: `{"c":3}`, want: `{"b":1,"c":3,"version":2}`},
+		"old version":     {input: `{"a":2,"version":1}`, want: `{"b":2,"version":2}`},
+		"current version": {input: `{"b":2,"version":2}`, want: `{"b":2,"version":2}`},
+		"newer version":   {input: `{"version":3}`, wantErr: ErrUnsupportedVersion},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := s.Migrate([]byte(tt.input))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Migrate() error = %v, want %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Migrate() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSchema_MissingMigration(t *testing.T) {
+	s := New("test", 2).Register(1, Unchanged)
+
+	if _, err := s.Migrate([]byte(`{}`)); err == nil {
+		t.Errorf("Migrate() without the migration from version 0 no error")
+	}
+	if _, err := s.Migrate([]byte(`[]`)); err == nil {
+		t.Errorf("Migrate() of a non object no error")
+	}
+}
@@ -0,0 +1,125 @@
+// Package statefile reads and writes state files in a crash safe way
+//
+// a state is written in a temporary file, synced and then renamed over the
+// previous one, so that a crash leaves either the old or the new state on
+// disk, never a truncated one. The previous state is kept aside, see
+// PreviousPath, and each file carries a checksum of its content.
+package statefile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	previousSuffix = ".prev"
+	tempSuffix     = ".tmp"
+)
+
+// header starts every file written by Write, it is followed by the
+// CRC-32 of the content and by the content itself
+var header = []byte{0x89, 'C', 'R', 'C'}
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrChecksum is returned when the content of a file does not match its checksum
+var ErrChecksum = errors.New("checksum mismatch")
+
+// PreviousPath returns the path where Write keeps the state previous to the one in filePath
+func PreviousPath(filePath string) string {
+	return filePath + previousSuffix
+}
+
+// Write atomically replaces the content of filePath with data,
+// moving the current content to PreviousPath
+func Write(filePath string, data []byte) (err error) {
+	tempPath := filePath + tempSuffix
+
+	f, err := os.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("creating file %s: %w", tempPath, err)
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tempPath)
+		}
+	}()
+
+	checksum := make([]byte, 4)
+	binary.BigEndian.PutUint32(checksum, crc32.Checksum(data, crcTable))
+
+	for _, b := range [][]byte{header, checksum, data} {
+		if _, err = f.Write(b); err != nil {
+			_ = f.Close()
+			return fmt.Errorf("writing in file %s: %w", tempPath, err)
+		}
+	}
+
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("syncing file %s: %w", tempPath, err)
+	}
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("closing file %s: %w", tempPath, err)
+	}
+
+	if _, serr := os.Stat(filePath); serr == nil {
+		if err = os.Rename(filePath, PreviousPath(filePath)); err != nil {
+			return fmt.Errorf("keeping previous state: %w", err)
+		}
+	}
+
+	if err = os.Rename(tempPath, filePath); err != nil {
+		return fmt.Errorf("renaming %s: %w", tempPath, err)
+	}
+
+	return syncDir(filepath.Dir(filePath))
+}
+
+// syncDir makes the renames in dir durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("opening dir %s: %w", dir, err)
+	}
+	defer d.Close()
+
+	// some platforms do not support syncing a directory, the
+	// renames are still atomic, only their durability is at stake
+	_ = d.Sync()
+
+	return nil
+}
+
+// Read returns the content of filePath, checking its checksum
+//
+// files without checksum, written before this package was used,
+// are returned as they are
+func Read(filePath string) ([]byte, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("reading file %s: %w", filePath, err)
+	}
+
+	if !bytes.HasPrefix(data, header) {
+		return data, nil
+	}
+
+	data = data[len(header):]
+	if len(data) < 4 {
+		return nil, fmt.Errorf("file %s: %w", filePath, ErrChecksum)
+	}
+
+	checksum, data := binary.BigEndian.Uint32(data), data[4:]
+	if crc32.Checksum(data, crcTable) != checksum {
+		return nil, fmt.Errorf("file %s: %w", filePath, ErrChecksum)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,81 @@
+package statefile
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteRead(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "state")
+
+	if err := Write(filePath, []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(PreviousPath(filePath)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("previous state after the first write: %v", err)
+	}
+
+	if err := Write(filePath, []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, want := range map[string]string{filePath: "second", PreviousPath(filePath): "first"} {
+		got, err := Read(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("Read(%s) = %q, want %q", path, got, want)
+		}
+	}
+
+	if _, err := os.Stat(filePath + tempSuffix); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("temporary file left: %v", err)
+	}
+}
+
+func TestRead(t *testing.T) {
+	dir := t.TempDir()
+
+	valid := filepath.Join(dir, "valid")
+	if err := Write(valid, []byte(`{"counter":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	corrupt := append([]byte{}, content...)
+	corrupt[len(corrupt)-2]++
+
+	tests := map[string]struct {
+		content []byte
+		want    string
+		wantErr error
+	}{
+		"valid":       {content: content, want: `{"counter":1}`},
+		"no checksum": {content: []byte(`{"counter":2}`), want: `{"counter":2}`},
+		"corrupt":     {content: corrupt, wantErr: ErrChecksum},
+		"truncated":   {content: content[:len(header)+2], wantErr: ErrChecksum},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			filePath := filepath.Join(dir, name)
+			if err := ioutil.WriteFile(filePath, tt.content, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := Read(filePath)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Read() error = %v, want %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Read() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
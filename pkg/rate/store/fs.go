@@ -0,0 +1,116 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/statefile"
+)
+
+// auxiliarySuffixes are the suffixes of the files kept next to the states,
+// e.g. by statefile and by the journals, which are not keys
+var auxiliarySuffixes = []string{".prev", ".tmp", ".journal", ".corrupt"}
+
+// FS keeps each state in a file of a directory, named as its key
+//
+// files are written with statefile, so that each save is atomic and
+// keeps the previous state aside
+type FS struct {
+	dir string
+}
+
+var (
+	_ Store   = (*FS)(nil)
+	_ Lister  = (*FS)(nil)
+	_ Deleter = (*FS)(nil)
+	_ Locator = (*FS)(nil)
+)
+
+// NewFS returns the Store of the states in dir, which is created at the first save
+func NewFS(dir string) *FS {
+	return &FS{dir: dir}
+}
+
+// Path returns the path of the file of key, see Locator
+func (fs *FS) Path(key string) string {
+	return filepath.Join(fs.dir, key)
+}
+
+// Load returns the state in the file of key, checking its checksum
+func (fs *FS) Load(key string) ([]byte, error) {
+	bytes, err := statefile.Read(fs.Path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("key %s: %w", key, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes, nil
+}
+
+// Save atomically replaces the file of key, see statefile.Write
+func (fs *FS) Save(key string, data []byte) error {
+	if err := os.MkdirAll(fs.dir, os.ModePerm); err != nil {
+		return fmt.Errorf("creating dir %s: %v", fs.dir, err)
+	}
+
+	return statefile.Write(fs.Path(key), data)
+}
+
+// List returns the keys starting with prefix, the files kept next to the states are ignored
+func (fs *FS) List(prefix string) ([]string, error) {
+	infos, err := ioutil.ReadDir(fs.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading dir %s: %v", fs.dir, err)
+	}
+
+	var keys []string
+	for _, info := range infos {
+		name := info.Name()
+		if !info.Mode().IsRegular() || !strings.HasPrefix(name, prefix) || isAuxiliary(name) {
+			continue
+		}
+		keys = append(keys, name)
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+func isAuxiliary(name string) bool {
+	for _, suffix := range auxiliarySuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Delete removes the file of key and the previous state kept aside
+func (fs *FS) Delete(key string) error {
+	for _, path := range []string{fs.Path(key), statefile.PreviousPath(fs.Path(key))} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("removing file %s: %v", path, err)
+		}
+	}
+
+	return nil
+}
+
+// Rename moves the file of the key from to the key to, replacing it
+func (fs *FS) Rename(from, to string) error {
+	if err := os.Rename(fs.Path(from), fs.Path(to)); err != nil {
+		return fmt.Errorf("renaming %s: %v", from, err)
+	}
+
+	return nil
+}
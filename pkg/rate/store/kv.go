@@ -0,0 +1,382 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	kvVersion    = 1
+	kvTempSuffix = ".tmp"
+
+	// the file is compacted when the records of overwritten and deleted
+	// states are more than the live ones, and more than minCompactSize
+	minCompactSize = 64 << 10
+)
+
+// kvMagic starts every KV file, it is followed by the version
+var kvMagic = []byte{0x89, 'K', 'V', 'S'}
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// record operations
+const (
+	opSave   byte = 1
+	opDelete byte = 2
+)
+
+// KV is an embedded key-value store keeping all the states in a single file
+//
+// the file is an append only log of records, each one saving or deleting a
+// key, with its own checksum. Only the position of the states is kept in
+// memory. On open the log is read to rebuild the positions, a record torn by
+// a crash is dropped. The log is compacted when it is mostly made of
+// overwritten states, by rewriting the live ones in a new file.
+type KV struct {
+	m        sync.Mutex
+	filePath string
+	f        *os.File
+	size     int64              // size of the file
+	index    map[string]kvEntry // position of the live states
+	live     int64              // size of the records of the live states
+}
+
+// kvEntry is the position of a state in the file
+type kvEntry struct {
+	offset int64 // of the value
+	length int64 // of the value
+	record int64 // size of the whole record
+}
+
+var (
+	_ Store   = (*KV)(nil)
+	_ Lister  = (*KV)(nil)
+	_ Deleter = (*KV)(nil)
+)
+
+// OpenKV opens the KV in filePath, creating it if it does not exist
+func OpenKV(filePath string) (*KV, error) {
+	if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("creating dir of %s: %v", filePath, err)
+	}
+
+	f, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("opening file %s: %v", filePath, err)
+	}
+
+	kv := &KV{
+		filePath: filePath,
+		f:        f,
+		index:    make(map[string]kvEntry),
+	}
+
+	if err := kv.load(); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return kv, nil
+}
+
+// load rebuilds the index from the file, truncating it after the last valid record
+func (kv *KV) load() error {
+	data, err := ioutil.ReadAll(kv.f)
+	if err != nil {
+		return fmt.Errorf("reading file %s: %v", kv.filePath, err)
+	}
+
+	if len(data) == 0 {
+		// a new file
+		header := append(append([]byte{}, kvMagic...), kvVersion)
+		if _, err := kv.f.Write(header); err != nil {
+			return fmt.Errorf("writing in file %s: %v", kv.filePath, err)
+		}
+		if err := kv.f.Sync(); err != nil {
+			return fmt.Errorf("syncing file %s: %v", kv.filePath, err)
+		}
+		kv.size = int64(len(header))
+		return nil
+	}
+
+	if !bytes.HasPrefix(data, kvMagic) || len(data) == len(kvMagic) {
+		return fmt.Errorf("file %s: missing KV header", kv.filePath)
+	}
+	if v := data[len(kvMagic)]; v > kvVersion {
+		return fmt.Errorf("file %s: unsupported KV version %d, the latest supported is %d", kv.filePath, v, kvVersion)
+	}
+
+	offset := int64(len(kvMagic) + 1)
+	for {
+		op, key, value, n := decodeKVRecord(data[offset:])
+		if n <= 0 {
+			break
+		}
+
+		kv.drop(key)
+		if op == opSave {
+			kv.index[key] = kvEntry{
+				offset: offset + int64(n-len(value)-4),
+				length: int64(len(value)),
+				record: int64(n),
+			}
+			kv.live += int64(n)
+		}
+		offset += int64(n)
+	}
+
+	// drop a record torn by a crash, so that the next ones are readable
+	if offset < int64(len(data)) {
+		if err := kv.f.Truncate(offset); err != nil {
+			return fmt.Errorf("truncating file %s: %v", kv.filePath, err)
+		}
+	}
+	kv.size = offset
+
+	return nil
+}
+
+// drop removes key from the index. Must be called with the lock held.
+func (kv *KV) drop(key string) {
+	if e, ok := kv.index[key]; ok {
+		kv.live -= e.record
+		delete(kv.index, key)
+	}
+}
+
+// Load returns the state of key
+func (kv *KV) Load(key string) ([]byte, error) {
+	kv.m.Lock()
+	defer kv.m.Unlock()
+
+	e, ok := kv.index[key]
+	if !ok {
+		return nil, fmt.Errorf("key %s: %w", key, ErrNotFound)
+	}
+
+	data := make([]byte, e.length)
+	if _, err := kv.f.ReadAt(data, e.offset); err != nil {
+		return nil, fmt.Errorf("reading key %s: %v", key, err)
+	}
+
+	return data, nil
+}
+
+// Save appends and syncs a record saving data as the state of key
+func (kv *KV) Save(key string, data []byte) error {
+	kv.m.Lock()
+	defer kv.m.Unlock()
+
+	record := appendKVRecord(nil, opSave, key, data)
+	if err := kv.append(record); err != nil {
+		return err
+	}
+
+	kv.drop(key)
+	kv.index[key] = kvEntry{
+		offset: kv.size - int64(len(data)+4),
+		length: int64(len(data)),
+		record: int64(len(record)),
+	}
+	kv.live += int64(len(record))
+
+	return kv.compact()
+}
+
+// Delete appends and syncs a record deleting the state of key
+func (kv *KV) Delete(key string) error {
+	kv.m.Lock()
+	defer kv.m.Unlock()
+
+	if _, ok := kv.index[key]; !ok {
+		return nil
+	}
+
+	if err := kv.append(appendKVRecord(nil, opDelete, key, nil)); err != nil {
+		return err
+	}
+	kv.drop(key)
+
+	return kv.compact()
+}
+
+// List returns the keys starting with prefix, sorted
+func (kv *KV) List(prefix string) ([]string, error) {
+	kv.m.Lock()
+	defer kv.m.Unlock()
+
+	var keys []string
+	for key := range kv.index {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// Close closes the file of the KV
+func (kv *KV) Close() error {
+	kv.m.Lock()
+	defer kv.m.Unlock()
+
+	if err := kv.f.Close(); err != nil {
+		return fmt.Errorf("closing file %s: %v", kv.filePath, err)
+	}
+
+	return nil
+}
+
+// append writes and syncs a record at the end of the file. Must be called with the lock held.
+func (kv *KV) append(record []byte) error {
+	if _, err := kv.f.WriteAt(record, kv.size); err != nil {
+		return fmt.Errorf("writing in file %s: %w", kv.filePath, err)
+	}
+	if err := kv.f.Sync(); err != nil {
+		return fmt.Errorf("syncing file %s: %w", kv.filePath, err)
+	}
+
+	kv.size += int64(len(record))
+	return nil
+}
+
+// compact rewrites the live states in a new file, if the overwritten and
+// deleted ones take more space than them. Must be called with the lock held.
+func (kv *KV) compact() (err error) {
+	header := int64(len(kvMagic) + 1)
+	dead := kv.size - header - kv.live
+	if dead < minCompactSize || dead < kv.live {
+		return nil
+	}
+
+	tempPath := kv.filePath + kvTempSuffix
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tempPath)
+		}
+	}()
+
+	keys := make([]string, 0, len(kv.index))
+	for key := range kv.index {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	buf := append(append([]byte{}, kvMagic...), kvVersion)
+	index := make(map[string]kvEntry, len(kv.index))
+	for _, key := range keys {
+		e := kv.index[key]
+
+		value := make([]byte, e.length)
+		if _, err := kv.f.ReadAt(value, e.offset); err != nil {
+			return fmt.Errorf("reading key %s: %v", key, err)
+		}
+
+		buf = appendKVRecord(buf, opSave, key, value)
+		index[key] = kvEntry{
+			offset: int64(len(buf) - len(value) - 4),
+			length: e.length,
+			record: e.record,
+		}
+	}
+
+	if err = ioutil.WriteFile(tempPath, buf, 0644); err != nil {
+		return fmt.Errorf("writing file %s: %v", tempPath, err)
+	}
+
+	f, err := os.OpenFile(tempPath, os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("opening file %s: %v", tempPath, err)
+	}
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("syncing file %s: %v", tempPath, err)
+	}
+
+	// the renamed file keeps being the one open
+	if err = os.Rename(tempPath, kv.filePath); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("renaming %s: %v", tempPath, err)
+	}
+
+	_ = kv.f.Close()
+	kv.f = f
+	kv.size = int64(len(buf))
+	kv.index = index
+
+	if d, err := os.Open(filepath.Dir(kv.filePath)); err == nil {
+		_ = d.Sync()
+		_ = d.Close()
+	}
+
+	return nil
+}
+
+// appendKVRecord appends to buf the record of op on key, made of the op, the key,
+// the value, each one preceded by its length, and the checksum of all of them
+func appendKVRecord(buf []byte, op byte, key string, value []byte) []byte {
+	start := len(buf)
+
+	buf = append(buf, op)
+	buf = appendUvarint(buf, uint64(len(key)))
+	buf = append(buf, key...)
+	buf = appendUvarint(buf, uint64(len(value)))
+	buf = append(buf, value...)
+
+	checksum := make([]byte, 4)
+	binary.BigEndian.PutUint32(checksum, crc32.Checksum(buf[start:], crcTable))
+
+	return append(buf, checksum...)
+}
+
+// decodeKVRecord returns the record at the start of b and its length,
+// or a non positive length if there is no valid record
+func decodeKVRecord(b []byte) (op byte, key string, value []byte, n int) {
+	if len(b) == 0 {
+		return 0, "", nil, 0
+	}
+
+	op = b[0]
+	if op != opSave && op != opDelete {
+		return 0, "", nil, 0
+	}
+	i := 1
+
+	// the lengths are compared with what is left, a sum could overflow
+	keyLen, k := binary.Uvarint(b[i:])
+	if k <= 0 || keyLen > uint64(len(b)-i-k) {
+		return 0, "", nil, 0
+	}
+	i += k
+	key = string(b[i : i+int(keyLen)])
+	i += int(keyLen)
+
+	valueLen, k := binary.Uvarint(b[i:])
+	if k <= 0 || len(b)-i-k < 4 || valueLen > uint64(len(b)-i-k-4) {
+		return 0, "", nil, 0
+	}
+	i += k
+	value = b[i : i+int(valueLen)]
+	i += int(valueLen)
+
+	if crc32.Checksum(b[:i], crcTable) != binary.BigEndian.Uint32(b[i:]) {
+		return 0, "", nil, 0
+	}
+
+	return op, key, value, i + 4
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
@@ -0,0 +1,72 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Memory keeps the states in memory, it is meant for tests
+type Memory struct {
+	m      sync.Mutex
+	states map[string][]byte
+}
+
+var (
+	_ Store   = (*Memory)(nil)
+	_ Lister  = (*Memory)(nil)
+	_ Deleter = (*Memory)(nil)
+)
+
+// NewMemory returns an empty Memory
+func NewMemory() *Memory {
+	return &Memory{states: make(map[string][]byte)}
+}
+
+// Load returns a copy of the state of key
+func (s *Memory) Load(key string) ([]byte, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	data, ok := s.states[key]
+	if !ok {
+		return nil, fmt.Errorf("key %s: %w", key, ErrNotFound)
+	}
+
+	return append([]byte{}, data...), nil
+}
+
+// Save keeps a copy of data as the state of key
+func (s *Memory) Save(key string, data []byte) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.states[key] = append([]byte{}, data...)
+	return nil
+}
+
+// List returns the keys starting with prefix, sorted
+func (s *Memory) List(prefix string) ([]string, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	var keys []string
+	for key := range s.states {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// Delete deletes the state of key
+func (s *Memory) Delete(key string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	delete(s.states, key)
+	return nil
+}
@@ -0,0 +1,42 @@
+// Package store implements the storage of the persisted states
+//
+// a Store keeps each state under a key, e.g. the counter and the limiter of
+// a server are two keys of the same Store. Implementations are FS, a
+// directory with a file per key, Memory, meant for tests, and KV, an
+// embedded key-value store keeping all the keys in a single file.
+package store
+
+import (
+	"errors"
+)
+
+// ErrNotFound is returned by Load when there is no state saved under the key
+var ErrNotFound = errors.New("state not found")
+
+// Store loads and saves states by key, it must be safe for concurrent use
+type Store interface {
+	// Load returns the state saved under key, or an error wrapping ErrNotFound
+	Load(key string) ([]byte, error)
+	// Save replaces the state saved under key with data, the state is
+	// either entirely replaced or left untouched
+	Save(key string, data []byte) error
+}
+
+// Lister is implemented by the stores able to list their keys
+type Lister interface {
+	// List returns the keys starting with prefix, sorted
+	List(prefix string) ([]string, error)
+}
+
+// Deleter is implemented by the stores able to delete a key
+type Deleter interface {
+	// Delete deletes the state saved under key, deleting a missing key is not an error
+	Delete(key string) error
+}
+
+// Locator is implemented by the stores keeping each state in its own file,
+// the files next to it are available for the state, e.g. for its journal
+type Locator interface {
+	// Path returns the path of the file of the state saved under key
+	Path(key string) string
+}
@@ -0,0 +1,219 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	stores := map[string]func(t *testing.T) Store{
+		"fs": func(t *testing.T) Store {
+			return NewFS(filepath.Join(t.TempDir(), "states"))
+		},
+		"memory": func(t *testing.T) Store {
+			return NewMemory()
+		},
+		"kv": func(t *testing.T) Store {
+			kv, err := OpenKV(filepath.Join(t.TempDir(), "states.kv"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { _ = kv.Close() })
+			return kv
+		},
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(t)
+
+			if _, err := s.Load("counter"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Load() of a missing key error = %v, want %v", err, ErrNotFound)
+			}
+
+			for _, data := range []string{"first", "second"} {
+				if err := s.Save("counter", []byte(data)); err != nil {
+					t.Fatal(err)
+				}
+
+				got, err := s.Load("counter")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != data {
+					t.Errorf("Load() = %q, want %q", got, data)
+				}
+			}
+
+			if err := s.Save("limiter", []byte("limiter")); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Save("latency", []byte("latency")); err != nil {
+				t.Fatal(err)
+			}
+
+			keys, err := s.(Lister).List("l")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []string{"latency", "limiter"}; !reflect.DeepEqual(keys, want) {
+				t.Errorf("List() = %v, want %v", keys, want)
+			}
+
+			for i := 0; i < 2; i++ {
+				if err := s.(Deleter).Delete("limiter"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := s.Load("limiter"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Load() of a deleted key error = %v, want %v", err, ErrNotFound)
+			}
+		})
+	}
+}
+
+func TestKV(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "states.kv")
+
+	kv, err := OpenKV(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// enough overwrites to compact the file
+	big := make([]byte, 16<<10)
+	for i := 0; i < 20; i++ {
+		big[0] = byte(i)
+		if err := kv.Save("big", big); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := kv.Save("a", []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.Save("b", []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > 2*int64(len(big))+minCompactSize {
+		t.Errorf("file size %d, not compacted", info.Size())
+	}
+
+	// a crash while appending a record
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	torn := appendKVRecord(nil, opSave, "a", []byte("torn"))
+	content = append(content, torn[:len(torn)-2]...)
+	if err := ioutil.WriteFile(filePath, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	kv, err = OpenKV(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kv.Close()
+
+	if got, err := kv.Load("big"); err != nil || got[0] != 19 || len(got) != len(big) {
+		t.Errorf("Load(big) after reopening = %d bytes starting with %v, %v, want the last save", len(got), got[:1], err)
+	}
+	if got, err := kv.Load("a"); err != nil || string(got) != "a" {
+		t.Errorf("Load(a) after a torn record = %q, %v, want %q", got, err, "a")
+	}
+	if _, err := kv.Load("b"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Load(b) after reopening error = %v, want %v", err, ErrNotFound)
+	}
+
+	// the torn record is dropped, the next ones are readable
+	if err := kv.Save("c", []byte("c")); err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if kv, err = OpenKV(filePath); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := kv.Load("c"); err != nil || string(got) != "c" {
+		t.Errorf("Load(c) = %q, %v, want %q", got, err, "c")
+	}
+	if err := kv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filePath, []byte("not a kv"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenKV(filePath); err == nil {
+		t.Errorf("OpenKV() of an invalid file no error")
+	}
+}
+
+func TestDecodeKVRecord_Corrupt(t *testing.T) {
+	valid := appendKVRecord(nil, opSave, "key", []byte("value"))
+
+	tests := map[string][]byte{
+		// the length plus the checksum overflows
+		"huge value length": append(appendUvarint(append([]byte{opSave}, 1, 'k'), 1<<64-4), 1, 2, 3, 4, 5, 6),
+		"max value length":  append(appendUvarint(append([]byte{opSave}, 1, 'k'), 1<<64-1), 1, 2, 3, 4, 5, 6),
+		"huge key length":   append(appendUvarint([]byte{opSave}, 1<<64-1), 1, 2, 3, 4, 5, 6),
+		"wrong checksum":    append(valid[:len(valid)-1:len(valid)-1], valid[len(valid)-1]+1),
+	}
+	for i := 0; i < len(valid); i++ {
+		tests[fmt.Sprintf("truncated at %d", i)] = valid[:i]
+	}
+
+	for name, b := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, _, _, n := decodeKVRecord(b); n > 0 {
+				t.Errorf("decodeKVRecord() length %d, want no record", n)
+			}
+		})
+	}
+
+	// a corrupt record ends the records read by OpenKV
+	filePath := filepath.Join(t.TempDir(), "states.kv")
+	kv, err := OpenKV(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.Save("a", []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filePath, append(content, tests["huge value length"]...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if kv, err = OpenKV(filePath); err != nil {
+		t.Fatal(err)
+	}
+	defer kv.Close()
+	if got, err := kv.Load("a"); err != nil || string(got) != "a" {
+		t.Errorf("Load(a) = %q, %v, want %q", got, err, "a")
+	}
+}
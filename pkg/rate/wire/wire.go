@@ -0,0 +1,221 @@
+// Package wire implements the compact binary format of the persisted states
+//
+// a state is made of a header, the magic bytes followed by the format version,
+// and of a body of varint packed values written by an Encoder and read back,
+// in the same order, by a Decoder
+package wire
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Version is the version of the binary format written by the Encoder
+//
+// version 2 appends the journal seq to the counters,
+// version 3 prefixes the limiters with their algorithm
+const Version = 3
+
+// magic starts every binary state, it can not be the start of a JSON document
+var magic = []byte{0x89, 'R', 'T', 'E'}
+
+var (
+	// ErrCorrupt is returned when a state can not be decoded, e.g. it is truncated
+	ErrCorrupt = errors.New("corrupt binary state")
+	// ErrUnsupportedVersion is returned when a state is newer than the binary format
+	// known by the Decoder, it must not be mistaken for a corrupt one
+	ErrUnsupportedVersion = errors.New("unsupported binary format version")
+
+	errShort = fmt.Errorf("unexpected end of input: %w", ErrCorrupt)
+)
+
+// IsBinary returns true if b starts with the binary format header
+func IsBinary(b []byte) bool {
+	return bytes.HasPrefix(b, magic)
+}
+
+// Encoder appends values to a binary state
+type Encoder struct {
+	buf []byte
+}
+
+// NewEncoder returns an Encoder with the header already written
+func NewEncoder() *Encoder {
+	e := &Encoder{}
+	e.buf = append(e.buf, magic...)
+	e.buf = append(e.buf, Version)
+	return e
+}
+
+// Bytes returns the encoded state
+func (e *Encoder) Bytes() []byte {
+	return e.buf
+}
+
+func (e *Encoder) Uvarint(v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	e.buf = append(e.buf, tmp[:n]...)
+}
+
+func (e *Encoder) Varint(v int64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(tmp[:], v)
+	e.buf = append(e.buf, tmp[:n]...)
+}
+
+func (e *Encoder) Duration(d time.Duration) {
+	e.Varint(int64(d))
+}
+
+// Time writes t with nanosecond precision, the location is not kept
+func (e *Encoder) Time(t time.Time) {
+	e.Varint(t.Unix())
+	e.Uvarint(uint64(t.Nanosecond()))
+}
+
+// Blob writes b prefixed by its length
+func (e *Encoder) Blob(b []byte) {
+	e.Uvarint(uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *Encoder) String(s string) {
+	e.Blob([]byte(s))
+}
+
+// Varints writes vs prefixed by its length
+func (e *Encoder) Varints(vs []int64) {
+	e.Uvarint(uint64(len(vs)))
+	for _, v := range vs {
+		e.Varint(v)
+	}
+}
+
+// Decoder reads the values of a binary state
+//
+// the first error is kept and returned by Err, after it
+// all the reads return zero values
+type Decoder struct {
+	buf     []byte
+	version byte
+	err     error
+}
+
+// NewDecoder checks the header of b and returns a Decoder of its body
+func NewDecoder(b []byte) (*Decoder, error) {
+	if !IsBinary(b) {
+		return nil, fmt.Errorf("missing binary format header: %w", ErrCorrupt)
+	}
+	b = b[len(magic):]
+
+	if len(b) == 0 {
+		return nil, errShort
+	}
+	if version := b[0]; version > Version {
+		return nil, fmt.Errorf("%w %d, the latest supported is %d", ErrUnsupportedVersion, version, Version)
+	}
+
+	return &Decoder{buf: b[1:], version: b[0]}, nil
+}
+
+// Version returns the format version of the decoded state
+func (d *Decoder) Version() int {
+	return int(d.version)
+}
+
+// Err returns the first error encountered
+func (d *Decoder) Err() error {
+	return d.err
+}
+
+func (d *Decoder) Uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = errShort
+		return 0
+	}
+	d.buf = d.buf[n:]
+
+	return v
+}
+
+func (d *Decoder) Varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = errShort
+		return 0
+	}
+	d.buf = d.buf[n:]
+
+	return v
+}
+
+func (d *Decoder) Duration() time.Duration {
+	return time.Duration(d.Varint())
+}
+
+func (d *Decoder) Time() time.Time {
+	sec := d.Varint()
+	nsec := d.Uvarint()
+	if d.err != nil {
+		return time.Time{}
+	}
+
+	return time.Unix(sec, int64(nsec))
+}
+
+func (d *Decoder) Blob() []byte {
+	n := d.Uvarint()
+	if d.err != nil {
+		return nil
+	}
+
+	if uint64(len(d.buf)) < n {
+		d.err = errShort
+		return nil
+	}
+
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+
+	return b
+}
+
+func (d *Decoder) String() string {
+	return string(d.Blob())
+}
+
+func (d *Decoder) Varints() []int64 {
+	n := d.Uvarint()
+	if d.err != nil {
+		return nil
+	}
+
+	// each varint takes at least one byte
+	if uint64(len(d.buf)) < n {
+		d.err = errShort
+		return nil
+	}
+
+	vs := make([]int64, n)
+	for i := range vs {
+		vs[i] = d.Varint()
+	}
+	if d.err != nil {
+		return nil
+	}
+
+	return vs
+}
@@ -0,0 +1,78 @@
+package wire
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEncoderDecoder(t *testing.T) {
+	at := time.Unix(1600000000, 123456789)
+
+	e := NewEncoder()
+	e.Uvarint(300)
+	e.Varint(-42)
+	e.Duration(time.Minute)
+	e.Time(at)
+	e.Time(time.Time{})
+	e.String("key")
+	e.Varints([]int64{0, 1, -1, 1 << 40})
+
+	if !IsBinary(e.Bytes()) {
+		t.Fatalf("IsBinary() = false")
+	}
+
+	d, err := NewDecoder(e.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := d.Uvarint(); got != 300 {
+		t.Errorf("Uvarint() = %d, want 300", got)
+	}
+	if got := d.Varint(); got != -42 {
+		t.Errorf("Varint() = %d, want -42", got)
+	}
+	if got := d.Duration(); got != time.Minute {
+		t.Errorf("Duration() = %v, want 1m", got)
+	}
+	if got := d.Time(); !got.Equal(at) {
+		t.Errorf("Time() = %v, want %v", got, at)
+	}
+	if got := d.Time(); !got.IsZero() {
+		t.Errorf("Time() = %v, want zero time", got)
+	}
+	if got := d.String(); got != "key" {
+		t.Errorf("String() = %q, want key", got)
+	}
+	if got, want := d.Varints(), []int64{0, 1, -1, 1 << 40}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Varints() = %v, want %v", got, want)
+	}
+	if err := d.Err(); err != nil {
+		t.Errorf("Err() = %v", err)
+	}
+
+	// reading past the end
+	if got := d.Varint(); got != 0 || d.Err() == nil {
+		t.Errorf("Varint() = %d, Err() = %v, want 0 and an error", got, d.Err())
+	}
+}
+
+func TestNewDecoder(t *testing.T) {
+	tests := map[string]struct {
+		input   []byte
+		wantErr bool
+	}{
+		"current version": {input: NewEncoder().Bytes()},
+		"json":            {input: []byte(`{"counter":1}`), wantErr: true},
+		"no version":      {input: magic, wantErr: true},
+		"next version":    {input: append(append([]byte{}, magic...), Version+1), wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := NewDecoder(tt.input); (err != nil) != tt.wantErr {
+				t.Errorf("NewDecoder() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -2,14 +2,29 @@ package server
 
 import (
 	"log"
+	"time"
+
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/clock"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/counter"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/journal"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/limiter"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/resp"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/store"
 )
 
 type Option func(s *Server)
 
-// WithPersistence set the path of where the server save its persistence files
+// WithPersistence set the path of where the server save its persistence files,
+// it is equal to WithStore with a store.FS
 func WithPersistence(path string) Option {
-	return func(s *Server) {
-		s.persistencePath = path
+	return WithStore(store.NewFS(path))
+}
+
+// WithStore set the Store where the server saves the states of its counters and
+// limiter, by default a store.FS in the persistence directory
+func WithStore(s store.Store) Option {
+	return func(srv *Server) {
+		srv.store = s
 	}
 }
 
@@ -20,8 +35,132 @@ func WithPerIPRequestLimiter(limit int64) Option {
 	}
 }
 
+// WithRedisLimiter set the per IP limiter to keep its windows in the Redis compatible
+// server of client, so that the instances using the same server share the limit of
+// each IP. By default the windows are kept in memory, and persisted in the store.
+func WithRedisLimiter(client *resp.Client) Option {
+	return func(s *Server) {
+		s.redis = client
+	}
+}
+
+// WithRedisResolution set the number of buckets the window of each IP is split in on
+// the server of WithRedisLimiter, by default the one of limiter.NewRedis, see
+// limiter.WithRedisResolution. Start returns an error if a bucket is shorter than 1ms.
+func WithRedisResolution(resolution uint64) Option {
+	return func(s *Server) {
+		s.redisResolution = resolution
+	}
+}
+
+// WithRouteCost set the units of the per IP limit consumed by each request to path,
+// by default a request costs 1. New returns an error if cost is not positive.
+func WithRouteCost(path string, cost int64) Option {
+	return func(s *Server) {
+		if s.routeCosts == nil {
+			s.routeCosts = make(map[string]int64)
+		}
+		s.routeCosts[path] = cost
+	}
+}
+
+// WithWindows set the windows reported in the response in addition to
+// the counter, each window must be a multiple of 100ms. If no window
+// is passed the response reports only the counter.
+func WithWindows(windows ...time.Duration) Option {
+	return func(s *Server) {
+		s.windows = windows
+	}
+}
+
+// WithCounterKind set the kind of counter used to count the requests,
+// by default counter.KindRing. A counter restored from the persistence
+// keeps the kind it was saved with.
+func WithCounterKind(kind counter.Kind) Option {
+	return func(s *Server) {
+		s.counterKind = kind
+	}
+}
+
+// WithCounterWindow set the window of the requests counter and its number of ticks,
+// by default 60s and 1000. A counter restored from the persistence is resampled to them.
+func WithCounterWindow(window time.Duration, resolution uint64) Option {
+	return func(s *Server) {
+		s.counterWindow = window
+		s.counterResolution = resolution
+	}
+}
+
+// WithLimiterCounterKind set the kind of counter used by the per IP limiters,
+// by default counter.KindRing
+func WithLimiterCounterKind(kind counter.Kind) Option {
+	return func(s *Server) {
+		s.limiterCounterKind = kind
+	}
+}
+
+// WithLimiterAlgorithm set the algorithm of the per IP limiters, by default
+// limiter.AlgorithmWindow. The limiters restored from the persistence keep
+// the algorithm they were saved with. The Redis limiter supports only the window.
+func WithLimiterAlgorithm(alg limiter.Algorithm) Option {
+	return func(s *Server) {
+		s.limiterAlgorithm = alg
+	}
+}
+
+// WithLimiterBurst set the burst of the token bucket and GCRA per IP limiters, the requests
+// allowed at once after being idle, by default equal to the limit, see limiter.WithBurst
+func WithLimiterBurst(burst int64) Option {
+	return func(s *Server) {
+		s.burst = burst
+	}
+}
+
+// WithLimiterIdleTTL set the per IP limiters not used for ttl, whose window has emptied,
+// to be evicted from memory and from the saved state, see limiter.WithIdleTTL.
+// By default they are never evicted.
+func WithLimiterIdleTTL(ttl time.Duration) Option {
+	return func(s *Server) {
+		s.limiterIdleTTL = ttl
+	}
+}
+
+// WithLimiterMaxKeys set the maximum number of IPs with a limiter, the least recently used
+// ones are evicted over it, see limiter.WithMaxKeys. By default there is no maximum.
+func WithLimiterMaxKeys(n int) Option {
+	return func(s *Server) {
+		s.limiterMaxKeys = n
+	}
+}
+
+// WithJournal set the requests counter and the per IP limiters to journal their
+// increases between the saves of their state, so that a crash loses only the
+// increases not yet synced according to policy
+func WithJournal(policy journal.Sync) Option {
+	return func(s *Server) {
+		s.journalSync = policy
+		s.isJournalEnabled = true
+	}
+}
+
+// WithErrorHandler set a function called at each background failure of the server
+// components, e.g. a failed save of a state, which is retried. The failures are
+// logged anyway, and reported by Health until the components recover.
+func WithErrorHandler(handler func(err error)) Option {
+	return func(s *Server) {
+		s.onError = handler
+	}
+}
+
 func WithLogger(logger *log.Logger) Option {
 	return func(s *Server) {
 		s.logger = logger
 	}
 }
+
+// WithClock set the Clock used by the server counters and limiters
+func WithClock(clk clock.Clock) Option {
+	return func(s *Server) {
+		s.clock = clk
+	}
+}
@@ -8,12 +8,20 @@ import (
 	"log"
 	"net"
 	"net/http"
-	"os"
-	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/clock"
 	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/counter"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/journal"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/lifecycle"
 	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/limiter"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/resp"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/statefile"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/store"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/wire"
 )
 
 const (
@@ -22,133 +30,533 @@ const (
 	defaultLimit                      = 15
 	defaultCounterResolution          = 1000
 	defaultPersistenceDir             = "persistence"
-	defaultCounterPersistenceFileName = "windowCounterState.json"
-	defaultLimiterPersistenceFileName = "limiter.json"
+	defaultCounterPersistenceFileName = "windowCounter.state"
+	defaultLimiterPersistenceFileName = "limiter.state"
+	defaultMultiPersistenceFileName   = "multiCounter.state"
+	defaultWindowsTickPeriod          = 100 * time.Millisecond
+	defaultLatencyWindowDuration      = 60 * time.Second
+	defaultLatencyResolution          = 60
+	defaultLatencyPersistenceFileName = "latencyHistogram.state"
 	defaultSavePeriod                 = time.Second
+	defaultJournalFlushPeriod         = 100 * time.Millisecond
+	defaultSeriesPath                 = "/series"
+	defaultHealthPath                 = "/health"
+	corruptSuffix                     = ".corrupt"
 )
 
+// legacyStateKeys are the keys of the states saved as JSON by the previous versions,
+// restored when there is no state under the new key, see restoreState
+var legacyStateKeys = map[string]string{
+	defaultCounterPersistenceFileName: "windowCounterState.json",
+	defaultLimiterPersistenceFileName: "limiter.json",
+	defaultMultiPersistenceFileName:   "multiCounterState.json",
+	defaultLatencyPersistenceFileName: "latencyHistogram.json",
+}
+
+// latency buckets from 10µs to about 100s, 4 per power of two
+var defaultLatencyBounds = counter.LogLinearBounds(10*time.Microsecond, 100*time.Second, 4)
+
+var defaultWindows = []time.Duration{
+	time.Second,
+	time.Minute,
+	5 * time.Minute,
+	15 * time.Minute,
+	time.Hour,
+}
+
 type Server struct {
 	logger *log.Logger
+	clock  clock.Clock
 
-	persistencePath string
+	store store.Store
+	// journal of the counter and of the limiter, see WithJournal
+	journalSync      journal.Sync
+	isJournalEnabled bool
 	// counter
-	counter *counter.Counter
+	counter           counter.Interface
+	counterKind       counter.Kind
+	counterWindow     time.Duration
+	counterResolution uint64
+
+	// handler latency
+	latency *counter.Histogram
+
+	// windows
+	windows      []time.Duration
+	multiCounter *counter.Multi
 
 	// limiter
-	limiter *limiter.Map
-	limit   int64
+	limiter            limiter.Backend
+	limit              int64
+	redis              *resp.Client
+	redisResolution    uint64
+	limiterCounterKind counter.Kind
+	limiterAlgorithm   limiter.Algorithm
+	burst              int64
+	limiterIdleTTL     time.Duration
+	limiterMaxKeys     int
+	// cost of the requests per route, 1 if not specified
+	routeCosts map[string]int64
+
+	// routines started by Start, see Close
+	cancel  context.CancelFunc
+	life    lifecycle.Lifecycle
+	onError func(err error)
 }
 
 func New(opts ...Option) (*Server, error) {
 	s := &Server{
-		persistencePath: defaultPersistenceDir,
-		limit:           defaultLimit,
+		store:   store.NewFS(defaultPersistenceDir),
+		limit:   defaultLimit,
+		windows: defaultWindows,
+		clock:   clock.New(),
 	}
 
 	for _, opt := range opts {
 		opt(s)
 	}
 
+	for path, cost := range s.routeCosts {
+		if cost <= 0 {
+			return nil, fmt.Errorf("cost %d of route %s: %w", cost, path, limiter.ErrInvalidCost)
+		}
+	}
+
 	return s, nil
 }
 
-// Start start the server
+// Start start the server routines, they run until ctx is cancelled or Shutdown is called
 func (s *Server) Start(ctx context.Context) error {
-
-	if s.persistencePath != "" {
-		if err := os.MkdirAll(s.persistencePath, os.ModePerm); err != nil {
-			return err
-		}
-	}
+	ctx, s.cancel = context.WithCancel(ctx)
 
 	s.logger.Printf("building window counter\n")
 	wc, err := s.buildWindowCounter()
 	if err != nil {
-		return fmt.Errorf("building counter: %v", err)
+		return fmt.Errorf("building counter: %w", err)
 	}
 	s.counter = wc
 	s.logger.Printf("window counter built\n")
 
-	go func() {
-		s.logger.Printf("starting window counter\n")
-		if err := wc.Run(ctx); err != nil {
-			panic(err)
+	s.run(ctx, "window counter", wc.Run)
+
+	s.logger.Printf("building latency histogram\n")
+	latency, err := s.buildLatencyHistogram()
+	if err != nil {
+		return fmt.Errorf("building latency histogram: %w", err)
+	}
+	s.latency = latency
+	s.logger.Printf("latency histogram built\n")
+
+	s.run(ctx, "latency histogram", latency.Run)
+
+	if len(s.windows) > 0 {
+		s.logger.Printf("building multi window counter\n")
+		mc, err := s.buildMultiCounter()
+		if err != nil {
+			return fmt.Errorf("building multi window counter: %w", err)
 		}
-	}()
+		s.multiCounter = mc
+		s.logger.Printf("multi window counter built\n")
+
+		s.run(ctx, "multi window counter", mc.Run)
+	}
 
-	if s.limit > 0 {
+	if s.limit > 0 && s.redis != nil {
+		if s.limiterAlgorithm != "" && s.limiterAlgorithm != limiter.AlgorithmWindow {
+			return fmt.Errorf("building redis limiter: algorithm %q not supported, only %q is", s.limiterAlgorithm, limiter.AlgorithmWindow)
+		}
+
+		s.logger.Printf("building redis limiter\n")
+		redisOpts := []limiter.RedisOption{limiter.WithRedisClock(s.clock)}
+		if s.redisResolution > 0 {
+			redisOpts = append(redisOpts, limiter.WithRedisResolution(s.redisResolution))
+		}
+		limiter, err := limiter.NewRedis(s.redis, defaultLimiterWindowsDuration, s.limit, redisOpts...)
+		if err != nil {
+			return fmt.Errorf("building redis limiter: %w", err)
+		}
+		s.limiter = limiter
+		s.logger.Printf("redis limiter built\n")
+	} else if s.limit > 0 {
 		s.logger.Printf("building limiter\n")
 		limiter, err := s.buildLimiter()
 		if err != nil {
-			return fmt.Errorf("building LimiterMap: %v", err)
+			return fmt.Errorf("building LimiterMap: %w", err)
 		}
 		s.limiter = limiter
 		s.logger.Printf("limiter built\n")
 
-		go func() {
-			s.logger.Printf("starting limiter\n")
-			if err := limiter.Run(ctx); err != nil {
-				panic(err)
-			}
-		}()
+		s.run(ctx, "limiter", limiter.Run)
 	}
 
 	return nil
 }
 
-func (s Server) buildWindowCounter() (*counter.Counter, error) {
+// run runs a routine of the server until ctx is cancelled or Close is called.
+// A routine stopping by itself is reported and makes the server degraded, see
+// Health, while an error returned on stop, e.g. failing the final save, is
+// returned by Close.
+func (s *Server) run(ctx context.Context, name string, run func(ctx context.Context) error) {
+	if !s.life.Begin() {
+		return
+	}
 
-	counterFilePath := filepath.Join(s.persistencePath, defaultCounterPersistenceFileName)
+	go func() {
+		s.logger.Printf("starting %s\n", name)
 
-	options := []counter.Option{
-		counter.WithPersistence(counterFilePath, defaultSavePeriod),
+		err := run(ctx)
+		if err != nil {
+			err = fmt.Errorf("%s: %w", name, err)
+		}
+		if ctx.Err() == nil {
+			// nothing asked the routine to stop
+			if err == nil {
+				err = fmt.Errorf("%s stopped unexpectedly", name)
+			}
+			s.life.Fail(err)
+			s.errorHandler(name)(err)
+		}
+
+		s.logger.Printf("%s stopped\n", name)
+		s.life.End(err)
+	}()
+}
+
+// errorHandler returns the handler of the background failures of the component name,
+// which logs them and passes them to the handler set by WithErrorHandler
+func (s *Server) errorHandler(name string) func(err error) {
+	return func(err error) {
+		s.logger.Printf("WARNING: %s: %v\n", name, err)
+		if s.onError != nil {
+			s.onError(err)
+		}
+	}
+}
+
+// Close stops the routines started by Start, which save their state a last time,
+// and waits for them. It must be called once the server does not serve requests
+// anymore, e.g. after http.Server.Shutdown.
+func (s *Server) Close() error {
+	if s.cancel != nil {
+		s.cancel()
 	}
 
-	if _, err := os.Stat(counterFilePath); err != nil {
-		if !errors.Is(err, os.ErrNotExist) {
-			return nil, fmt.Errorf("stating file %s: %v", counterFilePath, err)
+	return s.life.Close()
+}
+
+// Wait waits for the routines started by Start to stop, see Close
+func (s *Server) Wait() error {
+	return s.life.Wait()
+}
+
+// Shutdown is equal to Close, but it gives up waiting for the routines when ctx is done
+func (s *Server) Shutdown(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Close()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for the routines to stop: %w", ctx.Err())
+	}
+}
+
+// Health returns the health of the server: degraded if some component is failing
+// in background, e.g. it can not save its state, while it keeps serving requests
+func (s *Server) Health() Health {
+	components := map[string]interface{}{
+		"window counter":       s.counter,
+		"latency histogram":    s.latency,
+		"multi window counter": s.multiCounter,
+		"limiter":              s.limiter,
+	}
+
+	health := Health{Status: HealthOK}
+	for name, component := range components {
+		failing, ok := component.(interface{ Err() error })
+		// a nil pointer in the interface is a disabled component
+		if !ok || reflect.ValueOf(component).IsNil() {
+			continue
+		}
+		if err := failing.Err(); err != nil {
+			health.add(name, err)
 		}
-		return counter.New(defaultCounterWindowsDuration, defaultCounterResolution, options...)
+	}
+	if err := s.life.Err(); err != nil {
+		health.add("routines", err)
+	}
+
+	if m, ok := s.limiter.(*limiter.Map); ok && m != nil {
+		stats := m.Stats()
+		health.Limiter = &stats
+	}
+
+	return health
+}
+
+// counterConfig returns the window of the requests counter and its number of ticks, see WithCounterWindow
+func (s *Server) counterConfig() (time.Duration, uint64) {
+	window, resolution := s.counterWindow, s.counterResolution
+	if window == 0 {
+		window = defaultCounterWindowsDuration
+	}
+	if resolution == 0 {
+		resolution = defaultCounterResolution
+	}
+	return window, resolution
+}
+
+func (s *Server) buildWindowCounter() (counter.Interface, error) {
+
+	key := defaultCounterPersistenceFileName
+
+	window, resolution := s.counterConfig()
+
+	options := []counter.Option{
+		counter.WithPersistence(s.store, key, defaultSavePeriod),
+		counter.WithClock(s.clock),
+		counter.WithTickless(),
+		counter.WithWindow(window, resolution),
+		counter.WithLogger(s.logger),
+		counter.WithErrorHandler(s.errorHandler("window counter")),
+	}
+
+	if s.isJournalEnabled {
+		options = append(options, counter.WithJournal(s.journalSync, defaultJournalFlushPeriod))
 	}
 
-	return counter.NewFromFile(counterFilePath, options...)
+	var wc counter.Interface
+	restored, err := s.restoreState(key, func(key string) (err error) {
+		wc, err = counter.NewKindFromStore(s.store, key, options...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if restored {
+		r := wc.RestoreReport()
+		s.logger.Printf("window counter restored: %d missed ticks, %d expired and %d discarded increases, clock %v\n",
+			r.MissedTicks, r.Expired, r.Discarded, r.Anomaly)
+		return wc, nil
+	}
+
+	return counter.NewKind(s.counterKind, window, resolution, options...)
 }
 
-func (s Server) buildLimiter() (*limiter.Map, error) {
+func (s *Server) buildLatencyHistogram() (*counter.Histogram, error) {
+
+	key := defaultLatencyPersistenceFileName
+
+	options := []counter.Option{
+		counter.WithPersistence(s.store, key, defaultSavePeriod),
+		counter.WithClock(s.clock),
+		counter.WithTickless(),
+		counter.WithErrorHandler(s.errorHandler("latency histogram")),
+	}
+
+	var h *counter.Histogram
+	restored, err := s.restoreState(key, func(key string) (err error) {
+		h, err = counter.NewHistogramFromStore(s.store, key, options...)
+		return err
+	})
+	if err != nil || restored {
+		return h, err
+	}
+
+	return counter.NewHistogram(defaultLatencyWindowDuration, defaultLatencyResolution, defaultLatencyBounds, options...)
+}
 
-	limiterFilePath := filepath.Join(s.persistencePath, defaultLimiterPersistenceFileName)
+func (s *Server) buildMultiCounter() (*counter.Multi, error) {
+
+	key := defaultMultiPersistenceFileName
+
+	options := []counter.Option{
+		counter.WithPersistence(s.store, key, defaultSavePeriod),
+		counter.WithClock(s.clock),
+		counter.WithTickless(),
+		counter.WithErrorHandler(s.errorHandler("multi window counter")),
+	}
+
+	var mc *counter.Multi
+	restored, err := s.restoreState(key, func(key string) (err error) {
+		mc, err = counter.NewMultiFromStore(s.store, key, options...)
+		return err
+	})
+	if err != nil || restored {
+		return mc, err
+	}
+
+	return counter.NewMulti(defaultWindowsTickPeriod, s.windows, options...)
+}
+
+func (s *Server) buildLimiter() (*limiter.Map, error) {
+
+	key := defaultLimiterPersistenceFileName
 
 	options := []limiter.MapOption{
-		limiter.WithPersistence(limiterFilePath, defaultSavePeriod),
+		limiter.WithPersistence(s.store, key, defaultSavePeriod),
+		limiter.WithMapClock(s.clock),
+		limiter.WithMapLimit(defaultLimiterWindowsDuration, s.limit),
+		limiter.WithMapLogger(s.logger),
+		limiter.WithMapErrorHandler(s.errorHandler("limiter")),
+	}
+
+	if s.limiterCounterKind != "" {
+		options = append(options, limiter.WithLimitersCounterKind(s.limiterCounterKind))
 	}
 
-	if _, err := os.Stat(limiterFilePath); err != nil {
-		if !errors.Is(err, os.ErrNotExist) {
-			return nil, fmt.Errorf("stating file %s: %v", limiterFilePath, err)
+	switch s.limiterAlgorithm {
+	case "", limiter.AlgorithmWindow, limiter.AlgorithmTokenBucket, limiter.AlgorithmGCRA:
+		options = append(options, limiter.WithLimitersAlgorithm(s.limiterAlgorithm))
+	default:
+		return nil, fmt.Errorf("unknown limiter algorithm %q", s.limiterAlgorithm)
+	}
+
+	if s.burst > 0 {
+		options = append(options, limiter.WithLimitersBurst(s.burst))
+	}
+
+	if s.limiterIdleTTL > 0 {
+		options = append(options, limiter.WithIdleTTL(s.limiterIdleTTL))
+	}
+
+	if s.limiterMaxKeys > 0 {
+		options = append(options, limiter.WithMaxKeys(s.limiterMaxKeys))
+	}
+
+	if s.isJournalEnabled {
+		options = append(options, limiter.WithJournal(s.journalSync, defaultJournalFlushPeriod))
+	}
+
+	var m *limiter.Map
+	restored, err := s.restoreState(key, func(key string) (err error) {
+		m, err = limiter.NewFromStore(s.store, key, options...)
+		return err
+	})
+	if err != nil || restored {
+		return m, err
+	}
+
+	return limiter.NewMap(defaultLimiterWindowsDuration, s.limit, options...)
+}
+
+// restoreState calls restore with key, or with the previous snapshot of key if
+// the newest one is corrupt, or with the legacy key of the state if there is none,
+// see legacyStateKeys. It returns false if there is no usable state, in that case
+// the caller starts from a fresh state, which is saved under key.
+//
+// corrupt states are moved aside, if the store is able to, so that the next
+// save does not replace the previous snapshot with them. Any other error, e.g.
+// a state newer than the server or a configuration the state can not be
+// reconciled to, is returned and the state is left as it is.
+func (s *Server) restoreState(key string, restore func(key string) error) (bool, error) {
+	corrupt := false
+
+	keys := []string{key, statefile.PreviousPath(key)}
+	if legacy, ok := legacyStateKeys[key]; ok {
+		keys = append(keys, legacy, statefile.PreviousPath(legacy))
+	}
+
+	for _, k := range keys {
+		err := restore(k)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
 		}
-		return limiter.NewMap(defaultLimiterWindowsDuration, s.limit, options...), nil
+
+		if err != nil && !isCorrupt(err) {
+			return false, fmt.Errorf("restoring %s: %w", k, err)
+		}
+
+		if err != nil {
+			s.logger.Printf("WARNING: restoring %s: %v\n", k, err)
+			corrupt = true
+
+			if r, ok := s.store.(renamer); ok {
+				if err := r.Rename(k, k+corruptSuffix); err != nil {
+					return false, fmt.Errorf("moving aside corrupt state %s: %w", k, err)
+				}
+			}
+			continue
+		}
+
+		switch {
+		case k == legacyStateKeys[key]:
+			s.logger.Printf("%s restored from the legacy state %s\n", key, k)
+		case k != key:
+			s.logger.Printf("WARNING: %s restored from the previous snapshot %s\n", key, k)
+		}
+		return true, nil
 	}
 
-	return limiter.NewFromFile(limiterFilePath, options...)
+	if corrupt {
+		s.logger.Printf("WARNING: no valid state for %s, starting from a fresh one\n", key)
+	}
+
+	return false, nil
+}
+
+// isCorrupt returns true if err is the failure to decode a state, e.g. truncated,
+// inconsistent or with a wrong checksum, as opposed to a state that is valid but can not be used
+func isCorrupt(err error) bool {
+	var (
+		syntaxErr *json.SyntaxError
+		typeErr   *json.UnmarshalTypeError
+	)
+
+	return errors.Is(err, statefile.ErrChecksum) ||
+		errors.Is(err, wire.ErrCorrupt) ||
+		// shared by the counters and the limiters
+		errors.Is(err, counter.ErrInvalidState) ||
+		errors.As(err, &syntaxErr) ||
+		errors.As(err, &typeErr)
+}
+
+// renamer is implemented by the stores able to move a state, e.g. store.FS
+type renamer interface {
+	Rename(from, to string) error
 }
 
 // ServeHTTP responds at each request with a counter of the total number
 // of requests that it has received during the previous 60 seconds
 func (s *Server) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 
+	// health checks are neither limited nor counted
+	if req.URL.Path == defaultHealthPath {
+		s.serveHealth(resp)
+		return
+	}
+
+	if s.latency != nil {
+		start := s.clock.Now()
+		defer func() {
+			s.latency.Observe(s.clock.Now().Sub(start))
+		}()
+	}
+
 	if s.limiter != nil {
-		allowed, err := s.isClientAllowed(req)
+		decision, err := s.decideClient(req)
 		if err != nil {
 			http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
 
-		if !allowed {
+		s.setRateLimitHeaders(resp.Header(), decision)
+
+		if !decision.Allowed {
+			resp.Header().Set("Retry-After", strconv.FormatInt(ceilSeconds(decision.RetryAfter), 10))
 			http.Error(resp, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
 			return
 		}
 	}
 
+	if req.URL.Path == defaultSeriesPath {
+		s.serveSeries(resp, req)
+		return
+	}
+
 	response, err := s.Request()
 	if err != nil {
 		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
@@ -166,25 +574,164 @@ func (s *Server) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 	}
 }
 
-func (s Server) isClientAllowed(r *http.Request) (bool, error) {
+// serveSeries responds with the per tick series of the counter window, the
+// optional query parameter points downsamples the series to at most points
+func (s *Server) serveSeries(resp http.ResponseWriter, req *http.Request) {
+	snapshotter, ok := s.counter.(counter.Snapshotter)
+	if !ok {
+		http.Error(resp, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+		return
+	}
+
+	points := 0
+	if value := req.URL.Query().Get("points"); value != "" {
+		var err error
+		if points, err = strconv.Atoi(value); err != nil || points < 0 {
+			http.Error(resp, "points must be a non negative integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	window, _ := s.counterConfig()
+
+	bytes, err := json.Marshal(SeriesResponse{
+		Window: formatWindow(window),
+		Points: snapshotter.Snapshot(points),
+	})
+	if err != nil {
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	resp.Header().Set("Content-Type", "application/json")
+	if _, err = resp.Write(bytes); err != nil {
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// serveHealth responds with the health of the server, see Health
+func (s *Server) serveHealth(resp http.ResponseWriter) {
+	bytes, err := json.Marshal(s.Health())
+	if err != nil {
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	resp.Header().Set("Content-Type", "application/json")
+	if _, err = resp.Write(bytes); err != nil {
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// decideClient decides whether the request fits under the limit of its client IP
+func (s *Server) decideClient(r *http.Request) (limiter.Decision, error) {
 	ip, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
-		return false, err
+		return limiter.Decision{}, err
 	}
 
-	lim := s.limiter.Get(ip)
-	allowed := lim.IsAllowed()
+	return s.limiter.DecideN(ip, s.requestCost(r))
+}
+
+// setRateLimitHeaders sets the RateLimit headers of the IETF draft on the response:
+// the limit, the units remaining and the seconds until the whole limit is available again
+func (s *Server) setRateLimitHeaders(h http.Header, d limiter.Decision) {
+	h.Set("RateLimit-Limit", strconv.FormatInt(d.Limit, 10))
+	h.Set("RateLimit-Remaining", strconv.FormatInt(d.Remaining, 10))
+	h.Set("RateLimit-Reset", strconv.FormatInt(ceilSeconds(d.ResetAt.Sub(s.clock.Now())), 10))
+}
+
+// ceilSeconds returns d in seconds rounded up, the unit of the rate limit headers
+func ceilSeconds(d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64((d + time.Second - 1) / time.Second)
+}
 
-	return allowed, nil
+// requestCost returns the units of the limit consumed by the request
+func (s *Server) requestCost(r *http.Request) int64 {
+	if cost, ok := s.routeCosts[r.URL.Path]; ok {
+		return cost
+	}
+	return 1
 }
 
 // Request execute the logic of the server i.e. return the number of requests in the last 60s
 func (s *Server) Request() (Response, error) {
-	return Response{
+	response := Response{
 		Counter: s.counter.Increase(),
-	}, nil
+	}
+
+	if s.multiCounter != nil {
+		s.multiCounter.Increase()
+
+		response.Windows = make(map[string]int64)
+		for window, value := range s.multiCounter.Values() {
+			response.Windows[formatWindow(window)] = value
+		}
+	}
+
+	if s.latency != nil {
+		quantiles := s.latency.Quantiles(0.5, 0.95, 0.99)
+		response.Latency = map[string]float64{
+			"p50": durationToMillis(quantiles[0]),
+			"p95": durationToMillis(quantiles[1]),
+			"p99": durationToMillis(quantiles[2]),
+		}
+	}
+
+	return response, nil
+}
+
+func durationToMillis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// formatWindow returns the short form of a window duration e.g. 5m instead of 5m0s
+func formatWindow(d time.Duration) string {
+	str := d.String()
+	if strings.HasSuffix(str, "m0s") {
+		str = strings.TrimSuffix(str, "0s")
+	}
+	if strings.HasSuffix(str, "h0m") {
+		str = strings.TrimSuffix(str, "0m")
+	}
+	return str
 }
 
 type Response struct {
 	Counter int64 `json:"counter"`
+	// Windows is the number of requests in each window e.g. 1s, 1m, 5m
+	Windows map[string]int64 `json:"windows,omitempty"`
+	// Latency is the p50, p95 and p99 of the handler latency in the last minute, in milliseconds
+	Latency map[string]float64 `json:"latency_ms,omitempty"`
+}
+
+// Health statuses
+const (
+	HealthOK       = "ok"
+	HealthDegraded = "degraded"
+)
+
+// Health is the health of the server, Errors are the failures of its degraded components.
+// Limiter are the keys of the in memory per IP limiter and their evictions.
+type Health struct {
+	Status  string            `json:"status"`
+	Errors  map[string]string `json:"errors,omitempty"`
+	Limiter *limiter.MapStats `json:"limiter,omitempty"`
+}
+
+func (h *Health) add(component string, err error) {
+	h.Status = HealthDegraded
+	if h.Errors == nil {
+		h.Errors = make(map[string]string)
+	}
+	h.Errors[component] = err.Error()
+}
+
+// SeriesResponse is the series of the requests counted in the window
+type SeriesResponse struct {
+	Window string          `json:"window"`
+	Points []counter.Point `json:"points"`
 }
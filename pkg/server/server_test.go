@@ -3,27 +3,44 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/clock"
 	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/counter"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/limiter"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/resp"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/resp/resptest"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/statefile"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/store"
+	"github.com/stiflerGit/simpleinsurance-assessment/pkg/rate/wire"
 )
 
 func TestServer(t *testing.T) {
 	s := &Server{
-		counter:         counter.Must(time.Second, 10),
-		logger:          log.Default(),
-		persistencePath: defaultCounterPersistenceFileName,
+		counter: counter.Must(time.Second, 10),
+		logger:  log.Default(),
+		clock:   clock.NewFake(time.Now()),
+		store:   store.NewFS(t.TempDir()),
 	}
 
-	if err := s.Start(context.TODO()); err != nil {
+	ctx, cancelFunc := context.WithCancel(context.TODO())
+	defer cancelFunc()
+
+	if err := s.Start(ctx); err != nil {
 		t.Fatal(err)
 	}
+	defer s.Close()
 
 	ts := httptest.NewServer(s)
 	defer ts.Close()
@@ -63,3 +80,799 @@ func TestServer(t *testing.T) {
 	}
 
 }
+
+func TestServer_RouteCost(t *testing.T) {
+	s, err := New(
+		WithLogger(log.Default()),
+		WithClock(clock.NewFake(time.Now())),
+		WithPersistence(t.TempDir()),
+		WithPerIPRequestLimiter(5),
+		WithRouteCost("/export", 3),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancelFunc := context.WithCancel(context.TODO())
+	defer cancelFunc()
+
+	if err := s.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{path: "/export", want: http.StatusOK},
+		{path: "/export", want: http.StatusTooManyRequests},
+		{path: "/", want: http.StatusOK},
+		{path: "/", want: http.StatusOK},
+		{path: "/", want: http.StatusTooManyRequests},
+	}
+
+	for i, tt := range tests {
+		res, err := http.Get(ts.URL + tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != tt.want {
+			t.Errorf("at request %d to %s: status = %d, want %d", i, tt.path, res.StatusCode, tt.want)
+		}
+	}
+}
+
+func TestNew_InvalidRouteCost(t *testing.T) {
+	for _, cost := range []int64{0, -1} {
+		if _, err := New(WithRouteCost("/export", cost)); !errors.Is(err, limiter.ErrInvalidCost) {
+			t.Errorf("New() with cost %d: error = %v, want %v", cost, err, limiter.ErrInvalidCost)
+		}
+	}
+}
+
+func TestServer_Windows(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+
+	s, err := New(
+		WithLogger(log.Default()),
+		WithClock(clk),
+		WithPersistence(t.TempDir()),
+		WithWindows(100*time.Millisecond, time.Minute),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancelFunc := context.WithCancel(context.TODO())
+	defer cancelFunc()
+
+	if err := s.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	get := func() Response {
+		res, err := http.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		response := Response{}
+		if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	for i := 0; i < 3; i++ {
+		get()
+	}
+
+	// less than the save period, to not trigger the persistence
+	clk.Advance(100 * time.Millisecond)
+
+	want := map[string]int64{"100ms": 1, "1m": 4}
+	if got := get().Windows; !reflect.DeepEqual(got, want) {
+		t.Errorf("Windows = %v, want %v", got, want)
+	}
+}
+
+func TestServer_Latency(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+
+	s, err := New(
+		WithLogger(log.Default()),
+		WithClock(clk),
+		WithPersistence(t.TempDir()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancelFunc := context.WithCancel(context.TODO())
+	defer cancelFunc()
+
+	if err := s.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	for i := 0; i < 3; i++ {
+		res, err := http.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+
+	if got := s.latency.Count(); got != 3 {
+		t.Errorf("latency Count() = %d, want 3", got)
+	}
+}
+
+func TestServer_Series(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+
+	s, err := New(
+		WithLogger(log.Default()),
+		WithClock(clk),
+		WithPersistence(t.TempDir()),
+		WithCounterWindow(2*time.Minute, 1000),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancelFunc := context.WithCancel(context.TODO())
+	defer cancelFunc()
+
+	if err := s.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	for i := 0; i < 3; i++ {
+		res, err := http.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+
+	res, err := http.Get(ts.URL + defaultSeriesPath + "?points=60")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	series := SeriesResponse{}
+	if err := json.NewDecoder(res.Body).Decode(&series); err != nil {
+		t.Fatal(err)
+	}
+
+	if series.Window != "2m" {
+		t.Errorf("Window = %q, want 2m", series.Window)
+	}
+	if len(series.Points) != 60 {
+		t.Fatalf("len(Points) = %d, want 60", len(series.Points))
+	}
+	// the series request is not counted
+	if got := series.Points[59].Count; got != 3 {
+		t.Errorf("last point Count = %d, want 3", got)
+	}
+
+	res, err = http.Get(ts.URL + defaultSeriesPath + "?points=abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid points status = %d, want %d", res.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestServer_CorruptState(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	dir := t.TempDir()
+	counterFilePath := filepath.Join(dir, defaultCounterPersistenceFileName)
+
+	s, err := New(WithLogger(log.Default()), WithClock(clk), WithPersistence(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := counter.Must(defaultCounterWindowsDuration, defaultCounterResolution, counter.WithClock(clk), counter.WithTickless())
+	c.IncreaseBy(3)
+	state, err := c.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the newest state is valid for the checksum, but it can not be decoded
+	if err := statefile.Write(counterFilePath, state); err != nil {
+		t.Fatal(err)
+	}
+	if err := statefile.Write(counterFilePath, state[:len(state)/2]); err != nil {
+		t.Fatal(err)
+	}
+
+	wc, err := s.buildWindowCounter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := wc.Value(); got != 3 {
+		t.Errorf("restored from the previous snapshot Value() = %d, want 3", got)
+	}
+	if _, err := os.Stat(counterFilePath + corruptSuffix); err != nil {
+		t.Errorf("corrupt file not moved aside: %v", err)
+	}
+
+	// the previous snapshot is corrupt too
+	if err := ioutil.WriteFile(statefile.PreviousPath(counterFilePath), state[:len(state)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	wc, err = s.buildWindowCounter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := wc.Value(); got != 0 {
+		t.Errorf("fresh Value() = %d, want 0", got)
+	}
+}
+
+func TestServer_NewerState(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	dir := t.TempDir()
+	counterFilePath := filepath.Join(dir, defaultCounterPersistenceFileName)
+
+	s, err := New(WithLogger(log.Default()), WithClock(clk), WithPersistence(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := counter.Must(defaultCounterWindowsDuration, defaultCounterResolution, counter.WithClock(clk), counter.WithTickless())
+	state, err := c.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a state written by a newer server
+	state[4] = wire.Version + 1
+	if err := statefile.Write(counterFilePath, state); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.buildWindowCounter(); !errors.Is(err, wire.ErrUnsupportedVersion) {
+		t.Errorf("buildWindowCounter() error = %v, want %v", err, wire.ErrUnsupportedVersion)
+	}
+	if _, err := os.Stat(counterFilePath); err != nil {
+		t.Errorf("newer state moved aside: %v", err)
+	}
+}
+
+func TestServer_CorruptLimiterState(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	dir := t.TempDir()
+	limiterFilePath := filepath.Join(dir, defaultLimiterPersistenceFileName)
+
+	s, err := New(WithLogger(log.Default()), WithClock(clk), WithPersistence(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := limiter.MustMap(defaultLimiterWindowsDuration, 15, limiter.WithLimitersAlgorithm(limiter.AlgorithmGCRA))
+	if _, err := m.AllowN("127.0.0.1", 1); err != nil {
+		t.Fatal(err)
+	}
+	valid, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a gcra limiter without window passes the checksum, but not the validation
+	mJSON := limiter.MapJSON{}
+	if err := json.Unmarshal(valid, &mJSON); err != nil {
+		t.Fatal(err)
+	}
+	gJSON := limiter.GCRAJSON{}
+	if err := json.Unmarshal(mJSON.KeyToLimiter["127.0.0.1"], &gJSON); err != nil {
+		t.Fatal(err)
+	}
+	gJSON.Window = 0
+	if mJSON.KeyToLimiter["127.0.0.1"], err = json.Marshal(gJSON); err != nil {
+		t.Fatal(err)
+	}
+	state, err := json.Marshal(mJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := statefile.Write(limiterFilePath, state); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := s.buildLimiter()
+	if err != nil {
+		t.Fatalf("buildLimiter() error = %v, want a fresh limiter", err)
+	}
+	defer restored.Close()
+
+	if got := restored.Stats().Keys; got != 0 {
+		t.Errorf("%d keys, want a fresh limiter", got)
+	}
+	if _, err := os.Stat(limiterFilePath + corruptSuffix); err != nil {
+		t.Errorf("corrupt file not moved aside: %v", err)
+	}
+}
+
+func TestServer_Store(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	states := store.NewMemory()
+
+	s, err := New(WithLogger(log.Default()), WithClock(clk), WithStore(states))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := counter.Must(defaultCounterWindowsDuration, defaultCounterResolution, counter.WithClock(clk), counter.WithTickless())
+	c.IncreaseBy(3)
+	state, err := c.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := states.Save(defaultCounterPersistenceFileName, state); err != nil {
+		t.Fatal(err)
+	}
+
+	wc, err := s.buildWindowCounter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := wc.Value(); got != 3 {
+		t.Errorf("restored Value() = %d, want 3", got)
+	}
+
+	// a corrupt state can not be moved aside, the counter starts from a fresh one
+	if err := states.Save(defaultCounterPersistenceFileName, state[:len(state)/2]); err != nil {
+		t.Fatal(err)
+	}
+	if wc, err = s.buildWindowCounter(); err != nil {
+		t.Fatal(err)
+	}
+	if got := wc.Value(); got != 0 {
+		t.Errorf("fresh Value() = %d, want 0", got)
+	}
+}
+
+func TestServer_LegacyState(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	states := store.NewMemory()
+
+	s, err := New(WithLogger(log.Default()), WithClock(clk), WithStore(states))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// saved as JSON by a previous version
+	c := counter.Must(defaultCounterWindowsDuration, defaultCounterResolution, counter.WithClock(clk), counter.WithTickless())
+	c.IncreaseBy(3)
+	state, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := states.Save(legacyStateKeys[defaultCounterPersistenceFileName], state); err != nil {
+		t.Fatal(err)
+	}
+
+	wc, err := s.buildWindowCounter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := wc.Value(); got != 3 {
+		t.Errorf("restored Value() = %d, want 3", got)
+	}
+}
+
+func TestServer_RedisLimiter(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+
+	redis, err := resptest.NewServer(clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer redis.Close()
+
+	ctx, cancelFunc := context.WithCancel(context.TODO())
+	defer cancelFunc()
+
+	// two instances sharing the limit of each IP
+	var urls []string
+	for i := 0; i < 2; i++ {
+		client := resp.NewClient(redis.Addr())
+		defer client.Close()
+
+		s, err := New(
+			WithLogger(log.Default()),
+			WithClock(clk),
+			WithStore(store.NewMemory()),
+			WithPerIPRequestLimiter(5),
+			WithRedisLimiter(client),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Start(ctx); err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+
+		ts := httptest.NewServer(s)
+		defer ts.Close()
+		urls = append(urls, ts.URL)
+	}
+
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		res, err := http.Get(urls[i%len(urls)])
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode == http.StatusOK {
+			allowed++
+		}
+	}
+
+	if allowed != 5 {
+		t.Errorf("allowed %d requests over the instances, want 5", allowed)
+	}
+}
+
+func TestServer_TokenBucket(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+
+	s, err := New(
+		WithLogger(log.Default()),
+		WithClock(clk),
+		WithStore(store.NewMemory()),
+		WithPerIPRequestLimiter(5),
+		WithLimiterAlgorithm(limiter.AlgorithmTokenBucket),
+		WithLimiterBurst(2),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancelFunc := context.WithCancel(context.TODO())
+	defer cancelFunc()
+
+	if err := s.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	// a burst of 2, then a request each 4s, 5 in the 20s window
+	steps := []struct {
+		advance time.Duration
+		want    int
+	}{
+		{want: http.StatusOK},
+		{want: http.StatusOK},
+		{want: http.StatusTooManyRequests},
+		{advance: 4 * time.Second, want: http.StatusOK},
+		{want: http.StatusTooManyRequests},
+	}
+	for i, step := range steps {
+		clk.Advance(step.advance)
+
+		res, err := http.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != step.want {
+			t.Errorf("request %d status = %d, want %d", i, res.StatusCode, step.want)
+		}
+	}
+
+	// the redis limiter supports only the window
+	redis, err := New(
+		WithLogger(log.Default()),
+		WithClock(clk),
+		WithStore(store.NewMemory()),
+		WithRedisLimiter(resp.NewClient("127.0.0.1:0")),
+		WithLimiterAlgorithm(limiter.AlgorithmTokenBucket),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := redis.Start(ctx); err == nil {
+		t.Error("Start() of a redis token bucket limiter, want error")
+	}
+	redis.Close()
+
+	// the buckets of the redis limiter are at least 1ms long
+	redis, err = New(
+		WithLogger(log.Default()),
+		WithClock(clk),
+		WithStore(store.NewMemory()),
+		WithRedisLimiter(resp.NewClient("127.0.0.1:0")),
+		WithRedisResolution(uint64(defaultLimiterWindowsDuration/time.Microsecond)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := redis.Start(ctx); err == nil {
+		t.Error("Start() of a redis limiter with buckets of 1us, want error")
+	}
+	redis.Close()
+}
+
+func TestServer_RateLimitHeaders(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+
+	s, err := New(
+		WithLogger(log.Default()),
+		WithClock(clk),
+		WithStore(store.NewMemory()),
+		WithPerIPRequestLimiter(2),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancelFunc := context.WithCancel(context.TODO())
+	defer cancelFunc()
+
+	if err := s.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	// 2 requests per 20s window
+	steps := []struct {
+		advance    time.Duration
+		status     int
+		remaining  string
+		reset      string
+		retryAfter string
+	}{
+		{status: http.StatusOK, remaining: "1", reset: "20"},
+		{advance: 5 * time.Second, status: http.StatusOK, remaining: "0", reset: "20"},
+		// the first request leaves the window 15s later
+		{status: http.StatusTooManyRequests, remaining: "0", reset: "20", retryAfter: "15"},
+	}
+	for i, step := range steps {
+		clk.Advance(step.advance)
+
+		res, err := http.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != step.status {
+			t.Errorf("request %d status = %d, want %d", i, res.StatusCode, step.status)
+		}
+		for header, want := range map[string]string{
+			"RateLimit-Limit":     "2",
+			"RateLimit-Remaining": step.remaining,
+			"RateLimit-Reset":     step.reset,
+			"Retry-After":         step.retryAfter,
+		} {
+			if got := res.Header.Get(header); got != want {
+				t.Errorf("request %d %s = %q, want %q", i, header, got, want)
+			}
+		}
+	}
+}
+
+func TestServer_LimiterMaxKeys(t *testing.T) {
+	s, err := New(
+		WithLogger(log.Default()),
+		WithClock(clock.NewFake(time.Now())),
+		WithStore(store.NewMemory()),
+		WithPerIPRequestLimiter(1),
+		WithLimiterMaxKeys(1),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancelFunc := context.WithCancel(context.TODO())
+	defer cancelFunc()
+
+	if err := s.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// each IP evicts the limiter of the other one, so it is never limited
+	for i, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.1"} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("request %d from %s status = %d, want %d", i, ip, rec.Code, http.StatusOK)
+		}
+	}
+
+	want := limiter.MapStats{Keys: 1, LRUEvictions: 2}
+	if got := s.Health().Limiter; got == nil || *got != want {
+		t.Errorf("Health().Limiter = %+v, want %+v", got, want)
+	}
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	states := store.NewMemory()
+
+	s, err := New(WithLogger(log.Default()), WithClock(clk), WithStore(states), WithPerIPRequestLimiter(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(s)
+	for i := 0; i < 3; i++ {
+		res, err := http.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+	ts.Close()
+
+	// the save period never elapsed, the states are saved on shutdown
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFunc()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	wc, err := counter.NewKindFromStore(states, defaultCounterPersistenceFileName, counter.WithClock(clk), counter.WithTickless())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := wc.Value(); got != 3 {
+		t.Errorf("saved counter Value() = %d, want 3", got)
+	}
+
+	m, err := limiter.NewFromStore(states, defaultLimiterPersistenceFileName, limiter.WithMapClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 3 of the 10 units of the client are used
+	if allowed, _ := m.AllowN("127.0.0.1", 8); allowed {
+		t.Error("saved limiter allowed 8 more units, want at most 7")
+	}
+	if allowed, _ := m.AllowN("127.0.0.1", 7); !allowed {
+		t.Error("saved limiter denied 7 more units, want allowed")
+	}
+}
+
+// failingStore fails the saves while failing is set
+type failingStore struct {
+	store.Store
+	m       sync.Mutex
+	failing bool
+}
+
+func (s *failingStore) Save(key string, data []byte) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.failing {
+		return errors.New("disk full")
+	}
+	return s.Store.Save(key, data)
+}
+
+func (s *failingStore) setFailing(failing bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.failing = failing
+}
+
+func TestServer_Health(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	states := &failingStore{Store: store.NewMemory(), failing: true}
+
+	failures := make(chan error, 16)
+	s, err := New(
+		WithLogger(log.Default()),
+		WithClock(clk),
+		WithStore(states),
+		WithErrorHandler(func(err error) { failures <- err }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	getHealth := func() Health {
+		t.Helper()
+		res, err := http.Get(ts.URL + defaultHealthPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		var health Health
+		if err := json.NewDecoder(res.Body).Decode(&health); err != nil {
+			t.Fatal(err)
+		}
+		return health
+	}
+
+	if health := getHealth(); health.Status != HealthOK {
+		t.Errorf("health = %+v, want ok", health)
+	}
+
+	// the save tickers of the counter, the histogram, the multi counter and the limiter
+	clk.BlockUntil(4)
+	clk.Advance(defaultSavePeriod)
+	for i := 0; i < 4; i++ {
+		<-failures
+	}
+
+	// the server keeps serving while it can not save
+	health := getHealth()
+	if health.Status != HealthDegraded || len(health.Errors) != 4 {
+		t.Errorf("health with failing saves = %+v, want 4 degraded components", health)
+	}
+
+	// the saves are retried after a backoff
+	states.setFailing(false)
+	clk.Advance(time.Second)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Health().Status != HealthOK {
+		if time.Now().After(deadline) {
+			t.Fatalf("health after the retries = %+v, want ok", s.Health())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// health checks are not counted
+	response, err := s.Request()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Counter != 1 {
+		t.Errorf("Counter = %d, want 1", response.Counter)
+	}
+}